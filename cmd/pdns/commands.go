@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"main/internal/pdns"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// exit codes of the one-shot commands
+const (
+	exitOK        = 0 // success
+	exitFailed    = 1 // invalid configuration or another error
+	exitUsage     = 2 // invalid command line
+	exitGroupDown = 3 // probe: at least one group is down
+
+	exitConfig          = 4 // run: invalid configuration
+	exitStartup         = 5 // run: the pid file, log outputs, state file or listen addresses could not be opened
+	exitServer          = 6 // run: an HTTP server failed while running
+	exitShutdownTimeout = 7 // run: running checks or requests did not finish within shutdownTimeout
+)
+
+// quietLogs hides the exporter logs of one-shot commands unless verbose is set;
+// errors are printed by the commands themselves
+func quietLogs(verbose bool) {
+	if !verbose {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+		return
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+}
+
+// checkConfig validates the configuration file and prints all errors found
+func checkConfig(args []string) int {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	config := addConfigFlags(flags)
+	printConfig := addPrintConfigFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		// the file may also be given as an argument: check-config config.json
+		config.path = flags.Arg(0)
+	}
+	if *printConfig {
+		return printEffectiveConfig(config)
+	}
+	quietLogs(false)
+
+	conf, err := config.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: configuration is invalid:\n%s\n", config.path, err)
+		return exitFailed
+	}
+	servers := 0
+	for _, group := range conf.GroupsDNS {
+		servers += len(group.DNSServers)
+	}
+	fmt.Printf("%s: configuration is valid (%d groups, %d servers)\n", config.path, len(conf.GroupsDNS), servers)
+	return exitOK
+}
+
+// addPrintConfigFlag registers the -print-effective-config flag in flags
+func addPrintConfigFlag(flags *flag.FlagSet) *bool {
+	return flags.Bool("print-effective-config", false, "print the configuration with defaults, environment variables and flags applied (secrets redacted) and exit")
+}
+
+// printEffectiveConfig prints the merged configuration as JSON
+func printEffectiveConfig(config *configFlags) int {
+	quietLogs(false)
+	conf, err := config.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: configuration is invalid:\n%s\n", config.path, err)
+		return exitFailed
+	}
+	if err := pdns.WriteEffectiveConfig(os.Stdout, conf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// probe checks all groups once and prints the results as a table or JSON
+func probe(args []string) int {
+	flags := flag.NewFlagSet("probe", flag.ExitOnError)
+	config := addConfigFlags(flags)
+	format := flags.String("o", pdns.ReportFormatTable, "output format: table or json")
+	group := flags.String("group", "", "check only the group with this name")
+	timeout := flags.Duration("timeout", time.Minute, "maximum duration of the whole check")
+	verbose := flags.Bool("v", false, "print debug logs to stderr")
+	flags.Parse(args)
+	if *format != pdns.ReportFormatTable && *format != pdns.ReportFormatJSON {
+		fmt.Fprintf(os.Stderr, "unknown output format %q (expected table or json)\n", *format)
+		return exitUsage
+	}
+	quietLogs(*verbose)
+
+	conf, err := config.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: configuration is invalid:\n%s\n", config.path, err)
+		return exitFailed
+	}
+	groups := conf.GroupsDNS
+	if *group != "" {
+		groups = nil
+		for _, candidate := range conf.GroupsDNS {
+			if candidate.GroupName == *group {
+				groups = append(groups, candidate)
+			}
+		}
+		if len(groups) == 0 {
+			fmt.Fprintf(os.Stderr, "group %q not found in %s\n", *group, config.path)
+			return exitFailed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	results := pdns.CheckAvailabilityDns(ctx, groups, conf.ProbeWorkers)
+	if err := pdns.WriteReport(os.Stdout, results, time.Now(), *format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	for _, result := range results {
+		if result.State == pdns.GroupStateDown {
+			return exitGroupDown
+		}
+	}
+	return exitOK
+}
+
+// printVersion prints the version and build information
+func printVersion(w io.Writer) {
+	revision, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && revision == "":
+				revision = setting.Value
+			case setting.Key == "vcs.time" && date == "":
+				date = setting.Value
+			}
+		}
+	}
+	fmt.Fprintf(w, "dns-group-monitor %s\n", version)
+	if revision != "" {
+		fmt.Fprintf(w, "  commit:     %s\n", revision)
+	}
+	if date != "" {
+		fmt.Fprintf(w, "  build date: %s\n", date)
+	}
+	fmt.Fprintf(w, "  go version: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -1,65 +1,191 @@
-package main
-
-import (
-	"fmt"
-	"log"
-	"main/internal/pdns"
-	"os"
-	"path/filepath"
-	"strings"
-)
-
-// build var
-var desiredPathPid string
-
-// PIDFile stored the process id
-type PIDFile struct {
-	path string
-}
-
-// just suit for linux
-func processExists(pid string) bool {
-	if _, err := os.Stat(filepath.Join("/proc", pid)); err == nil {
-		return true
-	}
-	return false
-}
-
-func checkPIDFILEAlreadyExists(path string) error {
-	if pidByte, err := os.ReadFile(path); err == nil {
-		pid := strings.TrimSpace(string(pidByte))
-		if processExists(pid) {
-			return fmt.Errorf("ensure the process:%s is not running pid file:%s", pid, path)
-		}
-	}
-	return nil
-}
-
-// NewPIDFile create the pid file
-func newPIDFile(path string) (*PIDFile, error) {
-	if err := checkPIDFILEAlreadyExists(path); err != nil {
-		return nil, err
-	}
-
-	if err := os.WriteFile(path, []byte(fmt.Sprintf("%d", os.Getpid())), 0644); err != nil {
-		return nil, err
-	}
-	return &PIDFile{path: path}, nil
-}
-
-// Remove the pid file
-func (file PIDFile) removePid() error {
-	return os.Remove(file.path)
-}
-
-func main() {
-	pid, errPid := newPIDFile(desiredPathPid)
-	if errPid != nil {
-		log.Fatal("It is not possible to create a pid file: ", errPid)
-	}
-	defer pid.removePid()
-	err := pdns.Run()
-	if err != nil {
-		log.Fatal("FATAL ERROR")
-	}
-}
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"main/internal/pdns"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// build vars
+var (
+	desiredPathPid string
+	version        = "dev" // release version, set with -ldflags "-X main.version=..."
+	commit         string  // VCS revision, taken from the build info if not set
+	buildDate      string  // build date
+)
+
+// PIDFile stored the process id
+type PIDFile struct {
+	path string
+}
+
+// just suit for linux
+func processExists(pid string) bool {
+	if _, err := os.Stat(filepath.Join("/proc", pid)); err == nil {
+		return true
+	}
+	return false
+}
+
+func checkPIDFILEAlreadyExists(path string) error {
+	if pidByte, err := os.ReadFile(path); err == nil {
+		pid := strings.TrimSpace(string(pidByte))
+		if processExists(pid) {
+			return fmt.Errorf("ensure the process:%s is not running pid file:%s", pid, path)
+		}
+	}
+	return nil
+}
+
+// NewPIDFile create the pid file
+func newPIDFile(path string) (*PIDFile, error) {
+	if err := checkPIDFILEAlreadyExists(path); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("%d", os.Getpid())), 0644); err != nil {
+		return nil, err
+	}
+	return &PIDFile{path: path}, nil
+}
+
+// Remove the pid file
+func (file PIDFile) removePid() error {
+	return os.Remove(file.path)
+}
+
+const usage = `Usage: dns-group-monitor [command] [flags]
+
+Commands:
+  run           start the exporter (default when no command is given)
+  check-config  validate the configuration file and exit
+  probe         check all groups once and print the results
+  version       print build information
+
+Run "dns-group-monitor <command> -h" for the flags of a command.
+`
+
+func main() {
+	// Without a command the exporter is started, so "dns-group-monitor -c config.json" keeps working
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "run":
+		os.Exit(runDaemon(args))
+	case "check-config":
+		os.Exit(checkConfig(args))
+	case "probe":
+		os.Exit(probe(args))
+	case "version":
+		printVersion(os.Stdout)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(exitUsage)
+	}
+}
+
+// configFlags selects the configuration file of a command and the settings overridden on the command line
+type configFlags struct {
+	path      string                // path to the config file
+	format    string                // config file format, empty to detect it by the file extension
+	overrides []pdns.ConfigOverride // settings given as flags, in command line order
+}
+
+// addConfigFlags registers the -c and -format flags and a flag for every setting that can be overridden.
+// The config file and its format may also be set with DGM_CONFIG and DGM_CONFIG_FORMAT.
+func addConfigFlags(flags *flag.FlagSet) *configFlags {
+	config := &configFlags{}
+	flags.StringVar(&config.path, "c", envOr("DGM_CONFIG", pdns.DefaultConfigPath), "path to config file (env DGM_CONFIG)")
+	flags.StringVar(&config.format, "format", os.Getenv("DGM_CONFIG_FORMAT"), "config file format: json, yaml or toml (default: detected by the file extension; env DGM_CONFIG_FORMAT)")
+	for _, setting := range pdns.ConfigSettings {
+		override := func(value string) error {
+			config.overrides = append(config.overrides, pdns.ConfigOverride{Source: "flag -" + setting.Flag, Path: setting.Path, Value: value, Default: setting.Default})
+			return nil
+		}
+		usage := fmt.Sprintf("%s (overrides %s; env %s)", setting.Usage, setting.Path, setting.Env)
+		if setting.Default {
+			usage = fmt.Sprintf("%s (used when %s is not set in the config file; env %s)", setting.Usage, setting.Path, setting.Env)
+		}
+		if setting.IsBool() {
+			flags.BoolFunc(setting.Flag, usage, override)
+		} else {
+			flags.Func(setting.Flag, usage, override)
+		}
+	}
+	return config
+}
+
+// envOr returns the environment variable name or fallback if it is not set
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// source returns the configuration layers: the file, then environment variables, then flags
+func (config *configFlags) source() pdns.ConfigSource {
+	return pdns.ConfigSource{
+		Path:      config.path,
+		Format:    config.format,
+		Overrides: append(pdns.EnvOverrides(), config.overrides...),
+	}
+}
+
+// load reads and validates the configuration file and applies the overrides
+func (config *configFlags) load() (*pdns.Config, error) {
+	return pdns.LoadConfig(config.source())
+}
+
+// runDaemon starts the exporter and returns the exit code; the pid file is written only for the daemon
+// and removed however the exporter stops
+func runDaemon(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	config := addConfigFlags(flags)
+	printConfig := addPrintConfigFlag(flags)
+	flags.Parse(args)
+	if *printConfig {
+		return printEffectiveConfig(config)
+	}
+
+	pid, errPid := newPIDFile(desiredPathPid)
+	if errPid != nil {
+		log.Print("It is not possible to create a pid file: ", errPid)
+		return exitStartup
+	}
+	err := pdns.Run(config.source())
+	if errRemove := pid.removePid(); errRemove != nil {
+		log.Print("It is not possible to remove the pid file: ", errRemove)
+	}
+	if err != nil {
+		log.Print("FATAL ERROR: ", err)
+	}
+	return exitCode(err)
+}
+
+// exitCode maps the error returned by pdns.Run to the exit code of the daemon
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, pdns.ErrConfig):
+		return exitConfig
+	case errors.Is(err, pdns.ErrStartup):
+		return exitStartup
+	case errors.Is(err, pdns.ErrServer):
+		return exitServer
+	case errors.Is(err, pdns.ErrShutdownTimeout):
+		return exitShutdownTimeout
+	default:
+		return exitFailed
+	}
+}
@@ -0,0 +1,153 @@
+package pdns
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// APIPrefix - префикс путей JSON API состояния групп и серверов
+const APIPrefix = "/api/v1"
+
+// apiGroup - состояние группы в ответе JSON API
+type apiGroup struct {
+	Name               string      `json:"name"`               // Имя группы
+	State              GroupState  `json:"state"`              // Состояние группы по политике кворума
+	CheckedAt          time.Time   `json:"checkedAt"`          // Время последней проверки
+	AllServers         int         `json:"allServers"`         // Количество серверов в группе
+	AvailableServers   int         `json:"availableServers"`   // Количество доступных серверов
+	UnavailableServers int         `json:"unavailableServers"` // Количество недоступных серверов
+	MaintenanceServers int         `json:"maintenanceServers"` // Количество серверов на обслуживании
+	AvailableWeight    int         `json:"availableWeight"`    // Суммарный вес доступных серверов
+	Servers            []apiServer `json:"servers"`            // Состояние серверов группы
+}
+
+// apiServer - состояние сервера в ответе JSON API
+type apiServer struct {
+	ID            string     `json:"id"`                      // Идентификатор сервера
+	Group         string     `json:"group"`                   // Имя группы сервера
+	Address       string     `json:"address"`                 // Адрес сервера
+	Port          int        `json:"port"`                    // Порт сервера
+	Transport     string     `json:"transport"`               // Транспорт запроса
+	Record        string     `json:"record"`                  // Запрашиваемая запись
+	QueryType     string     `json:"queryType"`               // Тип запроса
+	QueryClass    string     `json:"queryClass"`              // Класс запроса
+	Status        string     `json:"status"`                  // Результат последней проверки или "maintenance"
+	Up            bool       `json:"up"`                      // Сервер прошел последнюю проверку
+	Maintenance   bool       `json:"maintenance"`             // Сервер на обслуживании
+	RttSeconds    float64    `json:"rttSeconds"`              // Время отклика последнего запроса в секундах
+	Rcode         string     `json:"rcode"`                   // Код ответа последнего запроса ("none", если ответа нет)
+	Attempts      int        `json:"attempts"`                // Количество попыток последнего запроса
+	FailureReason string     `json:"failureReason,omitempty"` // Причина недоступности по последней проверке
+	FailureDetail string     `json:"failureDetail,omitempty"` // Подробности причины недоступности
+	LastSuccess   *time.Time `json:"lastSuccess,omitempty"`   // Время последней успешной проверки
+	LastError     *apiError  `json:"lastError,omitempty"`     // Последняя ошибка
+}
+
+// apiError - последняя ошибка проверки сервера
+type apiError struct {
+	Time   time.Time `json:"time"`   // Время проверки
+	Reason string    `json:"reason"` // Причина ошибки
+	Detail string    `json:"detail"` // Подробности ошибки
+}
+
+// newAPIGroup формирует ответ API по последнему результату проверки группы
+func newAPIGroup(group GroupSnapshot) apiGroup {
+	result := group.Result
+	servers := make([]apiServer, 0, len(result.Responses))
+	for _, resp := range result.Responses {
+		servers = append(servers, newAPIServer(result.GroupName, resp, group.History[resp.ServerID]))
+	}
+	return apiGroup{
+		Name:               result.GroupName,
+		State:              result.State,
+		CheckedAt:          group.CheckedAt,
+		AllServers:         result.AllServers,
+		AvailableServers:   result.AvailabileServers,
+		UnavailableServers: result.UnavailableServers,
+		MaintenanceServers: result.MaintenanceServers,
+		AvailableWeight:    result.AvailableWeight,
+		Servers:            servers,
+	}
+}
+
+// newAPIServer формирует ответ API по результату проверки сервера и его истории
+func newAPIServer(groupName string, resp DnsResponseData, history ServerHistory) apiServer {
+	server := apiServer{
+		ID:            resp.ServerID,
+		Group:         groupName,
+		Address:       resp.Request.Address,
+		Port:          int(resp.Request.Port),
+		Transport:     resp.Request.Transport,
+		Record:        resp.Request.Fqdn,
+		QueryType:     dns.TypeToString[resp.Request.QueryType],
+		QueryClass:    dns.ClassToString[resp.Request.QueryClass],
+		Status:        string(resp.Status),
+		Up:            resp.Availability,
+		Maintenance:   resp.Maintenance,
+		RttSeconds:    resp.TimeToResponse.Seconds(),
+		Rcode:         rcodeName(resp.Rcode),
+		Attempts:      resp.Attempts,
+		FailureReason: resp.FailureReason,
+		FailureDetail: resp.FailureDetail,
+	}
+	if resp.Maintenance {
+		server.Status = "maintenance"
+	}
+	if !history.LastSuccess.IsZero() {
+		server.LastSuccess = &history.LastSuccess
+	}
+	if !history.LastError.IsZero() {
+		server.LastError = &apiError{Time: history.LastError, Reason: history.LastErrorReason, Detail: history.LastErrorDetail}
+	}
+	return server
+}
+
+// RegisterAPI регистрирует обработчики JSON API в mux:
+//   - GET /api/v1/groups - состояние всех групп;
+//   - GET /api/v1/groups/{name} - состояние группы;
+//   - GET /api/v1/servers/{id} - состояние сервера.
+//
+// Данные берутся из последних результатов проверок в snapshot.
+func RegisterAPI(mux *http.ServeMux, snapshot *Snapshot) {
+	mux.HandleFunc("GET "+APIPrefix+"/groups", func(w http.ResponseWriter, r *http.Request) {
+		groups := snapshot.Groups()
+		response := make([]apiGroup, 0, len(groups))
+		for _, group := range groups {
+			response = append(response, newAPIGroup(group))
+		}
+		writeJSON(w, http.StatusOK, response)
+	})
+	mux.HandleFunc("GET "+APIPrefix+"/groups/{name}", func(w http.ResponseWriter, r *http.Request) {
+		group, ok := snapshot.Group(r.PathValue("name"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "group not found or not checked yet"})
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPIGroup(group))
+	})
+	mux.HandleFunc("GET "+APIPrefix+"/servers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		for _, group := range snapshot.Groups() {
+			for _, resp := range group.Result.Responses {
+				if resp.ServerID == id {
+					writeJSON(w, http.StatusOK, newAPIServer(group.Result.GroupName, resp, group.History[id]))
+					return
+				}
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "server not found or not checked yet"})
+	})
+}
+
+// writeJSON записывает ответ value в формате JSON с кодом status
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Warn("Failed to write JSON response", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,133 @@
+package pdns
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ResponseAssertions - структура с проверками содержимого ответа DNS сервера.
+// Все заданные проверки должны выполниться, иначе сервер считается недоступным.
+// Незаданные (нулевые) поля не проверяются.
+type ResponseAssertions struct {
+	ExpectedValues []string `json:"expectedValues"` // Значения, которые должны присутствовать в секции ответа (IP адреса, имена, строки TXT)
+	RdataRegex     string   `json:"rdataRegex"`     // Регулярное выражение, которому должны соответствовать RDATA всех записей ответа
+	MinAnswers     int      `json:"minAnswers"`     // Минимальное количество записей в секции ответа
+	MinTTL         *uint32  `json:"minTTL"`         // Минимально допустимый TTL записей ответа
+	MaxTTL         *uint32  `json:"maxTTL"`         // Максимально допустимый TTL записей ответа
+	RequireAA      bool     `json:"requireAA"`      // Ответ должен быть авторитативным (флаг AA)
+	RequireRA      bool     `json:"requireRA"`      // Сервер должен поддерживать рекурсию (флаг RA)
+	MustBeNXDOMAIN bool     `json:"mustBeNXDOMAIN"` // Ответ должен иметь код NXDOMAIN
+
+	rdataRe *regexp.Regexp // Скомпилированное регулярное выражение RdataRegex
+}
+
+// Причины непрохождения проверок содержимого ответа (используются как значения лейбла reason)
+const (
+	AssertionExpectedValue = "expected_value" // В ответе нет ожидаемого значения
+	AssertionRdataRegex    = "rdata_regex"    // RDATA не соответствует регулярному выражению
+	AssertionMinAnswers    = "min_answers"    // Записей в ответе меньше, чем требуется
+	AssertionTTL           = "ttl"            // TTL записи вне допустимых границ
+	AssertionAAFlag        = "aa_flag"        // В ответе нет флага AA
+	AssertionRAFlag        = "ra_flag"        // В ответе нет флага RA
+	AssertionNXDOMAIN      = "nxdomain"       // Код ответа не NXDOMAIN
+)
+
+// compile проверяет корректность настроек и компилирует регулярное выражение.
+// Вызывается при загрузке конфигурации; для nil (проверки не заданы) ничего не делает.
+func (a *ResponseAssertions) compile() error {
+	if a == nil {
+		return nil
+	}
+	if a.MinAnswers < 0 {
+		return fmt.Errorf("assertions.minAnswers must not be negative")
+	}
+	if a.MinTTL != nil && a.MaxTTL != nil && *a.MinTTL > *a.MaxTTL {
+		return fmt.Errorf("assertions.minTTL (%d) is greater than assertions.maxTTL (%d)", *a.MinTTL, *a.MaxTTL)
+	}
+	if a.RdataRegex != "" {
+		re, err := regexp.Compile(a.RdataRegex)
+		if err != nil {
+			return fmt.Errorf("assertions.rdataRegex: %w", err)
+		}
+		a.rdataRe = re
+	}
+	return nil
+}
+
+// check выполняет проверки над ответом DNS сервера.
+// Возвращает пустые строки, если все проверки пройдены, иначе причину (одну из констант Assertion*)
+// и подробное описание для логов.
+func (a *ResponseAssertions) check(msg *dns.Msg) (string, string) {
+	if a == nil || msg == nil {
+		return "", ""
+	}
+	if a.MustBeNXDOMAIN && msg.Rcode != dns.RcodeNameError {
+		return AssertionNXDOMAIN, fmt.Sprintf("expected NXDOMAIN, got %s", rcodeName(msg.Rcode))
+	}
+	if a.RequireAA && !msg.Authoritative {
+		return AssertionAAFlag, "response is not authoritative (AA flag is not set)"
+	}
+	if a.RequireRA && !msg.RecursionAvailable {
+		return AssertionRAFlag, "recursion is not available (RA flag is not set)"
+	}
+	if len(msg.Answer) < a.MinAnswers {
+		return AssertionMinAnswers, fmt.Sprintf("expected at least %d answers, got %d", a.MinAnswers, len(msg.Answer))
+	}
+
+	rdatas := make([]string, 0, len(msg.Answer))
+	for _, rr := range msg.Answer {
+		ttl := rr.Header().Ttl
+		if a.MinTTL != nil && ttl < *a.MinTTL {
+			return AssertionTTL, fmt.Sprintf("TTL %d of %q is below minimum %d", ttl, rr.String(), *a.MinTTL)
+		}
+		if a.MaxTTL != nil && ttl > *a.MaxTTL {
+			return AssertionTTL, fmt.Sprintf("TTL %d of %q is above maximum %d", ttl, rr.String(), *a.MaxTTL)
+		}
+		rdata := rdataString(rr)
+		if a.rdataRe != nil && !a.rdataRe.MatchString(rdata) {
+			return AssertionRdataRegex, fmt.Sprintf("RDATA %q does not match %q", rdata, a.RdataRegex)
+		}
+		rdatas = append(rdatas, rdata)
+	}
+
+	for _, expected := range a.ExpectedValues {
+		if !containsRdata(rdatas, expected) {
+			return AssertionExpectedValue, fmt.Sprintf("expected value %q not found in answer %v", expected, rdatas)
+		}
+	}
+	return "", ""
+}
+
+// rdataString возвращает RDATA записи в текстовом виде (запись без заголовка).
+func rdataString(rr dns.RR) string {
+	return strings.TrimSpace(strings.TrimPrefix(rr.String(), rr.Header().String()))
+}
+
+// containsRdata проверяет, присутствует ли ожидаемое значение среди RDATA записей ответа.
+// IP адреса сравниваются как адреса (2001:db8::1 == 2001:0db8::1), остальные значения -
+// без учета регистра, кавычек и завершающей точки.
+func containsRdata(rdatas []string, expected string) bool {
+	expectedIP := net.ParseIP(expected)
+	for _, rdata := range rdatas {
+		if expectedIP != nil {
+			if ip := net.ParseIP(rdata); ip != nil && ip.Equal(expectedIP) {
+				return true
+			}
+			continue
+		}
+		if normalizeRdata(rdata) == normalizeRdata(expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeRdata приводит значение к виду, удобному для сравнения.
+func normalizeRdata(value string) string {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	return strings.ToLower(strings.TrimSuffix(value, "."))
+}
@@ -0,0 +1,101 @@
+package pdns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// testAnswer создает ответ с записями records в текстовом виде ("example.com. 300 IN A 192.0.2.1")
+func testAnswer(t *testing.T, records ...string) *dns.Msg {
+	t.Helper()
+	msg := &dns.Msg{}
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("record %q: %v", record, err)
+		}
+		msg.Answer = append(msg.Answer, rr)
+	}
+	return msg
+}
+
+// TestResponseAssertionsCheck проверяет каждую проверку содержимого ответа
+func TestResponseAssertionsCheck(t *testing.T) {
+	ttl := func(v uint32) *uint32 { return &v }
+	answer := []string{
+		"example.com. 300 IN A 192.0.2.1",
+		"example.com. 300 IN A 192.0.2.2",
+	}
+	tests := []struct {
+		name       string
+		assertions *ResponseAssertions
+		records    []string
+		change     func(msg *dns.Msg)
+		wantReason string
+	}{
+		{name: "not set", assertions: nil, records: answer},
+		{name: "empty", assertions: &ResponseAssertions{}, records: answer},
+		{name: "expected IP", assertions: &ResponseAssertions{ExpectedValues: []string{"192.0.2.2"}}, records: answer},
+		{name: "expected IPv6 in another form", assertions: &ResponseAssertions{ExpectedValues: []string{"2001:0db8::0001"}}, records: []string{"example.com. 300 IN AAAA 2001:db8::1"}},
+		{name: "missing IP", assertions: &ResponseAssertions{ExpectedValues: []string{"192.0.2.1", "192.0.2.3"}}, records: answer, wantReason: AssertionExpectedValue},
+		{name: "expected name", assertions: &ResponseAssertions{ExpectedValues: []string{"NS1.Example.com"}}, records: []string{"example.com. 300 IN NS ns1.example.com."}},
+		{name: "expected TXT", assertions: &ResponseAssertions{ExpectedValues: []string{"v=spf1 -all"}}, records: []string{`example.com. 300 IN TXT "v=spf1 -all"`}},
+		{name: "rdata regex", assertions: &ResponseAssertions{RdataRegex: `^192\.0\.2\.\d+$`}, records: answer},
+		{name: "rdata regex mismatch", assertions: &ResponseAssertions{RdataRegex: `^192\.0\.2\.1$`}, records: answer, wantReason: AssertionRdataRegex},
+		{name: "min answers", assertions: &ResponseAssertions{MinAnswers: 2}, records: answer},
+		{name: "too few answers", assertions: &ResponseAssertions{MinAnswers: 3}, records: answer, wantReason: AssertionMinAnswers},
+		{name: "ttl in range", assertions: &ResponseAssertions{MinTTL: ttl(300), MaxTTL: ttl(300)}, records: answer},
+		{name: "ttl below minimum", assertions: &ResponseAssertions{MinTTL: ttl(600)}, records: answer, wantReason: AssertionTTL},
+		{name: "ttl above maximum", assertions: &ResponseAssertions{MaxTTL: ttl(60)}, records: answer, wantReason: AssertionTTL},
+		{name: "aa flag", assertions: &ResponseAssertions{RequireAA: true}, records: answer, change: func(msg *dns.Msg) { msg.Authoritative = true }},
+		{name: "no aa flag", assertions: &ResponseAssertions{RequireAA: true}, records: answer, wantReason: AssertionAAFlag},
+		{name: "ra flag", assertions: &ResponseAssertions{RequireRA: true}, records: answer, change: func(msg *dns.Msg) { msg.RecursionAvailable = true }},
+		{name: "no ra flag", assertions: &ResponseAssertions{RequireRA: true}, records: answer, wantReason: AssertionRAFlag},
+		{name: "nxdomain", assertions: &ResponseAssertions{MustBeNXDOMAIN: true}, change: func(msg *dns.Msg) { msg.Rcode = dns.RcodeNameError }},
+		{name: "not nxdomain", assertions: &ResponseAssertions{MustBeNXDOMAIN: true}, records: answer, wantReason: AssertionNXDOMAIN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.assertions.compile(); err != nil {
+				t.Fatal(err)
+			}
+			msg := testAnswer(t, tt.records...)
+			if tt.change != nil {
+				tt.change(msg)
+			}
+			reason, detail := tt.assertions.check(msg)
+			if reason != tt.wantReason {
+				t.Errorf("reason %q (%s), want %q", reason, detail, tt.wantReason)
+			}
+			if (detail == "") != (tt.wantReason == "") {
+				t.Errorf("detail %q for reason %q", detail, reason)
+			}
+		})
+	}
+}
+
+// TestResponseAssertionsCompile проверяет ошибки в настройках проверок
+func TestResponseAssertionsCompile(t *testing.T) {
+	ttl := func(v uint32) *uint32 { return &v }
+	tests := []struct {
+		name       string
+		assertions *ResponseAssertions
+		wantErr    string // Подстрока ожидаемой ошибки, пустая - ошибки нет
+	}{
+		{name: "not set", assertions: nil},
+		{name: "valid", assertions: &ResponseAssertions{RdataRegex: `^10\.`, MinTTL: ttl(60), MaxTTL: ttl(3600), MinAnswers: 1}},
+		{name: "negative min answers", assertions: &ResponseAssertions{MinAnswers: -1}, wantErr: "minAnswers must not be negative"},
+		{name: "min ttl above max", assertions: &ResponseAssertions{MinTTL: ttl(600), MaxTTL: ttl(60)}, wantErr: "is greater than assertions.maxTTL"},
+		{name: "bad regex", assertions: &ResponseAssertions{RdataRegex: `(`}, wantErr: "assertions.rdataRegex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.assertions.compile()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("compile error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
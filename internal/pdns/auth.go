@@ -0,0 +1,281 @@
+package pdns
+
+import (
+	"fmt"
+	"log/slog"
+	"main/pkg/auth"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// Группы обработчиков, для каждой из которых задается своя аутентификация
+const (
+	EndpointMetrics = "metrics" // Метрики и /probe
+	EndpointAPI     = "api"     // Чтение API /api/v1
+	EndpointAdmin   = "admin"   // /-/reload, /-/log-level и изменение обслуживания
+)
+
+// Endpoints - группы обработчиков в порядке проверки конфигурации
+var Endpoints = []string{EndpointMetrics, EndpointAPI, EndpointAdmin}
+
+// AuthConfig - аутентификация запросов по группам обработчиков.
+// Запросы к группам metrics и api без настроек не проверяются, а группа admin без настроек недоступна.
+type AuthConfig struct {
+	Metrics *EndpointAuth `json:"metrics"` // Метрики и /probe
+	API     *EndpointAuth `json:"api"`     // Чтение API /api/v1
+	Admin   *EndpointAuth `json:"admin"`   // /-/reload, /-/log-level и изменение обслуживания через API
+}
+
+// EndpointAuth - способы аутентификации группы обработчиков.
+// Запрос разрешен, если его принял любой из заданных способов (mode "any", по умолчанию) или все способы (mode "all").
+type EndpointAuth struct {
+	Mode            string           `json:"mode" validate:"omitempty,oneof=any all"` // Сочетание способов: any или all
+	Mtls            *CertificateAuth `json:"mtls"`                                    // Клиентский сертификат (только на адресах с mTLS)
+	Tokens          []TokenConfig    `json:"tokens" validate:"dive"`                  // Bearer токены
+	Users           []UserConfig     `json:"users" validate:"dive"`                   // Пользователи HTTP basic
+	AllowedNetworks []string         `json:"allowedNetworks"`                         // Разрешенные сети клиентов (CIDR или адрес)
+}
+
+// CertificateAuth - условия, которым должен соответствовать клиентский сертификат (достаточно одного).
+// Без условий принимается любой сертификат, подписанный доверенным CA.
+type CertificateAuth struct {
+	AllowedCN    []string `json:"allowedCN"`    // Разрешенные CN
+	AllowedDNS   []string `json:"allowedDNS"`   // Разрешенные DNS имена из SAN
+	AllowedURI   []string `json:"allowedURI"`   // Разрешенные URI из SAN (например, "spiffe://example.org/prometheus")
+	AllowedEmail []string `json:"allowedEmail"` // Разрешенные email из SAN
+	Fingerprints []string `json:"fingerprints"` // Разрешенные отпечатки SHA-256 сертификатов (hex, допускаются ":")
+}
+
+// TokenConfig - bearer токен. Токен задается в конфигурации или в файле (предпочтительно).
+type TokenConfig struct {
+	Name      string `json:"name" validate:"required"`            // Имя клиента (используется в логах и как автор изменений)
+	Token     string `json:"token"`                               // Токен
+	TokenFile string `json:"tokenFile" validate:"omitempty,file"` // Файл с токеном
+}
+
+// UserConfig - пользователь HTTP basic с хэшем пароля bcrypt
+type UserConfig struct {
+	Username     string `json:"username" validate:"required"`     // Имя пользователя
+	PasswordHash string `json:"passwordHash" validate:"required"` // Хэш пароля bcrypt ("htpasswd -nbB user password")
+}
+
+// endpoints возвращает настройки групп обработчиков по имени группы
+func (conf *AuthConfig) endpoints() map[string]*EndpointAuth {
+	return map[string]*EndpointAuth{EndpointMetrics: conf.Metrics, EndpointAPI: conf.API, EndpointAdmin: conf.Admin}
+}
+
+// listenerAuth возвращает аутентификацию адреса listener: собственную, из секции web или, если ни одна
+// не задана, прежнюю проверку CN клиентского сертификата по mtls.allowedCN для всех обработчиков.
+// Если секция auth задана, mtls.allowedCN адреса проверяется дополнительно (см. authHandler).
+func (conf *Config) listenerAuth(listener ListenerConfig) *AuthConfig {
+	if listener.Auth != nil {
+		return listener.Auth
+	}
+	if conf.Web != nil && conf.Web.Auth != nil {
+		return conf.Web.Auth
+	}
+	if listener.Mtls != nil && listener.Mtls.Enabled && len(listener.Mtls.AllowedCN) > 0 {
+		endpoint := &EndpointAuth{Mtls: &CertificateAuth{AllowedCN: listener.Mtls.AllowedCN}}
+		return &AuthConfig{Metrics: endpoint, API: endpoint, Admin: endpoint}
+	}
+	return nil
+}
+
+// validate проверяет способы аутентификации всех групп обработчиков
+func (conf *AuthConfig) validate(check *configCheck, path string) {
+	if conf == nil {
+		return
+	}
+	endpoints := conf.endpoints()
+	for _, name := range Endpoints {
+		endpoints[name].validate(check, fieldPath(path, name))
+	}
+}
+
+// validate проверяет, что задан хотя бы один способ, а также отпечатки, сети, токены и хэши паролей
+// (обязательность полей проверяется тегами)
+func (endpoint *EndpointAuth) validate(check *configCheck, path string) {
+	if endpoint == nil {
+		return
+	}
+	if endpoint.Mtls == nil && len(endpoint.Tokens) == 0 && len(endpoint.Users) == 0 && len(endpoint.AllowedNetworks) == 0 {
+		check.addf(path, "no authentication methods configured (set mtls, tokens, users or allowedNetworks, or remove the section)")
+	}
+	if endpoint.Mtls != nil {
+		for i, fingerprint := range endpoint.Mtls.Fingerprints {
+			if _, err := auth.ParseFingerprint(fingerprint); err != nil {
+				check.add(indexPath(fieldPath(path, "mtls.fingerprints"), i), err)
+			}
+		}
+	}
+	for i, network := range endpoint.AllowedNetworks {
+		if _, err := auth.ParseNetwork(network); err != nil {
+			check.add(indexPath(fieldPath(path, "allowedNetworks"), i), err)
+		}
+	}
+	names := make(map[string]bool)
+	for i, token := range endpoint.Tokens {
+		tokenPath := indexPath(fieldPath(path, "tokens"), i)
+		if (token.Token == "") == (token.TokenFile == "") {
+			check.addf(tokenPath, "exactly one of token and tokenFile must be set")
+		}
+		if token.Name != "" && names[token.Name] {
+			check.addf(fieldPath(tokenPath, "name"), "duplicate token name %q", token.Name)
+		}
+		names[token.Name] = true
+	}
+	users := make(map[string]bool)
+	for i, user := range endpoint.Users {
+		userPath := indexPath(fieldPath(path, "users"), i)
+		if user.Username != "" && users[user.Username] {
+			check.addf(fieldPath(userPath, "username"), "duplicate user %q", user.Username)
+		}
+		users[user.Username] = true
+		if user.PasswordHash != "" {
+			if err := (&auth.Basic{}).AddUser(user.Username, user.PasswordHash); err != nil {
+				check.add(fieldPath(userPath, "passwordHash"), err)
+			}
+		}
+	}
+}
+
+// policy создает политику аутентификации группы обработчиков. Способы проверяются в порядке:
+// клиентский сертификат, bearer токен, HTTP basic, адрес клиента.
+func (endpoint *EndpointAuth) policy() (*auth.Policy, error) {
+	if endpoint == nil {
+		return nil, nil
+	}
+	policy := &auth.Policy{RequireAll: endpoint.Mode == "all"}
+	if mtls := endpoint.Mtls; mtls != nil {
+		certificate := &auth.Certificate{CommonNames: mtls.AllowedCN, DNSNames: mtls.AllowedDNS, URIs: mtls.AllowedURI, Emails: mtls.AllowedEmail}
+		for _, value := range mtls.Fingerprints {
+			fingerprint, err := auth.ParseFingerprint(value)
+			if err != nil {
+				return nil, err
+			}
+			certificate.Fingerprints = append(certificate.Fingerprints, fingerprint)
+		}
+		policy.Authenticators = append(policy.Authenticators, certificate)
+	}
+	if len(endpoint.Tokens) > 0 {
+		bearer := &auth.Bearer{}
+		for _, token := range endpoint.Tokens {
+			value := token.Token
+			if token.TokenFile != "" {
+				data, err := os.ReadFile(token.TokenFile)
+				if err != nil {
+					return nil, fmt.Errorf("token %q: %w", token.Name, err)
+				}
+				value = strings.TrimSpace(string(data))
+			}
+			bearer.AddToken(token.Name, value)
+		}
+		policy.Authenticators = append(policy.Authenticators, bearer)
+	}
+	if len(endpoint.Users) > 0 {
+		basic := &auth.Basic{}
+		for _, user := range endpoint.Users {
+			if err := basic.AddUser(user.Username, user.PasswordHash); err != nil {
+				return nil, err
+			}
+		}
+		policy.Authenticators = append(policy.Authenticators, basic)
+	}
+	if len(endpoint.AllowedNetworks) > 0 {
+		network := &auth.Network{}
+		for _, value := range endpoint.AllowedNetworks {
+			prefix, err := auth.ParseNetwork(value)
+			if err != nil {
+				return nil, err
+			}
+			network.Prefixes = append(network.Prefixes, prefix)
+		}
+		policy.Authenticators = append(policy.Authenticators, network)
+	}
+	return policy, nil
+}
+
+// authHandler оборачивает next проверкой аутентификации адреса listener по группам обработчиков.
+// Группа admin без настроек закрыта: ее политика без способов отклоняет все запросы.
+// Если аутентификация задана секцией auth, а у адреса с mTLS задан mtls.allowedCN, CN клиентского
+// сертификата проверяется вместе со способами каждой группы, чтобы секция auth не расширяла доступ к адресу.
+func (conf *Config) authHandler(next http.Handler, listener ListenerConfig) (http.Handler, error) {
+	authConf := conf.listenerAuth(listener)
+	if authConf == nil {
+		authConf = &AuthConfig{}
+	}
+	var allowedCN auth.Authenticator
+	if mtls := listener.Mtls; mtls != nil && mtls.Enabled && len(mtls.AllowedCN) > 0 && (listener.Auth != nil || conf.webAuth() != nil) {
+		allowedCN = &auth.Certificate{CommonNames: mtls.AllowedCN}
+	}
+	policies := make(map[string]*auth.Policy)
+	for name, endpoint := range authConf.endpoints() {
+		policy, err := endpoint.policy()
+		if err != nil {
+			return nil, fmt.Errorf("address %s: auth %s: %w", listener.Address, name, err)
+		}
+		switch {
+		case policy == nil && name == EndpointAdmin:
+			policy = &auth.Policy{}
+		case policy == nil && allowedCN != nil:
+			policy = &auth.Policy{Authenticators: []auth.Authenticator{allowedCN}}
+		case allowedCN != nil:
+			policy = &auth.Policy{RequireAll: true, Authenticators: []auth.Authenticator{policy, allowedCN}}
+		}
+		policies[name] = policy
+	}
+	return auth.Handler(next, func(r *http.Request) *auth.Policy {
+		return policies[requestEndpoint(r)]
+	}), nil
+}
+
+// requireAuth разрешает вызов handler только клиентам, аутентифицированным при проверке доступа
+// (см. authHandler). Изменяющие состояние обработчики (обслуживание, перезагрузка, уровень логов)
+// без настроенной аутентификации группы admin недоступны, а не открыты всем, кто может подключиться к адресу.
+func requireAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.IdentityFrom(r.Context()); !ok {
+			slog.Warn("Unauthenticated request to an admin endpoint rejected", slog.String("remoteAddr", r.RemoteAddr), slog.String("method", r.Method), slog.String("path", r.URL.Path))
+			writeJSON(w, http.StatusForbidden, map[string]string{"message": "authentication required, configure web.auth.admin"})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// requestEndpoint определяет группу обработчиков запроса по пути и методу
+func requestEndpoint(r *http.Request) string {
+	urlPath := path.Clean("/" + r.URL.Path)
+	readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+	switch {
+	case strings.HasPrefix(urlPath, "/-/"):
+		return EndpointAdmin
+	case strings.HasPrefix(urlPath, APIPrefix+"/maintenance") && !readOnly:
+		return EndpointAdmin
+	case strings.HasPrefix(urlPath, APIPrefix+"/"):
+		return EndpointAPI
+	}
+	return EndpointMetrics
+}
+
+// redactSecrets скрывает токены и хэши паролей
+func (conf *AuthConfig) redactSecrets() {
+	if conf == nil {
+		return
+	}
+	for _, endpoint := range conf.endpoints() {
+		if endpoint == nil {
+			continue
+		}
+		for i := range endpoint.Tokens {
+			if endpoint.Tokens[i].Token != "" {
+				endpoint.Tokens[i].Token = redacted
+			}
+		}
+		for i := range endpoint.Users {
+			endpoint.Users[i].PasswordHash = redacted
+		}
+	}
+}
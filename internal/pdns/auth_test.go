@@ -0,0 +1,110 @@
+package pdns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAuthHandler проверяет выбор политики по группе обработчиков: закрытую группу admin без настроек,
+// прежнюю проверку mtls.allowedCN и ее сочетание с секцией auth
+func TestAuthHandler(t *testing.T) {
+	mtls := &MtlsConfig{Enabled: true, AllowedCN: []string{"prometheus"}}
+	tokens := &AuthConfig{
+		API:   &EndpointAuth{Tokens: []TokenConfig{{Name: "grafana", Token: "secret"}}},
+		Admin: &EndpointAuth{Tokens: []TokenConfig{{Name: "ops", Token: "admin-secret"}}},
+	}
+	tests := []struct {
+		name     string
+		web      *AuthConfig
+		listener ListenerConfig
+		method   string
+		path     string
+		cn       string // CN клиентского сертификата (пустой - без TLS)
+		token    string
+		want     int
+	}{
+		{name: "no auth: metrics", method: "GET", path: "/metrics", want: http.StatusOK},
+		{name: "no auth: api", method: "GET", path: APIPrefix + "/groups", want: http.StatusOK},
+		{name: "no auth: reload", method: "POST", path: ReloadPath, want: http.StatusForbidden},
+		{name: "no auth: maintenance", method: "PUT", path: APIPrefix + "/maintenance/servers/s1", want: http.StatusForbidden},
+		{name: "no admin section", web: &AuthConfig{API: tokens.API}, method: "PUT", path: LogLevelPath, token: "secret", want: http.StatusForbidden},
+		{name: "admin token", web: tokens, method: "POST", path: ReloadPath, token: "admin-secret", want: http.StatusOK},
+		{name: "api token on admin", web: tokens, method: "POST", path: ReloadPath, token: "secret", want: http.StatusForbidden},
+		{name: "legacy CN", listener: ListenerConfig{Mtls: mtls}, method: "POST", path: ReloadPath, cn: "prometheus", want: http.StatusOK},
+		{name: "legacy CN mismatch", listener: ListenerConfig{Mtls: mtls}, method: "GET", path: "/metrics", cn: "grafana", want: http.StatusForbidden},
+		{name: "CN and auth: open group", web: tokens, listener: ListenerConfig{Mtls: mtls}, method: "GET", path: "/metrics", cn: "prometheus", want: http.StatusOK},
+		{name: "CN and auth: open group, CN mismatch", web: tokens, listener: ListenerConfig{Mtls: mtls}, method: "GET", path: "/metrics", cn: "grafana", want: http.StatusForbidden},
+		{name: "CN and auth: token", web: tokens, listener: ListenerConfig{Mtls: mtls}, method: "GET", path: APIPrefix + "/groups", cn: "prometheus", token: "secret", want: http.StatusOK},
+		{name: "CN and auth: token, CN mismatch", web: tokens, listener: ListenerConfig{Mtls: mtls}, method: "GET", path: APIPrefix + "/groups", cn: "grafana", token: "secret", want: http.StatusForbidden},
+		{name: "CN and auth: no token", web: tokens, listener: ListenerConfig{Mtls: mtls}, method: "GET", path: APIPrefix + "/groups", cn: "prometheus", want: http.StatusUnauthorized},
+		{name: "CN and listener auth", listener: ListenerConfig{Mtls: mtls, Auth: tokens}, method: "POST", path: ReloadPath, cn: "grafana", token: "admin-secret", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{Web: &WebConfig{Auth: tt.web}}
+			handler, err := conf.authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), tt.listener)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.cn != "" {
+				cert := &x509.Certificate{Raw: []byte(tt.cn), Subject: pkix.Name{CommonName: tt.cn}}
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			}
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+// TestEndpointAuthValidate проверяет ошибки в настройках аутентификации группы обработчиков
+func TestEndpointAuthValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint *EndpointAuth
+		want     []string // Подстроки ожидаемых ошибок, пустой - ошибок нет
+	}{
+		{name: "not set", endpoint: nil},
+		{name: "no methods", endpoint: &EndpointAuth{}, want: []string{"web.auth.admin: no authentication methods configured"}},
+		{name: "mode only", endpoint: &EndpointAuth{Mode: "all"}, want: []string{"no authentication methods configured"}},
+		{name: "any certificate", endpoint: &EndpointAuth{Mtls: &CertificateAuth{}}},
+		{name: "network", endpoint: &EndpointAuth{AllowedNetworks: []string{"10.0.0.0/8"}}},
+		{name: "bad network", endpoint: &EndpointAuth{AllowedNetworks: []string{"10.0.0.0/8", "ten"}}, want: []string{"web.auth.admin.allowedNetworks[1]: invalid network"}},
+		{name: "bad fingerprint", endpoint: &EndpointAuth{Mtls: &CertificateAuth{Fingerprints: []string{"ab"}}}, want: []string{"web.auth.admin.mtls.fingerprints[0]"}},
+		{
+			name:     "tokens",
+			endpoint: &EndpointAuth{Tokens: []TokenConfig{{Name: "a", Token: "x"}, {Name: "a", Token: "y"}, {Name: "b"}}},
+			want:     []string{`tokens[1].name: duplicate token name "a"`, "tokens[2]: exactly one of token and tokenFile must be set"},
+		},
+		{
+			name:     "users",
+			endpoint: &EndpointAuth{Users: []UserConfig{{Username: "ops", PasswordHash: "password"}}},
+			want:     []string{"users[0].passwordHash: user \"ops\": invalid bcrypt hash"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := &configCheck{}
+			tt.endpoint.validate(check, "web.auth.admin")
+			if len(check.errs) != len(tt.want) {
+				t.Fatalf("errors:\n%v\nwant %d", check.errs, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(check.errs[i].Error(), want) {
+					t.Errorf("error %q does not contain %q", check.errs[i].Error(), want)
+				}
+			}
+		})
+	}
+}
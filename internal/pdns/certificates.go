@@ -0,0 +1,371 @@
+package pdns
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultTLSReloadInterval - интервал проверки изменений файлов сертификатов по умолчанию
+const DefaultTLSReloadInterval = time.Minute
+
+// tlsMaterial - прочитанные из файлов сертификат сервера, CA клиентов и CRL одного адреса
+type tlsMaterial struct {
+	config      *tls.Config         // Настройки TLS соединений с клиентами
+	certificate *x509.Certificate   // Сертификат сервера
+	clientCAs   []*x509.Certificate // Сертификаты CA для проверки клиентских сертификатов
+	crls        []revocationList    // Списки отозванных сертификатов
+}
+
+// revocationList - список отозванных сертификатов (CRL) с серийными номерами для быстрого поиска
+type revocationList struct {
+	file    string               // Файл, из которого прочитан список
+	list    *x509.RevocationList // Разобранный список
+	revoked map[string]bool      // Серийные номера отозванных сертификатов (в десятичном виде)
+}
+
+// CertificateStore - сертификат и ключ сервера, CA клиентов и CRL одного адреса с mTLS.
+// Файлы перечитываются при изменении содержимого (Watch) или по запросу (Reload) без перезапуска
+// сервера: новые соединения используют новые сертификаты, открытые соединения не прерываются.
+// Если новые файлы некорректны (например, сертификат уже заменен, а ключ еще нет), продолжают
+// действовать прежние.
+type CertificateStore struct {
+	address string                      // Адрес HTTP сервера (для логов и меток метрик)
+	mtls    MtlsConfig                  // Пути к файлам
+	current atomic.Pointer[tlsMaterial] // Действующие сертификаты
+	mu      sync.Mutex                  // Последовательное выполнение перезагрузок
+	sum     [sha256.Size]byte           // Контрольная сумма последнего прочитанного содержимого файлов
+
+	lastSuccess atomic.Bool   // Результат последней перезагрузки
+	successes   atomic.Uint64 // Количество успешных перезагрузок
+	failures    atomic.Uint64 // Количество неудачных перезагрузок
+}
+
+// NewCertificateStore читает файлы mTLS адреса address. Ошибка возвращается, если файлы
+// не удалось прочитать или разобрать.
+func NewCertificateStore(address string, mtls MtlsConfig) (*CertificateStore, error) {
+	store := &CertificateStore{address: address, mtls: mtls}
+	if mtls.ClientCA == "" {
+		slog.Warn("mTLS clientCA is not set, client certificates are verified against the server certificate", slog.String("addr", address), slog.String("cert", mtls.Cert))
+	}
+	store.sum = store.filesSum()
+	material, err := loadTLSMaterial(mtls)
+	if err != nil {
+		return nil, err
+	}
+	store.current.Store(material)
+	store.lastSuccess.Store(true)
+	material.logExpiry(address)
+	return store, nil
+}
+
+// TLSConfig возвращает настройки TLS сервера, которые для каждого соединения берут действующие сертификаты
+func (s *CertificateStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &s.current.Load().config.Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.current.Load().config, nil
+		},
+	}
+}
+
+// Reload перечитывает файлы и, если они корректны, заменяет действующие сертификаты.
+// source - что вызвало перезагрузку (sighup, watch), используется в логах.
+func (s *CertificateStore) Reload(source string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Сумма запоминается и при ошибке, чтобы отслеживание файлов не повторяло перезагрузку до следующего изменения
+	s.sum = s.filesSum()
+	material, err := loadTLSMaterial(s.mtls)
+	if err != nil {
+		slog.Error("TLS certificates reload failed, keeping the current certificates", slog.String("source", source), slog.String("addr", s.address), slog.String("error", err.Error()))
+		s.lastSuccess.Store(false)
+		s.failures.Add(1)
+		return err
+	}
+	s.current.Store(material)
+	s.lastSuccess.Store(true)
+	s.successes.Add(1)
+	slog.Info("TLS certificates reloaded", slog.String("source", source), slog.String("addr", s.address),
+		slog.String("subject", material.certificate.Subject.String()), slog.Time("notAfter", material.certificate.NotAfter))
+	material.logExpiry(s.address)
+	return nil
+}
+
+// Watch проверяет изменения файлов с интервалом reloadInterval и перезагружает сертификаты
+// при изменении содержимого. Работает до отмены ctx.
+func (s *CertificateStore) Watch(ctx context.Context) {
+	ticker := time.NewTicker(s.mtls.reloadInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.changed() {
+				s.Reload("watch")
+			}
+		}
+	}
+}
+
+// changed проверяет, изменилось ли содержимое файлов с последнего чтения
+func (s *CertificateStore) changed() bool {
+	sum := s.filesSum()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sum != s.sum
+}
+
+// files возвращает пути к файлам сертификатов адреса
+func (s *CertificateStore) files() []string {
+	return append([]string{s.mtls.Cert, s.mtls.Key, s.mtls.clientCA()}, s.mtls.CRL...)
+}
+
+// filesSum вычисляет контрольную сумму содержимого файлов; недоступные файлы пропускаются
+func (s *CertificateStore) filesSum() [sha256.Size]byte {
+	hash := sha256.New()
+	for _, file := range s.files() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", file, len(data))
+		hash.Write(data)
+	}
+	var sum [sha256.Size]byte
+	hash.Sum(sum[:0])
+	return sum
+}
+
+// loadTLSMaterial читает сертификат и ключ сервера, сертификаты CA клиентов и CRL
+// и создает из них настройки TLS с обязательной проверкой клиентского сертификата
+func loadTLSMaterial(mtls MtlsConfig) (*tlsMaterial, error) {
+	pair, err := tls.LoadX509KeyPair(mtls.Cert, mtls.Key)
+	if err != nil {
+		return nil, fmt.Errorf("server certificate %s: %w", mtls.Cert, err)
+	}
+	certificate, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("server certificate %s: %w", mtls.Cert, err)
+	}
+	data, err := os.ReadFile(mtls.clientCA())
+	if err != nil {
+		return nil, fmt.Errorf("client CA: %w", err)
+	}
+	clientCAs, err := parseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("client CA %s: %w", mtls.clientCA(), err)
+	}
+	pool := x509.NewCertPool()
+	for _, ca := range clientCAs {
+		pool.AddCert(ca)
+	}
+	material := &tlsMaterial{certificate: certificate, clientCAs: clientCAs}
+	for _, file := range mtls.CRL {
+		crl, err := loadRevocationList(file)
+		if err != nil {
+			return nil, fmt.Errorf("CRL %s: %w", file, err)
+		}
+		material.crls = append(material.crls, crl)
+	}
+	material.config = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if len(material.crls) > 0 {
+		material.config.VerifyPeerCertificate = material.verifyRevocation
+	}
+	return material, nil
+}
+
+// parseCertificates разбирает сертификаты в формате PEM; повторяющиеся сертификаты пропускаются
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	seen := make(map[[sha256.Size]byte]bool)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if fingerprint := sha256.Sum256(certificate.Raw); !seen[fingerprint] {
+			seen[fingerprint] = true
+			certificates = append(certificates, certificate)
+		}
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return certificates, nil
+}
+
+// loadRevocationList читает CRL в формате PEM ("X509 CRL") или DER
+func loadRevocationList(file string) (revocationList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return revocationList{}, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "X509 CRL" {
+			return revocationList{}, fmt.Errorf("unexpected PEM block %q, expected \"X509 CRL\"", block.Type)
+		}
+		data = block.Bytes
+	}
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return revocationList{}, err
+	}
+	crl := revocationList{file: file, list: list, revoked: make(map[string]bool)}
+	for _, entry := range list.RevokedCertificateEntries {
+		crl.revoked[entry.SerialNumber.String()] = true
+	}
+	return crl, nil
+}
+
+// verifyRevocation проверяет, что клиентский сертификат и промежуточные CA не отозваны.
+// Вызывается после проверки цепочки сертификатов; соединение принимается, если не отозван
+// ни один сертификат хотя бы одной из проверенных цепочек. CRL учитывается, только если
+// он подписан издателем проверяемого сертификата.
+func (m *tlsMaterial) verifyRevocation(_ [][]byte, chains [][]*x509.Certificate) error {
+	var err error
+	for _, chain := range chains {
+		if err = m.checkChain(chain); err == nil {
+			return nil
+		}
+	}
+	if err != nil {
+		slog.Warn("Client certificate rejected", slog.String("error", err.Error()))
+	}
+	return err
+}
+
+// checkChain ищет отозванные сертификаты в цепочке chain (последний сертификат цепочки - корневой CA)
+func (m *tlsMaterial) checkChain(chain []*x509.Certificate) error {
+	for i := 0; i+1 < len(chain); i++ {
+		certificate, issuer := chain[i], chain[i+1]
+		for _, crl := range m.crls {
+			if !bytes.Equal(crl.list.RawIssuer, issuer.RawSubject) || crl.list.CheckSignatureFrom(issuer) != nil {
+				continue
+			}
+			if crl.revoked[certificate.SerialNumber.String()] {
+				return fmt.Errorf("certificate %q (serial %s) is revoked by %s", certificate.Subject.String(), certificate.SerialNumber.Text(16), crl.file)
+			}
+		}
+	}
+	return nil
+}
+
+// logExpiry предупреждает об истекших сертификатах и устаревших CRL
+func (m *tlsMaterial) logExpiry(address string) {
+	now := time.Now()
+	if now.After(m.certificate.NotAfter) {
+		slog.Warn("Server certificate has expired", slog.String("addr", address), slog.String("subject", m.certificate.Subject.String()), slog.Time("notAfter", m.certificate.NotAfter))
+	}
+	for _, crl := range m.crls {
+		if !crl.list.NextUpdate.IsZero() && now.After(crl.list.NextUpdate) {
+			slog.Warn("CRL is past its next update time, revocations issued since then are not known", slog.String("addr", address), slog.String("file", crl.file), slog.Time("nextUpdate", crl.list.NextUpdate))
+		}
+	}
+}
+
+// CertificateMetrics - метрики сертификатов всех адресов с mTLS: сроки действия сертификатов
+// сервера и CA клиентов, время следующего обновления CRL и результаты перезагрузок
+type CertificateMetrics struct {
+	stores []*CertificateStore // Сертификаты адресов
+
+	CertificateExpiry *prometheus.Desc // Дескриптор метрики окончания срока действия сертификата
+	CRLNextUpdate     *prometheus.Desc // Дескриптор метрики времени следующего обновления CRL
+	LastReload        *prometheus.Desc // Дескриптор метрики результата последней перезагрузки
+	ReloadsTotal      *prometheus.Desc // Дескриптор метрики количества перезагрузок
+}
+
+// NewCertificateMetrics создает метрики сертификатов адресов stores
+func NewCertificateMetrics(stores []*CertificateStore) *CertificateMetrics {
+	return &CertificateMetrics{
+		stores: stores,
+		CertificateExpiry: prometheus.NewDesc(
+			"tls_certificate_expiry_timestamp_seconds",
+			"Expiry time of the certificates used by the HTTP server: the server certificate (kind=server) and the client CA certificates (kind=client_ca)",
+			[]string{"address", "kind", "subject", "serial"}, nil,
+		),
+		CRLNextUpdate: prometheus.NewDesc(
+			"tls_crl_next_update_timestamp_seconds",
+			"Next update time of the certificate revocation lists used to check client certificates",
+			[]string{"address", "file", "issuer"}, nil,
+		),
+		LastReload: prometheus.NewDesc(
+			"tls_certificates_last_reload_successful",
+			"Whether the last reload of the TLS certificate files was successful (1 - success, 0 - failure)",
+			[]string{"address"}, nil,
+		),
+		ReloadsTotal: prometheus.NewDesc(
+			"tls_certificates_reloads_total",
+			"Total number of TLS certificate file reloads by result (success, failure)",
+			[]string{"address", "result"}, nil,
+		),
+	}
+}
+
+// Describe реализует интерфейс prometheus.Collector для метрик сертификатов
+func (metrics *CertificateMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- metrics.CertificateExpiry
+	ch <- metrics.CRLNextUpdate
+	ch <- metrics.LastReload
+	ch <- metrics.ReloadsTotal
+}
+
+// Collect реализует интерфейс prometheus.Collector для метрик сертификатов
+func (metrics *CertificateMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, store := range metrics.stores {
+		material := store.current.Load()
+		expiry := func(kind string, certificate *x509.Certificate) {
+			ch <- prometheus.MustNewConstMetric(metrics.CertificateExpiry, prometheus.GaugeValue, float64(certificate.NotAfter.Unix()),
+				store.address, kind, certificate.Subject.String(), hex.EncodeToString(certificate.SerialNumber.Bytes()))
+		}
+		expiry("server", material.certificate)
+		for _, ca := range material.clientCAs {
+			expiry("client_ca", ca)
+		}
+		for _, crl := range material.crls {
+			if crl.list.NextUpdate.IsZero() {
+				continue // Время следующего обновления в CRL необязательно
+			}
+			ch <- prometheus.MustNewConstMetric(metrics.CRLNextUpdate, prometheus.GaugeValue, float64(crl.list.NextUpdate.Unix()),
+				store.address, crl.file, crl.list.Issuer.String())
+		}
+		success := 0.0
+		if store.lastSuccess.Load() {
+			success = 1
+		}
+		ch <- prometheus.MustNewConstMetric(metrics.LastReload, prometheus.GaugeValue, success, store.address)
+		ch <- prometheus.MustNewConstMetric(metrics.ReloadsTotal, prometheus.CounterValue, float64(store.successes.Load()), store.address, "success")
+		ch <- prometheus.MustNewConstMetric(metrics.ReloadsTotal, prometheus.CounterValue, float64(store.failures.Load()), store.address, "failure")
+	}
+}
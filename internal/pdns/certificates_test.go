@@ -0,0 +1,197 @@
+package pdns
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mtlsFiles - файлы сертификатов сервера с mTLS во временном каталоге теста
+type mtlsFiles struct {
+	dir  string     // Каталог с файлами
+	mtls MtlsConfig // Пути к файлам
+}
+
+// newMtlsFiles записывает сертификат сервера от serverCA и CA клиентов clientCA
+func newMtlsFiles(t *testing.T, serverCA, clientCA *testCA) *mtlsFiles {
+	t.Helper()
+	dir := t.TempDir()
+	files := &mtlsFiles{dir: dir, mtls: MtlsConfig{
+		Enabled:  true,
+		Cert:     filepath.Join(dir, "server.pem"),
+		Key:      filepath.Join(dir, "server-key.pem"),
+		ClientCA: filepath.Join(dir, "client-ca.pem"),
+	}}
+	files.write(t, serverCA, clientCA)
+	return files
+}
+
+// write заменяет сертификат и ключ сервера сертификатом от serverCA, а CA клиентов - сертификатом clientCA
+func (files *mtlsFiles) write(t *testing.T, serverCA, clientCA *testCA) {
+	t.Helper()
+	server := serverCA.issueServer(t)
+	key, err := x509.MarshalPKCS8PrivateKey(server.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, files.mtls.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate[0]}))
+	writeTestFile(t, files.mtls.Key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}))
+	writeTestFile(t, files.mtls.ClientCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCA.cert.Raw}))
+}
+
+// addCRL записывает CRL, подписанный signer, с отозванными сертификатами revoked и добавляет его в настройки
+func (files *mtlsFiles) addCRL(t *testing.T, name string, signer *testCA, revoked ...tls.Certificate) {
+	t.Helper()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, certificate := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   certificate.Leaf.SerialNumber,
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, signer.cert, signer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(files.dir, name)
+	writeTestFile(t, file, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}))
+	files.mtls.CRL = append(files.mtls.CRL, file)
+}
+
+// startMtlsServer запускает HTTPS сервер с сертификатами из store
+func startMtlsServer(t *testing.T, store *CertificateStore) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = store.TLSConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// mtlsGet выполняет запрос к серверу в новом соединении с клиентским сертификатом client,
+// проверяя сертификат сервера по serverCA
+func mtlsGet(server *httptest.Server, serverCA *testCA, client tls.Certificate) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+	httpClient := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{client}},
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := httpClient.Get(server.URL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// TestCertificateStoreRevocation проверяет отклонение отозванных клиентских сертификатов и то,
+// что CRL учитывается, только если он подписан издателем сертификата
+func TestCertificateStoreRevocation(t *testing.T) {
+	serverCA := newTestCA(t, "server CA")
+	clientCA := newTestCA(t, "client CA")
+	revoked := clientCA.issueClient(t, "revoked")
+	valid := clientCA.issueClient(t, "valid")
+	// CA с тем же именем, что и clientCA, но другим ключом: его CRL не должен учитываться
+	impostor := newTestCA(t, "client CA")
+	other := newTestCA(t, "other CA")
+
+	tests := []struct {
+		name    string
+		crls    func(files *mtlsFiles)
+		client  tls.Certificate
+		wantErr bool
+	}{
+		{"no CRL", func(*mtlsFiles) {}, revoked, false},
+		{"revoked", func(files *mtlsFiles) { files.addCRL(t, "client.crl", clientCA, revoked) }, revoked, true},
+		{"not revoked", func(files *mtlsFiles) { files.addCRL(t, "client.crl", clientCA, revoked) }, valid, false},
+		{"wrong signature", func(files *mtlsFiles) { files.addCRL(t, "impostor.crl", impostor, revoked, valid) }, valid, false},
+		{"other issuer", func(files *mtlsFiles) { files.addCRL(t, "other.crl", other, revoked) }, revoked, false},
+		{"one of several", func(files *mtlsFiles) {
+			files.addCRL(t, "other.crl", other)
+			files.addCRL(t, "client.crl", clientCA, revoked)
+		}, revoked, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := newMtlsFiles(t, serverCA, clientCA)
+			tt.crls(files)
+			store, err := NewCertificateStore("test", files.mtls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := startMtlsServer(t, store)
+			if err := mtlsGet(server, serverCA, tt.client); (err != nil) != tt.wantErr {
+				t.Errorf("request error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestCertificateStoreReload проверяет, что замена сертификата сервера и CA клиентов применяется без перезапуска
+// сервера, а некорректные файлы не заменяют действующие сертификаты
+func TestCertificateStoreReload(t *testing.T) {
+	oldServerCA, oldClientCA := newTestCA(t, "old server CA"), newTestCA(t, "old client CA")
+	newServerCA, newClientCA := newTestCA(t, "new server CA"), newTestCA(t, "new client CA")
+	oldClient, newClient := oldClientCA.issueClient(t, "old"), newClientCA.issueClient(t, "new")
+
+	files := newMtlsFiles(t, oldServerCA, oldClientCA)
+	files.mtls.ReloadInterval = Duration(10 * time.Millisecond)
+	store, err := NewCertificateStore("test", files.mtls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := startMtlsServer(t, store)
+	if err := mtlsGet(server, oldServerCA, oldClient); err != nil {
+		t.Fatalf("before reload: %v", err)
+	}
+
+	// Некорректный ключ: продолжают действовать прежние сертификаты
+	writeTestFile(t, files.mtls.Key, []byte("not a key"))
+	if err := store.Reload("test"); err == nil {
+		t.Fatal("reload with an invalid key succeeded")
+	}
+	if err := mtlsGet(server, oldServerCA, oldClient); err != nil {
+		t.Fatalf("after failed reload: %v", err)
+	}
+
+	// Новые файлы подхватываются при отслеживании изменений
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Watch(ctx)
+	files.write(t, newServerCA, newClientCA)
+	deadline := time.Now().Add(5 * time.Second)
+	for mtlsGet(server, newServerCA, newClient) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("new certificates were not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := mtlsGet(server, newServerCA, oldClient); err == nil {
+		t.Error("client certificate of the replaced CA is still accepted")
+	}
+	if err := mtlsGet(server, oldServerCA, newClient); err == nil {
+		t.Error("replaced server certificate is still served")
+	}
+	// Файлы записываются по очереди, поэтому отслеживание может застать их и в промежуточном состоянии
+	if store.successes.Load() == 0 || store.failures.Load() == 0 {
+		t.Errorf("reloads: %d successful, %d failed; want both", store.successes.Load(), store.failures.Load())
+	}
+}
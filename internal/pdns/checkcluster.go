@@ -1,115 +1,177 @@
-package pdns
-
-import (
-	"log/slog"
-	"sync"
-)
-
-// AvailabilityGroup - структура, представляющая собой отчет о доступности группы DNS серверов
-type AvailabilityGroup struct {
-	GroupName          string // Имя группы серверов DNS
-	AllServers         int8   // Общее количество серверов в группе
-	AvailabileServers  int8   // Количество доступных серверов
-	UnavailableServers int8   // Количество недоступных серверов
-	MaintenanceServers int8   // Количество серверов на обслуживании
-}
-
-// processingDnsGroup - функция для обработки конкретной группы DNS серверов
-func processingDnsGroup(group GroupDNS) AvailabilityGroup {
-	dnsClient := CreateDnsClient()                             // Создаем DNS клиент с заданными настройками (тайм-ауты и т.д.)
-	chDns := make(chan DnsResponseData, len(group.DNSServers)) // Канал для получения данных о каждом сервере
-	var wg sync.WaitGroup                                      // Ожидание завершения всех горутин
-	// Инициализируем структуру для хранения результатов обработки группы
-	availGroup := AvailabilityGroup{
-		GroupName:          group.GroupName,
-		AllServers:         int8(len(group.DNSServers)), // Общее количество серверов в группе
-		AvailabileServers:  0,                           // Изначально доступных серверов нет
-		UnavailableServers: 0,                           // Изначально недоступных серверов нет
-		MaintenanceServers: 0,                           // Изначально серверы на обслуживании не учитываются
-	}
-	counter := 0 // Счетчик, который отслеживает количество обработанных серверов
-
-	// Логируем начало обработки группы
-	slog.Info("Processing DNS group", slog.String("groupName", group.GroupName), slog.Int("serversCount", len(group.DNSServers)))
-
-	// Проходим по каждому серверу из группы и проверяем его состояние
-	for _, target := range group.DNSServers {
-		counter++
-		if target.Maintenance { // Если сервер находится на обслуживании, увеличиваем счетчик и пропускаем его
-			availGroup.MaintenanceServers++
-			slog.Debug("Server is under maintenance", slog.String("serverID", target.ServerID), slog.String("serverIP", target.IP))
-			continue
-		}
-		wg.Add(1) // Увеличиваем счетчик горутин для каждого запроса
-		// Создаем данные для DNS запроса
-		dnsReqData := CreateDnsRequestData(target.ServerID, target.IP, target.RequestedRecord, int32(target.DNSPort))
-		// Запускаем горутину для отправки DNS запроса асинхронно
-		go DnsRequest(dnsReqData, chDns, dnsClient, &wg)
-	}
-
-	// Ожидаем завершения всех горутин
-	wg.Wait()
-	close(chDns) // Закрываем канал после завершения всех горутин
-
-	// Обрабатываем результаты запросов, полученные через канал
-	for result := range chDns {
-		// Логируем результаты доступности каждого сервера
-		if result.Availability {
-			slog.Debug("Server is available", slog.String("serverID", result.ServerID), slog.String("serverIP", result.ServerID), slog.Duration("responseTime", result.TimeToResponse))
-		} else {
-			slog.Debug("Server is unavailable", slog.String("serverID", result.ServerID), slog.String("serverIP", result.ServerID))
-		}
-
-		// Если сервер доступен, увеличиваем счетчик доступных серверов, иначе недоступных
-		switch result.Availability {
-		case true:
-			availGroup.AvailabileServers++
-		case false:
-			availGroup.UnavailableServers++
-		}
-	}
-
-	// Логируем итоговые результаты для группы
-	slog.Info("Finished processing DNS group",
-		slog.String("groupName", availGroup.GroupName),
-		slog.Int("allServers", int(availGroup.AllServers)),
-		slog.Int("availableServers", int(availGroup.AvailabileServers)),
-		slog.Int("unavailableServers", int(availGroup.UnavailableServers)),
-		slog.Int("maintenanceServers", int(availGroup.MaintenanceServers)),
-	)
-
-	// Возвращаем итоговый результат по группе
-	return availGroup
-}
-
-// CheckAvailabilityDns - основная функция для проверки доступности всех DNS серверов во всех группах
-func CheckAvailabilityDns(dnsGroups []GroupDNS, chAvailMgcl chan []AvailabilityGroup) {
-	var wgAvailAuth sync.WaitGroup    // Ожидание завершения всех горутин по обработке групп
-	var availList []AvailabilityGroup // Список для хранения результатов по всем группам
-
-	// Логируем начало проверки доступности всех групп
-	slog.Info("Starting to check availability for all DNS groups")
-
-	// Обрабатываем каждую группу DNS серверов
-	for _, group := range dnsGroups {
-		wgAvailAuth.Add(1) // Увеличиваем счетчик горутин для каждой группы
-
-		go func(group GroupDNS) {
-			defer wgAvailAuth.Done() // Уменьшаем счетчик горутин по завершению
-
-			// Обрабатываем группу и получаем результаты
-			dataAvail := processingDnsGroup(group)
-			// Добавляем результат в общий список
-			availList = append(availList, dataAvail)
-		}(group) // Передаем группу в горутину
-	}
-
-	// Ожидаем завершения всех горутин
-	wgAvailAuth.Wait()
-
-	// Логируем завершение проверки доступности всех групп
-	slog.Info("Finished checking availability for all DNS groups")
-
-	// Отправляем собранные результаты в канал
-	chAvailMgcl <- availList
-}
+package pdns
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// GroupState - итоговое состояние группы DNS серверов с учетом политики кворума
+type GroupState string
+
+const (
+	GroupStateHealthy  GroupState = "healthy"  // Политика выполнена, все серверы (кроме серверов на обслуживании) доступны
+	GroupStateDegraded GroupState = "degraded" // Политика выполнена, но часть серверов недоступна
+	GroupStateDown     GroupState = "down"     // Политика не выполнена
+	// Все серверы группы на обслуживании, политика не проверяется
+	GroupStateMaintenance GroupState = "maintenance"
+)
+
+// GroupStates - список всех возможных состояний группы
+var GroupStates = []GroupState{GroupStateHealthy, GroupStateDegraded, GroupStateDown, GroupStateMaintenance}
+
+// AvailabilityGroup - структура, представляющая собой отчет о доступности группы DNS серверов
+type AvailabilityGroup struct {
+	GroupName          string     // Имя группы серверов DNS
+	AllServers         int        // Общее количество серверов в группе
+	AvailabileServers  int        // Количество доступных серверов
+	UnavailableServers int        // Количество недоступных серверов
+	MaintenanceServers int        // Количество серверов на обслуживании
+	AvailableWeight    int        // Суммарный вес доступных серверов
+	State              GroupState // Состояние группы по политике кворума
+	// Результаты по каждому серверу группы; для серверов на обслуживании запрос не выполняется
+	// и в результате установлен только флаг Maintenance
+	Responses []DnsResponseData
+}
+
+// processingDnsGroup - функция для обработки конкретной группы DNS серверов.
+// Запросы к серверам группы выполняются в общем пуле pool; результаты собираются
+// после завершения всех запросов, поэтому итоговая структура заполняется в одной горутине.
+func processingDnsGroup(ctx context.Context, group GroupDNS, pool *ProbePool) AvailabilityGroup {
+	// Инициализируем структуру для хранения результатов обработки группы
+	availGroup := AvailabilityGroup{
+		GroupName:          group.GroupName,
+		AllServers:         len(group.DNSServers), // Общее количество серверов в группе
+		AvailabileServers:  0,                     // Изначально доступных серверов нет
+		UnavailableServers: 0,                     // Изначально недоступных серверов нет
+		MaintenanceServers: 0,                     // Изначально серверы на обслуживании не учитываются
+	}
+	weights := make(map[string]int) // Веса серверов для взвешенного кворума
+	var requests []DnsRequestData   // Запросы к серверам, не находящимся на обслуживании
+
+	// Логируем начало обработки группы
+	slog.Info("Processing DNS group", slog.String("groupName", group.GroupName), slog.Int("serversCount", len(group.DNSServers)))
+
+	// Проходим по каждому серверу из группы и проверяем его состояние
+	for _, target := range group.DNSServers {
+		if target.Maintenance { // Если сервер находится на обслуживании, увеличиваем счетчик и пропускаем его
+			availGroup.MaintenanceServers++
+			slog.Debug("Server is under maintenance", slog.String("serverID", target.ServerID), slog.String("serverIP", target.IP))
+			availGroup.Responses = append(availGroup.Responses, DnsResponseData{
+				ServerID:    target.ServerID,
+				Request:     CreateDnsRequestData(target),
+				Maintenance: true,
+				Rcode:       -1,
+			})
+			continue
+		}
+		weights[target.ServerID] = target.weight()
+		// Создаем данные для DNS запроса
+		requests = append(requests, CreateDnsRequestData(target))
+	}
+
+	// Выполняем запросы в пуле и ожидаем их завершения
+	results := pool.ProbeAll(ctx, requests)
+
+	// Обрабатываем результаты запросов
+	for _, result := range results {
+		// Логируем результаты доступности каждого сервера
+		if result.Availability {
+			slog.Debug("Server is available", slog.String("serverID", result.ServerID), slog.String("serverIP", result.Request.Address), slog.Duration("responseTime", result.TimeToResponse))
+		} else {
+			slog.Debug("Server is unavailable", slog.String("serverID", result.ServerID), slog.String("serverIP", result.Request.Address), slog.String("status", string(result.Status)), slog.String("rcode", rcodeName(result.Rcode)))
+		}
+
+		// Доступным считается только сервер с результатом ok, любая другая классификация
+		// (неожиданный rcode, тайм-аут, ошибка сети, усеченный ответ, непройденная проверка) означает недоступность
+		switch result.Status {
+		case ProbeStatusOK:
+			availGroup.AvailabileServers++
+			availGroup.AvailableWeight += weights[result.ServerID]
+		default:
+			availGroup.UnavailableServers++
+		}
+		availGroup.Responses = append(availGroup.Responses, result)
+	}
+
+	// Определяем состояние группы по политике кворума
+	availGroup.State = group.HealthPolicy.evaluate(availGroup)
+
+	// Логируем итоговые результаты для группы
+	slog.Info("Finished processing DNS group",
+		slog.String("groupName", availGroup.GroupName),
+		slog.String("state", string(availGroup.State)),
+		slog.Int("allServers", availGroup.AllServers),
+		slog.Int("availableServers", availGroup.AvailabileServers),
+		slog.Int("unavailableServers", availGroup.UnavailableServers),
+		slog.Int("maintenanceServers", availGroup.MaintenanceServers),
+	)
+
+	// Возвращаем итоговый результат по группе
+	return availGroup
+}
+
+// evaluate определяет состояние группы по результатам проверки.
+// Группа в состоянии down, если не выполнено хотя бы одно из условий политики; degraded, если условия
+// выполнены, но есть недоступные серверы; healthy, если недоступных серверов нет.
+// Без политики (nil) группа считается работоспособной при хотя бы одном доступном сервере.
+// Если все серверы группы на обслуживании, группа в состоянии maintenance.
+func (policy *GroupHealthPolicy) evaluate(avail AvailabilityGroup) GroupState {
+	if policy == nil {
+		policy = &GroupHealthPolicy{MinAvailable: 1}
+	}
+	// Процент доступных серверов считается от серверов, не находящихся на обслуживании
+	active := avail.AllServers - avail.MaintenanceServers
+	if active == 0 && avail.MaintenanceServers > 0 {
+		return GroupStateMaintenance
+	}
+	var percent float64
+	if active > 0 {
+		percent = float64(avail.AvailabileServers) * 100 / float64(active)
+	}
+	switch {
+	case avail.AvailabileServers < policy.MinAvailable,
+		percent < policy.MinPercent,
+		avail.AvailableWeight < policy.MinWeight,
+		avail.AvailabileServers == 0:
+		return GroupStateDown
+	case avail.UnavailableServers > 0:
+		return GroupStateDegraded
+	default:
+		return GroupStateHealthy
+	}
+}
+
+// CheckAvailabilityDns - основная функция для проверки доступности всех DNS серверов во всех группах.
+// Одновременно выполняется не более workers запросов (при workers <= 0 - DefaultProbeWorkers).
+// Результаты возвращаются в порядке групп в dnsGroups. При отмене ctx невыполненные запросы
+// считаются неуспешными.
+func CheckAvailabilityDns(ctx context.Context, dnsGroups []GroupDNS, workers int) []AvailabilityGroup {
+	var wgAvailAuth sync.WaitGroup                         // Ожидание завершения всех горутин по обработке групп
+	availList := make([]AvailabilityGroup, len(dnsGroups)) // Результаты по всем группам, по одному элементу на группу
+	pool := NewProbePool(workers)                          // Общий пул запросов для всех групп
+	defer pool.Close()
+
+	// Логируем начало проверки доступности всех групп
+	slog.Info("Starting to check availability for all DNS groups")
+
+	// Обрабатываем каждую группу DNS серверов
+	for i, group := range dnsGroups {
+		wgAvailAuth.Add(1) // Увеличиваем счетчик горутин для каждой группы
+
+		go func(i int, group GroupDNS) {
+			defer wgAvailAuth.Done() // Уменьшаем счетчик горутин по завершению
+
+			// Обрабатываем группу и записываем результат в ее собственный элемент списка
+			availList[i] = processingDnsGroup(ctx, group, pool)
+		}(i, group) // Передаем группу в горутину
+	}
+
+	// Ожидаем завершения всех горутин
+	wgAvailAuth.Wait()
+
+	// Логируем завершение проверки доступности всех групп
+	slog.Info("Finished checking availability for all DNS groups")
+
+	return availList
+}
@@ -0,0 +1,114 @@
+package pdns
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// TestCheckAvailabilityDns проверяет сотни групп в общем пуле: результаты каждой группы
+// возвращаются в порядке групп и не смешиваются с результатами других групп
+func TestCheckAvailabilityDns(t *testing.T) {
+	fake := startFakeDNSServer(t, 0)
+	const groupsCount = 300
+	var groups []GroupDNS
+	for g := 0; g < groupsCount; g++ {
+		group := GroupDNS{GroupName: fmt.Sprintf("group%d", g)}
+		group.DNSServers = append(group.DNSServers,
+			fake.target(fmt.Sprintf("g%d-ok", g), fakeNameOK),
+			fake.target(fmt.Sprintf("g%d-servfail", g), fakeNameServfail),
+		)
+		if g%2 == 0 {
+			maintenance := fake.target(fmt.Sprintf("g%d-maint", g), fakeNameOK)
+			maintenance.Maintenance = true
+			group.DNSServers = append(group.DNSServers, maintenance)
+		}
+		groups = append(groups, group)
+	}
+
+	results := CheckAvailabilityDns(context.Background(), groups, 32)
+
+	if len(results) != groupsCount {
+		t.Fatalf("got %d groups, want %d", len(results), groupsCount)
+	}
+	for g, result := range results {
+		if result.GroupName != groups[g].GroupName {
+			t.Fatalf("result %d is for group %q, want %q", g, result.GroupName, groups[g].GroupName)
+		}
+		wantMaintenance := 0
+		if g%2 == 0 {
+			wantMaintenance = 1
+		}
+		if result.AllServers != len(groups[g].DNSServers) || result.AvailabileServers != 1 || result.UnavailableServers != 1 || result.MaintenanceServers != wantMaintenance {
+			t.Errorf("group %s: all %d, available %d, unavailable %d, maintenance %d", result.GroupName,
+				result.AllServers, result.AvailabileServers, result.UnavailableServers, result.MaintenanceServers)
+		}
+		if result.State != GroupStateDegraded {
+			t.Errorf("group %s: state %s, want %s", result.GroupName, result.State, GroupStateDegraded)
+		}
+		for _, response := range result.Responses {
+			if want := fmt.Sprintf("g%d-", g); response.ServerID[:len(want)] != want {
+				t.Errorf("group %s contains the result of server %s", result.GroupName, response.ServerID)
+			}
+		}
+	}
+}
+
+// TestCheckAvailabilityDnsCancelled проверяет, что при отмененной проверке все серверы считаются недоступными
+func TestCheckAvailabilityDnsCancelled(t *testing.T) {
+	fake := startFakeDNSServer(t, 0)
+	groups := []GroupDNS{{GroupName: "g", DNSServers: []DNSTarget{fake.target("s1", fakeNameOK), fake.target("s2", fakeNameOK)}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result := CheckAvailabilityDns(ctx, groups, 4)[0]
+	if result.AvailabileServers != 0 || result.UnavailableServers != 2 || result.State != GroupStateDown {
+		t.Errorf("available %d, unavailable %d, state %s; want 0, 2, down", result.AvailabileServers, result.UnavailableServers, result.State)
+	}
+	for _, response := range result.Responses {
+		assertCancelled(t, response, response.ServerID)
+	}
+}
+
+// TestGroupHealthPolicyEvaluate проверяет состояние группы по условиям политики кворума
+func TestGroupHealthPolicyEvaluate(t *testing.T) {
+	// counts возвращает результат проверки группы: всего, доступно, недоступно, на обслуживании и вес доступных
+	counts := func(all, available, unavailable, maintenance, weight int) AvailabilityGroup {
+		return AvailabilityGroup{AllServers: all, AvailabileServers: available, UnavailableServers: unavailable, MaintenanceServers: maintenance, AvailableWeight: weight}
+	}
+	tests := []struct {
+		name   string
+		policy *GroupHealthPolicy
+		avail  AvailabilityGroup
+		want   GroupState
+	}{
+		{"default: all up", nil, counts(3, 3, 0, 0, 3), GroupStateHealthy},
+		{"default: one up", nil, counts(3, 1, 2, 0, 1), GroupStateDegraded},
+		{"default: none up", nil, counts(3, 0, 3, 0, 0), GroupStateDown},
+		{"default: all in maintenance", nil, counts(2, 0, 0, 2, 0), GroupStateMaintenance},
+		{"default: no servers", nil, counts(0, 0, 0, 0, 0), GroupStateDown},
+		{"min available met", &GroupHealthPolicy{MinAvailable: 2}, counts(3, 2, 1, 0, 2), GroupStateDegraded},
+		{"min available not met", &GroupHealthPolicy{MinAvailable: 2}, counts(3, 1, 2, 0, 1), GroupStateDown},
+		{"zero policy, none up", &GroupHealthPolicy{}, counts(2, 0, 2, 0, 0), GroupStateDown},
+		{"percent met", &GroupHealthPolicy{MinPercent: 50}, counts(4, 2, 2, 0, 2), GroupStateDegraded},
+		{"percent not met", &GroupHealthPolicy{MinPercent: 51}, counts(4, 2, 2, 0, 2), GroupStateDown},
+		{"percent excludes maintenance", &GroupHealthPolicy{MinPercent: 100}, counts(4, 2, 0, 2, 2), GroupStateHealthy},
+		{"weight met", &GroupHealthPolicy{MinWeight: 10}, counts(3, 1, 2, 0, 10), GroupStateDegraded},
+		{"weight not met", &GroupHealthPolicy{MinWeight: 10}, counts(3, 2, 1, 0, 9), GroupStateDown},
+		{"all conditions", &GroupHealthPolicy{MinAvailable: 2, MinPercent: 50, MinWeight: 3}, counts(4, 3, 1, 0, 3), GroupStateDegraded},
+		{"one condition fails", &GroupHealthPolicy{MinAvailable: 2, MinPercent: 80, MinWeight: 3}, counts(4, 3, 1, 0, 3), GroupStateDown},
+		{"maintenance with policy", &GroupHealthPolicy{MinAvailable: 3}, counts(3, 0, 0, 3, 0), GroupStateMaintenance},
+		{"large group: all up", nil, counts(200, 200, 0, 0, 200), GroupStateHealthy},
+		{"large group: some up", nil, counts(200, 150, 50, 0, 150), GroupStateDegraded},
+		{"large group: min available", &GroupHealthPolicy{MinAvailable: 140}, counts(300, 150, 150, 0, 150), GroupStateDegraded},
+		{"large group: percent", &GroupHealthPolicy{MinPercent: 60}, counts(300, 150, 50, 100, 150), GroupStateDegraded},
+		{"large group: all in maintenance", nil, counts(256, 0, 0, 256, 0), GroupStateMaintenance},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.evaluate(tt.avail); got != tt.want {
+				t.Errorf("evaluate = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,126 +1,672 @@
-package pdns
-
-import (
-	"encoding/json"
-	"flag"
-	"log/slog"
-	"os"
-
-	"github.com/go-playground/validator/v10"
-)
-
-// Config - основная структура конфигурации, которая содержит параметры для работы приложения.
-// Включает в себя:
-// - путь к файлу логов,
-// - уровень логирования,
-// - настройки для mTLS экспорта,
-// - группы DNS серверов.
-type Config struct {
-	LogPath      string     `json:"logPath"`      // Путь к файлу логов
-	LogLevel     string     `json:"logLevel"`     // Уровень логирования
-	LogToFile    bool       `json:"logToFile"`    // Логирование в файл
-	LogToSyslog  bool       `json:"logToSyslog"`  // Логирование в syslog
-	MtlsExporter MtlsConfig `json:"mtlsExporter"` // Конфигурация mTLS
-	GroupsDNS    []GroupDNS `json:"groupsDns"`    // Список групп DNS серверов
-}
-
-// MtlsConfig - структура для конфигурации mTLS (mutual TLS).
-// Содержит параметры для включения mTLS и настройки безопасности.
-type MtlsConfig struct {
-	Enabled     bool     `json:"enabled"`     // Флаг включения mTLS
-	Key         string   `json:"key"`         // Путь к приватному ключу
-	Cert        string   `json:"cert"`        // Путь к сертификату
-	AllowedCN   []string `json:"allowedCN"`   // Список допустимых Common Name (CN)
-	Description string   `json:"description"` // Описание настроек
-}
-
-// GroupDNS - структура, представляющая группу DNS серверов.
-// Содержит:
-// - имя группы,
-// - список DNS серверов в этой группе.
-type GroupDNS struct {
-	GroupName  string      `json:"groupName"`  // Имя группы DNS серверов
-	DNSServers []DNSTarget `json:"dnsServers"` // Список DNS серверов в группе
-}
-
-// DNSTarget - структура, содержащая информацию о конкретном DNS сервере.
-// Включает в себя:
-// - уникальный идентификатор сервера,
-// - IP адрес,
-// - порт,
-// - запрашиваемую запись,
-// - состояние обслуживания,
-// - описание сервера.
-type DNSTarget struct {
-	ServerID        string `json:"serverID"`        // Идентификатор сервера
-	IP              string `json:"IP"`              // IP адрес DNS сервера
-	DNSPort         int    `json:"dnsPort"`         // Порт DNS сервера
-	RequestedRecord string `json:"requestedRecord"` // Запрашиваемая DNS запись (например, A-запись)
-	Maintenance     bool   `json:"maintenance"`     // Флаг, указывающий на состояние обслуживания
-	Description     string `json:"description"`     // Описание DNS сервера
-}
-
-// GetConfig читает конфигурацию из JSON файла, указанного через флаг `-c`.
-// Возвращает объект конфигурации Config или ошибку в случае неудачи.
-// Также выполняет валидацию конфигурации с помощью библиотеки validator.
-func GetConfig() (*Config, error) {
-	var path string
-	// Чтение флага с путем к файлу конфигурации
-	flag.StringVar(&path, "c", "/etc/dns-group-monitor/config.json", "path to config file")
-	flag.Parse()
-
-	// Логирование пути к конфигурационному файлу
-	slog.Debug("Reading configuration file", slog.String("configFilePath", path))
-
-	// Чтение содержимого конфигурационного файла
-	plan, errRead := os.ReadFile(path)
-	if errRead != nil {
-		// Логируем ошибку чтения файла и возвращаем ошибку
-		slog.Error("Error reading configuration file", slog.String("configFilePath", path), slog.String("error", errRead.Error()))
-		return nil, errRead
-	}
-
-	var Conf Config
-	// Разбираем содержимое файла в структуру Config
-	err := json.Unmarshal(plan, &Conf)
-
-	// Инициализация валидатора и проверка соответствия структуры Config
-	validate := validator.New()
-	if err := validate.Struct(Conf); err != nil {
-		// Логируем ошибки валидации полей структуры
-		errs := err.(validator.ValidationErrors)
-		for _, fieldErr := range errs {
-			slog.Error("Validation error", slog.String("field", fieldErr.Namespace()), slog.String("tag", fieldErr.ActualTag()), slog.String("param", fieldErr.Param()))
-		}
-		return nil, err // Возвращаем ошибку валидации
-	}
-
-	if err != nil {
-		return &Conf, err
-	}
-
-	// Возвращаем структуру с конфигурацией
-	return &Conf, nil
-}
-
-// ContainBool проверяет, содержится ли значение `key` в списке `listing` типа []bool.
-// Возвращает true, если значение найдено, и false в противном случае.
-func ContainBool(listing []bool, key bool) bool {
-	for _, value := range listing {
-		if key == value {
-			return true
-		}
-	}
-	return false
-}
-
-// ContainString проверяет, содержится ли строка `key` в списке `listing` типа []string.
-// Возвращает true, если строка найдена, и false в противном случае.
-func ContainString(listing []string, key string) bool {
-	for _, value := range listing {
-		if key == value {
-			return true
-		}
-	}
-	return false
-}
+package pdns
+
+import (
+	"fmt"
+	"log/slog"
+	"main/pkg/contain"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Config - основная структура конфигурации, которая содержит параметры для работы приложения.
+// Включает в себя:
+// - путь к файлу логов,
+// - уровень логирования,
+// - выходы логов (stdout, stderr, файл с ротацией, syslog), формат и постоянные атрибуты записей,
+// - настройки для mTLS экспорта,
+// - границы корзин гистограмм времени отклика,
+// - интервал фоновых проверок групп,
+// - параметры запросов по умолчанию (тайм-ауты и повторы),
+// - ограничение количества одновременных запросов,
+// - адреса HTTP сервера и путь метрик,
+// - модули запросов для обработчика /probe,
+// - путь к файлу состояния (обслуживание, заданное через API),
+// - интервал проверки изменений файла конфигурации,
+// - время на корректную остановку,
+// - подключаемые файлы с дополнительными группами,
+// - группы DNS серверов.
+type Config struct {
+	LogPath         string                 `json:"logPath"`                                                   // Путь к файлу логов
+	LogLevel        string                 `json:"logLevel" validate:"omitempty,oneof=DEBUG INFO WARN ERROR"` // Уровень логирования
+	LogToFile       bool                   `json:"logToFile"`                                                 // Логирование в файл
+	LogToSyslog     bool                   `json:"logToSyslog"`                                               // Логирование в syslog
+	LogOutputs      []LogOutput            `json:"logOutputs" validate:"dive"`                                // Выходы логов со своими уровнями (если не заданы, используются logToFile и logToSyslog)
+	LogFormat       string                 `json:"logFormat" validate:"omitempty,oneof=json logfmt text"`     // Формат записей логов: json (по умолчанию), logfmt или text
+	LogAttributes   map[string]string      `json:"logAttributes"`                                             // Постоянные атрибуты каждой записи лога (например, "environment": "production")
+	MtlsExporter    MtlsConfig             `json:"mtlsExporter"`                                              // Конфигурация mTLS
+	RttBuckets      []float64              `json:"rttBuckets"`                                                // Границы корзин гистограмм времени отклика в секундах (по умолчанию DefaultRttBuckets)
+	CheckInterval   Duration               `json:"checkInterval"`                                             // Интервал проверки групп по умолчанию (например, "30s"), по умолчанию DefaultCheckInterval
+	CheckJitter     Duration               `json:"checkJitter"`                                               // Максимальная случайная задержка проверки по умолчанию, по умолчанию 10% от интервала
+	ProbeDefaults   *ProbeSettings         `json:"probeDefaults"`                                             // Тайм-ауты и повторы запросов по умолчанию для всех групп
+	ProbeWorkers    int                    `json:"probeWorkers" validate:"min=0"`                             // Максимальное количество одновременных DNS запросов, по умолчанию DefaultProbeWorkers
+	Web             *WebConfig             `json:"web"`                                                       // Адреса HTTP сервера и путь метрик (если не задано, ":9100" и "/metrics")
+	ProbeModules    map[string]ProbeModule `json:"probeModules" validate:"dive"`                              // Именованные модули запросов для /probe
+	StateFile       string                 `json:"stateFile"`                                                 // Файл для сохранения обслуживания, заданного через API (если не задан, состояние не сохраняется между запусками)
+	ConfigWatch     Duration               `json:"configWatch"`                                               // Интервал проверки изменений файла конфигурации для перезагрузки (если не задан, файл не отслеживается)
+	ShutdownTimeout Duration               `json:"shutdownTimeout"`                                           // Время на завершение начатых проверок и HTTP запросов при остановке, по умолчанию DefaultShutdownTimeout
+	Include         []string               `json:"include"`                                                   // Шаблоны путей (glob) или каталоги (conf.d) подключаемых файлов с группами, относительно каталога файла конфигурации
+	GroupsDNS       []GroupDNS             `json:"groupsDns" validate:"dive"`                                 // Список групп DNS серверов
+}
+
+// DefaultRttBuckets - границы корзин гистограмм времени отклика по умолчанию (в секундах).
+// Подобраны под типичные задержки DNS: от 1 мс в локальной сети до нескольких секунд при проблемах.
+var DefaultRttBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// MtlsConfig - структура для конфигурации mTLS (mutual TLS).
+// Содержит параметры для включения mTLS и настройки безопасности.
+// Файлы сертификата, ключа, CA клиентов и CRL перечитываются при изменении без перезапуска (см. CertificateStore).
+type MtlsConfig struct {
+	Enabled        bool     `json:"enabled"`                                  // Флаг включения mTLS
+	Key            string   `json:"key" validate:"required_if=Enabled true"`  // Путь к приватному ключу
+	Cert           string   `json:"cert" validate:"required_if=Enabled true"` // Путь к сертификату
+	ClientCA       string   `json:"clientCA"`                                 // Путь к сертификатам CA для проверки клиентских сертификатов (если не задан, используется cert)
+	CRL            []string `json:"crl"`                                      // Пути к спискам отозванных сертификатов (CRL) в формате PEM или DER
+	ReloadInterval Duration `json:"reloadInterval"`                           // Интервал проверки изменений файлов сертификатов (по умолчанию 1m)
+	AllowedCN      []string `json:"allowedCN"`                                // Список допустимых Common Name (CN)
+	Description    string   `json:"description"`                              // Описание настроек
+}
+
+// ProbeModule - именованный набор параметров запроса для обработчика /probe.
+// Адрес сервера и запрашиваемая запись передаются в параметрах HTTP запроса, остальное берется из модуля.
+type ProbeModule struct {
+	QueryType      string              `json:"queryType"`                           // Тип DNS запроса, по умолчанию A
+	QueryClass     string              `json:"queryClass"`                          // Класс DNS запроса, по умолчанию IN
+	ExpectedRcodes []string            `json:"expectedRcodes"`                      // Коды ответа, при которых сервер считается доступным, по умолчанию NOERROR
+	Assertions     *ResponseAssertions `json:"assertions"`                          // Проверки содержимого ответа (необязательно)
+	Transport      string              `json:"transport"`                           // Транспорт запроса: udp, tcp, dot, doh, doq (по умолчанию udp)
+	TLSServerName  string              `json:"tlsServerName"`                       // Имя сервера для проверки TLS сертификата, по умолчанию адрес сервера
+	TLSCAFile      string              `json:"tlsCAFile" validate:"omitempty,file"` // Путь к файлу с CA для проверки TLS сертификата сервера
+	DohMethod      string              `json:"dohMethod"`                           // HTTP метод DoH запроса: GET или POST (по умолчанию POST)
+	DohURLs        []string            `json:"dohURLs"`                             // URL DoH серверов (https), которые можно передать в параметре target
+	Probe          *ProbeSettings      `json:"probe"`                               // Тайм-ауты и повторы запроса (переопределяют probeDefaults)
+	Description    string              `json:"description"`                         // Описание модуля
+}
+
+// target формирует описание сервера для запроса по модулю (без идентификатора сервера)
+func (module ProbeModule) target(ip string, port int, record string) DNSTarget {
+	return DNSTarget{
+		IP:              ip,
+		DNSPort:         port,
+		RequestedRecord: record,
+		QueryType:       module.QueryType,
+		QueryClass:      module.QueryClass,
+		ExpectedRcodes:  module.ExpectedRcodes,
+		Assertions:      module.Assertions,
+		Transport:       module.Transport,
+		TLSServerName:   module.TLSServerName,
+		TLSCAFile:       module.TLSCAFile,
+		DohMethod:       module.DohMethod,
+		Probe:           module.Probe,
+		Description:     module.Description,
+	}
+}
+
+// DefaultListenAddress и DefaultMetricsPath - адрес HTTP сервера и путь метрик по умолчанию
+const (
+	DefaultListenAddress = ":9100"
+	DefaultMetricsPath   = "/metrics"
+)
+
+// WebConfig - настройки HTTP сервера экспортера.
+// Сервер может слушать несколько адресов одновременно, например обычный HTTP на localhost
+// и mTLS на внешнем интерфейсе.
+type WebConfig struct {
+	MetricsPath string           `json:"metricsPath"`               // Путь метрик (по умолчанию "/metrics")
+	Listeners   []ListenerConfig `json:"listeners" validate:"dive"` // Адреса для прослушивания (по умолчанию ":9100" с настройками mtlsExporter)
+	Auth        *AuthConfig      `json:"auth"`                      // Аутентификация запросов на всех адресах (если не задана, проверяется только CN из mtls.allowedCN)
+}
+
+// ListenerConfig - один адрес HTTP сервера.
+// Адрес задается как "host:port" (IPv4 или IPv6 в квадратных скобках, например "[::1]:9100")
+// или как "unix:/путь/к/сокету" для unix сокета.
+type ListenerConfig struct {
+	Address string      `json:"address" validate:"required"` // Адрес для прослушивания
+	Mtls    *MtlsConfig `json:"mtls"`                        // Настройки mTLS этого адреса (если не заданы или выключены, используется HTTP без TLS)
+	Auth    *AuthConfig `json:"auth"`                        // Аутентификация запросов на этом адресе (заменяет web.auth)
+}
+
+// listeners возвращает адреса HTTP сервера с учетом значений по умолчанию.
+// Без секции web используется один адрес DefaultListenAddress с настройками mtlsExporter.
+func (conf *Config) listeners() []ListenerConfig {
+	if conf.Web != nil && len(conf.Web.Listeners) > 0 {
+		return conf.Web.Listeners
+	}
+	mtls := conf.MtlsExporter
+	return []ListenerConfig{{Address: DefaultListenAddress, Mtls: &mtls}}
+}
+
+// metricsPath возвращает путь метрик с учетом значения по умолчанию
+func (conf *Config) metricsPath() string {
+	if conf.Web != nil && conf.Web.MetricsPath != "" {
+		return conf.Web.MetricsPath
+	}
+	return DefaultMetricsPath
+}
+
+// webAuth возвращает аутентификацию запросов из секции web (nil, если не задана)
+func (conf *Config) webAuth() *AuthConfig {
+	if conf.Web == nil {
+		return nil
+	}
+	return conf.Web.Auth
+}
+
+// validate проверяет адреса HTTP сервера и путь метрик (обязательность адреса проверяется тегами)
+func (web *WebConfig) validate(check *configCheck, path string) {
+	if web == nil {
+		return
+	}
+	if web.MetricsPath != "" && !strings.HasPrefix(web.MetricsPath, "/") {
+		check.addf(fieldPath(path, "metricsPath"), "%q must start with \"/\"", web.MetricsPath)
+	}
+	web.Auth.validate(check, fieldPath(path, "auth"))
+	seen := make(map[string]bool)
+	for i, listener := range web.Listeners {
+		listenerPath := indexPath(fieldPath(path, "listeners"), i)
+		if listener.Address == "" {
+			continue
+		}
+		if seen[listener.Address] {
+			check.addf(fieldPath(listenerPath, "address"), "duplicate address %q", listener.Address)
+		}
+		seen[listener.Address] = true
+		if _, _, err := listenNetwork(listener.Address); err != nil {
+			check.add(fieldPath(listenerPath, "address"), err)
+		}
+		listener.Mtls.validate(check, fieldPath(listenerPath, "mtls"))
+		listener.Auth.validate(check, fieldPath(listenerPath, "auth"))
+	}
+}
+
+// validate проверяет, что файлы сертификата, ключа, CA клиентов и CRL включенного mTLS существуют
+func (mtls *MtlsConfig) validate(check *configCheck, path string) {
+	if mtls == nil || !mtls.Enabled {
+		return
+	}
+	files := []struct{ name, path string }{{"cert", mtls.Cert}, {"key", mtls.Key}, {"clientCA", mtls.ClientCA}}
+	for i, crl := range mtls.CRL {
+		files = append(files, struct{ name, path string }{indexPath("crl", i), crl})
+	}
+	for _, file := range files {
+		if file.path == "" {
+			continue // Отсутствие пути проверяется тегами, clientCA необязателен
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			check.addf(fieldPath(path, file.name), "%s", err)
+		}
+	}
+	if mtls.ReloadInterval < 0 {
+		check.addf(fieldPath(path, "reloadInterval"), "must not be negative, got %s", time.Duration(mtls.ReloadInterval))
+	}
+}
+
+// clientCA возвращает файл сертификатов CA для проверки клиентских сертификатов.
+// Для совместимости с прежними версиями без clientCA используется сертификат сервера.
+func (mtls MtlsConfig) clientCA() string {
+	if mtls.ClientCA != "" {
+		return mtls.ClientCA
+	}
+	return mtls.Cert
+}
+
+// reloadInterval возвращает интервал проверки изменений файлов сертификатов с учетом значения по умолчанию
+func (mtls MtlsConfig) reloadInterval() time.Duration {
+	if mtls.ReloadInterval > 0 {
+		return time.Duration(mtls.ReloadInterval)
+	}
+	return DefaultTLSReloadInterval
+}
+
+// weight возвращает вес сервера для взвешенного кворума (по умолчанию 1)
+func (target DNSTarget) weight() int {
+	if target.Weight == nil {
+		return 1
+	}
+	return *target.Weight
+}
+
+// ProbeSettings - тайм-ауты и повторы запроса к DNS серверу.
+// Задаются глобально (probeDefaults), для группы и для сервера; незаданные поля наследуются
+// от более общего уровня, а в конце - от встроенных значений по умолчанию.
+type ProbeSettings struct {
+	DialTimeout  *Duration `json:"dialTimeout"`                               // Тайм-аут соединения с сервером (по умолчанию 1s)
+	ReadTimeout  *Duration `json:"readTimeout"`                               // Тайм-аут чтения ответа (по умолчанию 2s)
+	WriteTimeout *Duration `json:"writeTimeout"`                              // Тайм-аут отправки запроса (по умолчанию 2s)
+	Retries      *int      `json:"retries" validate:"omitempty,min=0,max=10"` // Количество повторов при тайм-ауте или ошибке сети (по умолчанию 0)
+	RetryDelay   *Duration `json:"retryDelay"`                                // Задержка перед первым повтором, удваивается с каждым повтором (по умолчанию 100ms)
+}
+
+// defaultProbeSettings - встроенные значения тайм-аутов и повторов
+var defaultProbeSettings = ProbeSettings{
+	DialTimeout:  durationPtr(1 * time.Second),
+	ReadTimeout:  durationPtr(2 * time.Second),
+	WriteTimeout: durationPtr(2 * time.Second),
+	Retries:      new(int),
+	RetryDelay:   durationPtr(100 * time.Millisecond),
+}
+
+// durationPtr возвращает указатель на Duration для значений по умолчанию
+func durationPtr(d time.Duration) *Duration {
+	value := Duration(d)
+	return &value
+}
+
+// merge возвращает настройки, в которых незаданные поля s взяты из parent. Исходные структуры не изменяются.
+func (s *ProbeSettings) merge(parent *ProbeSettings) *ProbeSettings {
+	var merged ProbeSettings
+	if s != nil {
+		merged = *s
+	}
+	if parent == nil {
+		return &merged
+	}
+	if merged.DialTimeout == nil {
+		merged.DialTimeout = parent.DialTimeout
+	}
+	if merged.ReadTimeout == nil {
+		merged.ReadTimeout = parent.ReadTimeout
+	}
+	if merged.WriteTimeout == nil {
+		merged.WriteTimeout = parent.WriteTimeout
+	}
+	if merged.Retries == nil {
+		merged.Retries = parent.Retries
+	}
+	if merged.RetryDelay == nil {
+		merged.RetryDelay = parent.RetryDelay
+	}
+	return &merged
+}
+
+// resolve возвращает настройки, в которых все поля заданы (незаданные берутся из встроенных значений)
+func (s *ProbeSettings) resolve() *ProbeSettings {
+	return s.merge(&defaultProbeSettings)
+}
+
+// timeouts возвращает тайм-ауты DNS клиента; вызывается для настроек после resolve
+func (s *ProbeSettings) timeouts() clientTimeouts {
+	return clientTimeouts{
+		Dial:  time.Duration(*s.DialTimeout),
+		Read:  time.Duration(*s.ReadTimeout),
+		Write: time.Duration(*s.WriteTimeout),
+	}
+}
+
+// validate проверяет, что тайм-ауты положительны, а задержка повтора не отрицательна
+// (количество повторов проверяется тегами)
+func (s *ProbeSettings) validate(check *configCheck, path string) {
+	if s == nil {
+		return
+	}
+	timeouts := []struct {
+		name  string
+		value *Duration
+	}{{"dialTimeout", s.DialTimeout}, {"readTimeout", s.ReadTimeout}, {"writeTimeout", s.WriteTimeout}}
+	for _, timeout := range timeouts {
+		if timeout.value != nil && *timeout.value <= 0 {
+			check.addf(fieldPath(path, timeout.name), "must be positive, got %s", time.Duration(*timeout.value))
+		}
+	}
+	if s.RetryDelay != nil && *s.RetryDelay < 0 {
+		check.addf(fieldPath(path, "retryDelay"), "must not be negative, got %s", time.Duration(*s.RetryDelay))
+	}
+}
+
+// applyProbeDefaults записывает в каждый сервер итоговые настройки запроса с учетом настроек группы
+// и probeDefaults, чтобы при создании запроса не требовалось знать, к какой группе относится сервер.
+// Модули /probe наследуют probeDefaults.
+func (conf *Config) applyProbeDefaults() {
+	for name, module := range conf.ProbeModules {
+		module.Probe = module.Probe.merge(conf.ProbeDefaults)
+		conf.ProbeModules[name] = module
+	}
+	for i := range conf.GroupsDNS {
+		group := &conf.GroupsDNS[i]
+		groupSettings := group.Probe.merge(conf.ProbeDefaults)
+		for j := range group.DNSServers {
+			target := &group.DNSServers[j]
+			target.Probe = target.Probe.merge(groupSettings)
+		}
+	}
+}
+
+// DefaultCheckInterval - интервал проверки групп, если он не задан в конфигурации
+const DefaultCheckInterval = 30 * time.Second
+
+// Duration - длительность в конфигурации, записываемая строкой в формате time.ParseDuration ("500ms", "30s", "1m")
+type Duration time.Duration
+
+// UnmarshalText разбирает длительность из строки конфигурации
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalText записывает длительность в виде строки ("30s")
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// GroupDNS - структура, представляющая группу DNS серверов.
+// Содержит:
+// - имя группы,
+// - интервал и разброс фоновых проверок группы,
+// - политику определения состояния группы,
+// - параметры запросов группы (тайм-ауты и повторы),
+// - список DNS серверов в этой группе.
+type GroupDNS struct {
+	GroupName     string             `json:"groupName" validate:"required"`    // Имя группы DNS серверов
+	CheckInterval Duration           `json:"checkInterval"`                    // Интервал проверки группы (если не задан, используется глобальный)
+	CheckJitter   Duration           `json:"checkJitter"`                      // Максимальная случайная задержка проверки (если не задана, используется глобальная)
+	HealthPolicy  *GroupHealthPolicy `json:"healthPolicy"`                     // Политика кворума для состояния группы (если не задана, достаточно одного доступного сервера)
+	Probe         *ProbeSettings     `json:"probe"`                            // Тайм-ауты и повторы запросов для серверов группы (переопределяют probeDefaults)
+	DNSServers    []DNSTarget        `json:"dnsServers" validate:"min=1,dive"` // Список DNS серверов в группе
+}
+
+// GroupHealthPolicy - политика кворума, по которой определяется состояние группы (healthy/degraded/down).
+// Группа считается работоспособной, только если выполнены все заданные условия.
+type GroupHealthPolicy struct {
+	MinAvailable int     `json:"minAvailable" validate:"min=0"`       // Минимальное количество доступных серверов
+	MinPercent   float64 `json:"minPercent" validate:"min=0,max=100"` // Минимальный процент доступных серверов (от серверов не на обслуживании)
+	MinWeight    int     `json:"minWeight" validate:"min=0"`          // Минимальный суммарный вес доступных серверов (взвешенный кворум)
+}
+
+// hasGroup проверяет, есть ли в конфигурации группа name
+func (conf *Config) hasGroup(name string) bool {
+	for _, group := range conf.GroupsDNS {
+		if group.GroupName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// serverGroup возвращает имя группы сервера serverID
+func (conf *Config) serverGroup(serverID string) (string, bool) {
+	for _, group := range conf.GroupsDNS {
+		for _, target := range group.DNSServers {
+			if target.ServerID == serverID {
+				return group.GroupName, true
+			}
+		}
+	}
+	return "", false
+}
+
+// checkSchedule возвращает интервал и максимальную случайную задержку проверки группы
+// с учетом значений по умолчанию из глобальной конфигурации.
+func (group GroupDNS) checkSchedule(conf *Config) (time.Duration, time.Duration) {
+	interval := time.Duration(group.CheckInterval)
+	if interval <= 0 {
+		interval = time.Duration(conf.CheckInterval)
+	}
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+	jitter := time.Duration(group.CheckJitter)
+	if jitter <= 0 {
+		jitter = time.Duration(conf.CheckJitter)
+	}
+	if jitter <= 0 {
+		jitter = interval / 10
+	}
+	return interval, jitter
+}
+
+// DNSTarget - структура, содержащая информацию о конкретном DNS сервере.
+// Включает в себя:
+// - уникальный идентификатор сервера,
+// - IP адрес,
+// - порт,
+// - запрашиваемую запись,
+// - тип и класс DNS запроса,
+// - список ожидаемых кодов ответа (rcode),
+// - проверки содержимого ответа,
+// - вес сервера для взвешенного кворума,
+// - транспорт запроса и его параметры,
+// - тайм-ауты и повторы запроса,
+// - состояние обслуживания,
+// - описание сервера.
+type DNSTarget struct {
+	ServerID        string              `json:"serverID" validate:"required"`                         // Идентификатор сервера
+	IP              string              `json:"IP" validate:"required_without=DohURL,omitempty,host"` // IP адрес DNS сервера
+	DNSPort         int                 `json:"dnsPort" validate:"min=0,max=65535"`                   // Порт DNS сервера
+	RequestedRecord string              `json:"requestedRecord" validate:"required,dnsname"`          // Запрашиваемая DNS запись (например, A-запись)
+	QueryType       string              `json:"queryType"`                                            // Тип DNS запроса (A, AAAA, SOA, NS, MX, TXT, SRV, PTR и т.д.), по умолчанию A
+	QueryClass      string              `json:"queryClass"`                                           // Класс DNS запроса (IN, CH, HS), по умолчанию IN
+	ExpectedRcodes  []string            `json:"expectedRcodes"`                                       // Коды ответа, при которых сервер считается доступным (NOERROR, NXDOMAIN и т.д.), по умолчанию NOERROR
+	Assertions      *ResponseAssertions `json:"assertions"`                                           // Проверки содержимого ответа (необязательно)
+	Weight          *int                `json:"weight" validate:"omitempty,min=0"`                    // Вес сервера для взвешенного кворума группы, по умолчанию 1
+	Transport       string              `json:"transport"`                                            // Транспорт запроса: udp, tcp, dot, doh, doq (по умолчанию udp)
+	TLSServerName   string              `json:"tlsServerName"`                                        // Имя сервера для проверки TLS сертификата (DoT, DoH, DoQ), по умолчанию IP
+	TLSCAFile       string              `json:"tlsCAFile" validate:"omitempty,file"`                  // Путь к файлу с CA для проверки TLS сертификата сервера, по умолчанию системные CA
+	DohURL          string              `json:"dohURL"`                                               // URL DoH сервера, по умолчанию https://IP:dnsPort/dns-query
+	DohMethod       string              `json:"dohMethod"`                                            // HTTP метод DoH запроса: GET или POST (по умолчанию POST)
+	Probe           *ProbeSettings      `json:"probe"`                                                // Тайм-ауты и повторы запроса к серверу (переопределяют настройки группы и probeDefaults)
+	Maintenance     bool                `json:"maintenance"`                                          // Флаг, указывающий на состояние обслуживания
+	Description     string              `json:"description"`                                          // Описание DNS сервера
+}
+
+// DefaultConfigPath - путь к файлу конфигурации, если он не задан флагом `-c` командной строки
+const DefaultConfigPath = "/etc/dns-group-monitor/config.json"
+
+// LoadConfig читает и проверяет конфигурацию из файла source.Path вместе с подключенными файлами (include)
+// и применяет переопределения из переменных окружения и флагов source.Overrides.
+// Формат файла (json, yaml или toml) задается source.Format, а если он пустой - определяется по расширению
+// файла; формат подключенных файлов всегда определяется по расширению.
+// Используется как при запуске, так и при перезагрузке конфигурации.
+func LoadConfig(source ConfigSource) (*Config, error) {
+	path := source.Path
+	format, err := configFormat(path, source.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	// Логирование пути к конфигурационному файлу
+	slog.Debug("Reading configuration file", slog.String("configFilePath", path), slog.String("format", format))
+
+	// Чтение содержимого конфигурационного файла
+	plan, errRead := os.ReadFile(path)
+	if errRead != nil {
+		// Логируем ошибку чтения файла и возвращаем ошибку
+		slog.Error("Error reading configuration file", slog.String("configFilePath", path), slog.String("error", errRead.Error()))
+		return nil, errRead
+	}
+	return parseConfig(path, plan, format, source.Overrides)
+}
+
+// parseConfig разбирает конфигурацию в формате format (json, yaml или toml) из содержимого plan файла path,
+// подключает файлы include, применяет переопределения overrides и проверяет итоговую конфигурацию. YAML и TOML преобразуются в JSON, поэтому
+// все форматы используют одни и те же имена полей. Поля проверяются тегами `validate` с помощью библиотеки
+// validator, а семантика конфигурации - validateConfig. При ошибках возвращается ConfigErrors со всеми
+// найденными ошибками, их файлами, путями и номерами строк.
+func parseConfig(path string, plan []byte, format string, overrides []ConfigOverride) (*Config, error) {
+	var Conf Config
+	check := &configCheck{}
+	plan = check.parse(plan, format)
+	// Разбираем содержимое файла в структуру Config; если файл не разобран, проверять дальше нечего
+	if check.err() == nil {
+		decodeConfig(plan, &Conf, check)
+	}
+	if check.err() == nil {
+		includeConfigs(path, &Conf, check)
+		applyOverrides(&Conf, overrides, check)
+	}
+	if check.err() == nil {
+		validateTags(&Conf, check)
+		validateConfig(&Conf, check)
+	}
+	if err := check.err(); err != nil {
+		// Логируем каждую ошибку с путем к полю и номером строки
+		for _, configErr := range check.errs {
+			slog.Error("Configuration error", slog.String("file", configErr.File), slog.String("path", configErr.Path), slog.Int("line", configErr.Line), slog.String("error", configErr.Message))
+		}
+		return nil, err
+	}
+	Conf.applyProbeDefaults()
+
+	// Возвращаем структуру с конфигурацией
+	return &Conf, nil
+}
+
+// validateConfig проверяет семантику конфигурации, которую нельзя выразить тегами validator:
+// границы корзин гистограмм должны строго возрастать, имена групп и идентификаторы серверов должны быть
+// уникальны (в том числе между подключенными файлами), типы, классы DNS запросов и ожидаемые коды ответа каждого сервера должны быть известны
+// библиотеке miekg/dns, а проверки содержимого ответа должны быть корректны (регулярные выражения
+// компилируются здесь же). Найденные ошибки добавляются в check.
+func validateConfig(conf *Config, check *configCheck) {
+	// Границы корзин гистограмм должны быть положительными и строго возрастать
+	for i, bucket := range conf.RttBuckets {
+		if bucket <= 0 {
+			check.addf(indexPath("rttBuckets", i), "bucket bound must be positive, got %v", bucket)
+		} else if i > 0 && bucket <= conf.RttBuckets[i-1] {
+			check.addf(indexPath("rttBuckets", i), "bucket bounds must be strictly increasing")
+		}
+	}
+	for _, duration := range []struct {
+		name  string
+		value Duration
+	}{{"checkInterval", conf.CheckInterval}, {"checkJitter", conf.CheckJitter}, {"configWatch", conf.ConfigWatch}, {"shutdownTimeout", conf.ShutdownTimeout}} {
+		if duration.value < 0 {
+			check.addf(duration.name, "must not be negative, got %s", time.Duration(duration.value))
+		}
+	}
+	for i, output := range conf.LogOutputs {
+		output.validate(check, indexPath("logOutputs", i))
+	}
+	for key := range conf.LogAttributes {
+		if key == "" || contain.ContainString(reservedLogKeys, key) {
+			check.addf(fieldPath("logAttributes", key), "attribute name must not be empty or one of %s", strings.Join(reservedLogKeys, ", "))
+		}
+	}
+	conf.MtlsExporter.validate(check, "mtlsExporter")
+	conf.Web.validate(check, "web")
+	conf.ProbeDefaults.validate(check, "probeDefaults")
+	for name, module := range conf.ProbeModules {
+		path := fieldPath("probeModules", name)
+		if name == "" {
+			check.addf("probeModules", "module name must not be empty")
+		}
+		module.target("", 0, "").validate(check, path)
+		for i, dohURL := range module.DohURLs {
+			if u, err := url.Parse(dohURL); err != nil || u.Scheme != "https" || u.Host == "" {
+				check.addf(indexPath(fieldPath(path, "dohURLs"), i), "%q must be an absolute https URL", dohURL)
+			}
+		}
+	}
+	groups := make(map[string]int)     // Индекс группы по имени
+	servers := make(map[string]string) // Путь к серверу по идентификатору
+	for i, group := range conf.GroupsDNS {
+		path := indexPath("groupsDns", i)
+		if first, ok := groups[group.GroupName]; ok && group.GroupName != "" {
+			check.addf(fieldPath(path, "groupName"), "duplicate group name %q (already used by %s)", group.GroupName, check.where(indexPath("groupsDns", first)))
+		} else {
+			groups[group.GroupName] = i
+		}
+		if group.CheckInterval < 0 {
+			check.addf(fieldPath(path, "checkInterval"), "must not be negative, got %s", time.Duration(group.CheckInterval))
+		}
+		if group.CheckJitter < 0 {
+			check.addf(fieldPath(path, "checkJitter"), "must not be negative, got %s", time.Duration(group.CheckJitter))
+		}
+		group.Probe.validate(check, fieldPath(path, "probe"))
+		for j, target := range group.DNSServers {
+			serverPath := indexPath(fieldPath(path, "dnsServers"), j)
+			if first, ok := servers[target.ServerID]; ok && target.ServerID != "" {
+				check.addf(fieldPath(serverPath, "serverID"), "duplicate serverID %q (already used by %s)", target.ServerID, check.where(first))
+			} else {
+				servers[target.ServerID] = serverPath
+			}
+			// Нулевой порт означает порт транспорта по умолчанию, поэтому явно заданный 0 - ошибка
+			if portPath := fieldPath(serverPath, "dnsPort"); target.DNSPort == 0 && check.present(portPath) {
+				check.addf(portPath, "must be between 1 and 65535 (omit the field to use the default port of the transport)")
+			}
+			target.validate(check, serverPath)
+		}
+	}
+}
+
+// validate проверяет параметры запроса к серверу: тип и класс запроса, коды ответа, проверки ответа,
+// тайм-ауты и транспорт
+func (target DNSTarget) validate(check *configCheck, path string) {
+	if _, err := parseQueryType(target.QueryType); err != nil {
+		check.add(fieldPath(path, "queryType"), err)
+	}
+	if _, err := parseQueryClass(target.QueryClass); err != nil {
+		check.add(fieldPath(path, "queryClass"), err)
+	}
+	if _, err := parseRcodes(target.ExpectedRcodes); err != nil {
+		check.add(fieldPath(path, "expectedRcodes"), err)
+	}
+	if err := target.Assertions.compile(); err != nil {
+		check.addf(fieldPath(path, "assertions"), "%s", strings.TrimPrefix(err.Error(), "assertions."))
+	}
+	target.Probe.validate(check, fieldPath(path, "probe"))
+	if _, err := parseTransport(target.Transport); err != nil {
+		check.add(fieldPath(path, "transport"), err)
+	}
+	if _, err := parseDohMethod(target.DohMethod); err != nil {
+		check.add(fieldPath(path, "dohMethod"), err)
+	}
+	if target.DohURL != "" {
+		if u, err := url.Parse(target.DohURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			check.addf(fieldPath(path, "dohURL"), "%q must be an absolute http(s) URL", target.DohURL)
+		}
+	}
+}
+
+// parseQueryType преобразует строковое имя типа DNS запроса (например, "SOA") в его числовое значение.
+// Пустая строка означает тип A.
+func parseQueryType(name string) (uint16, error) {
+	if name == "" {
+		return dns.TypeA, nil
+	}
+	qtype, ok := dns.StringToType[strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown queryType %q", name)
+	}
+	return qtype, nil
+}
+
+// parseQueryClass преобразует строковое имя класса DNS запроса (например, "CH" или "CHAOS") в его числовое значение.
+// Пустая строка означает класс IN.
+func parseQueryClass(name string) (uint16, error) {
+	switch strings.ToUpper(name) {
+	case "":
+		return dns.ClassINET, nil
+	case "CHAOS":
+		return dns.ClassCHAOS, nil
+	case "HESIOD":
+		return dns.ClassHESIOD, nil
+	}
+	qclass, ok := dns.StringToClass[strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown queryClass %q", name)
+	}
+	return qclass, nil
+}
+
+// parseRcodes преобразует список строковых кодов ответа (например, "NOERROR", "NXDOMAIN") в их числовые значения.
+// Пустой список означает, что ожидается только NOERROR.
+func parseRcodes(names []string) ([]int, error) {
+	if len(names) == 0 {
+		return []int{dns.RcodeSuccess}, nil
+	}
+	rcodes := make([]int, 0, len(names))
+	for _, name := range names {
+		rcode, ok := dns.StringToRcode[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown rcode %q in expectedRcodes", name)
+		}
+		rcodes = append(rcodes, rcode)
+	}
+	return rcodes, nil
+}
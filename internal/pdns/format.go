@@ -0,0 +1,146 @@
+package pdns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Форматы файла конфигурации
+const (
+	ConfigFormatJSON = "json"
+	ConfigFormatYAML = "yaml"
+	ConfigFormatTOML = "toml"
+)
+
+// configFormat возвращает формат файла конфигурации path: заданный явно format
+// или определенный по расширению файла (.yaml, .yml, .toml; остальные файлы считаются JSON)
+func configFormat(path, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case ConfigFormatJSON, ConfigFormatYAML, ConfigFormatTOML:
+		return strings.ToLower(format), nil
+	case "yml":
+		return ConfigFormatYAML, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unknown configuration format %q (expected json, yaml or toml)", format)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ConfigFormatYAML, nil
+	case ".toml":
+		return ConfigFormatTOML, nil
+	}
+	return ConfigFormatJSON, nil
+}
+
+// toJSON преобразует содержимое файла конфигурации в формате YAML или TOML в JSON,
+// чтобы все форматы разбирались и проверялись одинаково (по тегам json структур Config).
+// Возвращает JSON документ и номера строк значений в исходном файле по пути к ним
+// (0, если номер строки неизвестен). Ошибки разбора добавляются в check.
+func toJSON(data []byte, format string, check *configCheck) ([]byte, map[string]int) {
+	var value any
+	lines := make(map[string]int)
+	switch format {
+	case ConfigFormatYAML:
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			check.errs = append(check.errs, ConfigError{Line: yamlErrorLine(err), Message: "invalid YAML: " + strings.TrimPrefix(err.Error(), "yaml: ")})
+			return nil, lines
+		}
+		if err := node.Decode(&value); err != nil {
+			check.errs = append(check.errs, ConfigError{Message: "invalid YAML: " + strings.TrimPrefix(err.Error(), "yaml: ")})
+			return nil, lines
+		}
+		yamlLines("", &node, lines)
+	case ConfigFormatTOML:
+		var table map[string]any
+		if _, err := toml.Decode(string(data), &table); err != nil {
+			var parseErr toml.ParseError
+			if errors.As(err, &parseErr) {
+				// Номер строки выводится отдельно, из сообщения он удаляется ("toml: line 3 (last key ...): ...")
+				_, message, _ := strings.Cut(parseErr.Error(), ": ")
+				_, message, _ = strings.Cut(message, ": ")
+				check.errs = append(check.errs, ConfigError{Line: parseErr.Position.Line, Message: "invalid TOML: " + message})
+			} else {
+				check.errs = append(check.errs, ConfigError{Message: "invalid TOML: " + err.Error()})
+			}
+			return nil, lines
+		}
+		value = table
+	}
+	valuePaths("", value, lines)
+	if value == nil {
+		// Пустой файл разбирается как пустой JSON документ, об этом сообщит decodeConfig
+		return nil, lines
+	}
+	plan, err := json.Marshal(value)
+	if err != nil {
+		check.errs = append(check.errs, ConfigError{Message: fmt.Sprintf("unsupported %s value: %s", strings.ToUpper(format), err)})
+		return nil, lines
+	}
+	return plan, lines
+}
+
+// yamlLines записывает номера строк значений YAML документа node по пути к ним
+func yamlLines(path string, node *yaml.Node, lines map[string]int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			yamlLines(path, child, lines)
+		}
+		return
+	case yaml.AliasNode:
+		yamlLines(path, node.Alias, lines)
+	case yaml.MappingNode:
+		// Содержимое карты - чередующиеся ключи и значения
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			yamlLines(fieldPath(path, node.Content[i].Value), node.Content[i+1], lines)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			yamlLines(indexPath(path, i), child, lines)
+		}
+	}
+	if path != "" {
+		lines[path] = node.Line
+	}
+}
+
+// yamlErrorLine выделяет номер строки из ошибки разбора YAML ("yaml: line 3: ...")
+func yamlErrorLine(err error) int {
+	var line int
+	if _, scanErr := fmt.Sscanf(err.Error(), "yaml: line %d:", &line); scanErr != nil {
+		return 0
+	}
+	return line
+}
+
+// valuePaths отмечает пути всех значений документа value как заданные в файле;
+// уже известные номера строк не изменяются
+func valuePaths(path string, value any, lines map[string]int) {
+	if path != "" {
+		if _, ok := lines[path]; !ok {
+			lines[path] = 0
+		}
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		for key, child := range value {
+			valuePaths(fieldPath(path, key), child, lines)
+		}
+	case []any:
+		for i, child := range value {
+			valuePaths(indexPath(path, i), child, lines)
+		}
+	case []map[string]any:
+		for i, child := range value {
+			valuePaths(indexPath(path, i), child, lines)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package pdns
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"main/pkg/contain"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigInclude - подключаемый файл конфигурации (include, conf.d) с группами DNS серверов.
+// Позволяет хранить группы разных дата-центров в отдельных файлах, которыми владеют разные команды.
+type ConfigInclude struct {
+	ProbeDefaults *ProbeSettings `json:"probeDefaults"`             // Тайм-ауты и повторы запросов по умолчанию для групп этого файла (дополняются probeDefaults основного файла)
+	GroupsDNS     []GroupDNS     `json:"groupsDns" validate:"dive"` // Список групп DNS серверов
+}
+
+// includeExtensions - расширения файлов, подключаемых из каталога (conf.d); остальные файлы каталога пропускаются
+var includeExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// includeFiles возвращает подключаемые файлы по шаблонам patterns в порядке подключения.
+// Относительные шаблоны отсчитываются от каталога файла конфигурации path. Каталог подключает все файлы
+// конфигурации в нем (includeExtensions). Файлы одного шаблона подключаются в порядке имен, файл,
+// найденный по нескольким шаблонам, подключается один раз. Шаблон без совпадений - не ошибка,
+// а отсутствующий файл, заданный без символов шаблона, - ошибка. Ошибки шаблонов добавляются в check.
+func includeFiles(path string, patterns []string, check *configCheck) []string {
+	var files []string
+	seen := map[string]bool{filepath.Clean(path): true}
+	for i, pattern := range patterns {
+		if pattern == "" {
+			check.addf(indexPath("include", i), "pattern must not be empty")
+			continue
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		directory := false
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			pattern, directory = filepath.Join(pattern, "*"), true
+		} else if err != nil && !strings.ContainsAny(pattern, `*?[\`) {
+			check.add(indexPath("include", i), err)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			check.addf(indexPath("include", i), "invalid pattern %q: %s", pattern, err)
+			continue
+		}
+		for _, file := range matches {
+			if info, err := os.Stat(file); err != nil || info.IsDir() || seen[file] {
+				continue
+			}
+			if directory && !contain.ContainString(includeExtensions, strings.ToLower(filepath.Ext(file))) {
+				continue
+			}
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// includeConfigs подключает к конфигурации conf файлы include основного файла path: группы подключенных
+// файлов добавляются после групп основного файла в порядке подключения. Каждый файл разбирается
+// и проверяется отдельно, его ошибки указываются с именем файла; проверка итоговой конфигурации
+// (например, уникальность имен групп) выполняется после подключения всех файлов.
+func includeConfigs(path string, conf *Config, check *configCheck) {
+	files := includeFiles(path, conf.Include, check)
+	if len(files) > 0 {
+		check.path = path
+	}
+	check.origins = make([]groupOrigin, len(conf.GroupsDNS))
+	for _, file := range files {
+		slog.Debug("Including configuration file", slog.String("configFilePath", file))
+		include, fileCheck := loadInclude(file)
+		for _, configErr := range fileCheck.errs {
+			configErr.File = file
+			check.errs = append(check.errs, configErr)
+		}
+		if len(fileCheck.errs) > 0 {
+			continue
+		}
+		for i, group := range include.GroupsDNS {
+			if include.ProbeDefaults != nil {
+				group.Probe = group.Probe.merge(include.ProbeDefaults)
+			}
+			conf.GroupsDNS = append(conf.GroupsDNS, group)
+			check.origins = append(check.origins, groupOrigin{file: file, index: i, check: fileCheck})
+		}
+	}
+}
+
+// loadInclude читает и разбирает подключаемый файл. Здесь проверяются только настройки probeDefaults файла,
+// группы проверяются в составе итоговой конфигурации.
+func loadInclude(file string) (*ConfigInclude, *configCheck) {
+	var include ConfigInclude
+	check := &configCheck{}
+	format, err := configFormat(file, "")
+	if err == nil {
+		var data []byte
+		if data, err = os.ReadFile(file); err == nil {
+			data = check.parse(data, format)
+			if check.err() == nil {
+				decodeConfig(data, &include, check)
+			}
+		}
+	}
+	if err != nil {
+		check.errs = append(check.errs, ConfigError{Message: err.Error()})
+	}
+	if check.err() == nil {
+		validateTags(&ConfigInclude{ProbeDefaults: include.ProbeDefaults}, check)
+		include.ProbeDefaults.validate(check, "probeDefaults")
+	}
+	return &include, check
+}
+
+// configSum возвращает контрольную сумму содержимого файла конфигурации path и файлов,
+// подключаемых по шаблонам include, чтобы отслеживать изменения всех файлов конфигурации,
+// в том числе появление и удаление файлов в каталоге conf.d
+func configSum(path string, include []string) [sha256.Size]byte {
+	hash := sha256.New()
+	files := includeFiles(path, include, &configCheck{})
+	for _, file := range append([]string{path}, files...) {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", file, len(data))
+		hash.Write(data)
+	}
+	var sum [sha256.Size]byte
+	hash.Sum(sum[:0])
+	return sum
+}
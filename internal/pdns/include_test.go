@@ -0,0 +1,174 @@
+package pdns
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFiles создает в каталоге dir файлы с содержимым files по относительным путям
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestIncludeConfigs проверяет порядок подключения файлов, пропуск посторонних файлов каталога
+// и дополнение probeDefaults подключенного файла настройками основного файла
+func TestIncludeConfigs(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yaml": `include: [conf.d, "extra/*.json", "missing/*.yaml"]
+probeDefaults: {retries: 1, dialTimeout: 3s}
+groupsDns:
+  - groupName: main
+    dnsServers: [{serverID: m1, IP: 192.0.2.1, requestedRecord: example.com}]
+`,
+		"conf.d/20-msa.toml": `[[groupsDns]]
+groupName = "msa"
+[[groupsDns.dnsServers]]
+serverID = "msa1"
+IP = "192.0.2.20"
+requestedRecord = "example.com"
+`,
+		"conf.d/10-ny.yaml": `probeDefaults: {retries: 3}
+groupsDns:
+  - groupName: ny
+    dnsServers:
+      - {serverID: ny1, IP: 192.0.2.10, requestedRecord: example.com}
+      - {serverID: ny2, IP: 192.0.2.11, requestedRecord: example.com, probe: {retries: 5}}
+`,
+		"conf.d/README.txt": `not a configuration file`,
+		"extra/lon.json":    `{"groupsDns": [{"groupName": "lon", "dnsServers": [{"serverID": "lon1", "IP": "192.0.2.30", "requestedRecord": "example.com"}]}]}`,
+	})
+	conf, err := LoadConfig(ConfigSource{Path: filepath.Join(dir, "config.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	retries := make(map[string]int)
+	dial := make(map[string]Duration)
+	for _, group := range conf.GroupsDNS {
+		names = append(names, group.GroupName)
+		for _, server := range group.DNSServers {
+			retries[server.ServerID] = *server.Probe.Retries
+			dial[server.ServerID] = *server.Probe.DialTimeout
+		}
+	}
+	if got := strings.Join(names, ","); got != "main,ny,msa,lon" {
+		t.Errorf("groups %s, want main,ny,msa,lon", got)
+	}
+	for serverID, want := range map[string]int{"m1": 1, "ny1": 3, "ny2": 5, "msa1": 1, "lon1": 1} {
+		if retries[serverID] != want {
+			t.Errorf("server %s: retries %d, want %d", serverID, retries[serverID], want)
+		}
+		if dial[serverID] != Duration(3*time.Second) {
+			t.Errorf("server %s: dialTimeout %v, want 3s from the main file", serverID, dial[serverID])
+		}
+	}
+}
+
+// TestIncludeConfigsErrors проверяет ошибки подключения: отсутствующий файл, ошибки в подключенном файле
+// с его именем и строкой, ошибки итоговой конфигурации в группах из подключенных файлов
+func TestIncludeConfigsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string // Ожидаемые ошибки; "DIR" заменяется каталогом файлов
+	}{
+		{
+			name: "file errors",
+			files: map[string]string{
+				"config.yaml":   "include: [conf.d, missing.yaml]\ngroupsDns: []\n",
+				"conf.d/b.yaml": "probeDefaults: {retries: 20}\ngroupsDns: []\n",
+			},
+			want: []string{
+				"line 1: include[1]: stat DIR/missing.yaml",
+				"DIR/conf.d/b.yaml: line 1: probeDefaults.retries: must be at most 10, got 20",
+			},
+		},
+		{
+			name: "merged configuration errors",
+			files: map[string]string{
+				"config.yaml": `include: [conf.d]
+groupsDns:
+  - groupName: ny
+    dnsServers: [{serverID: s1, IP: 192.0.2.1, requestedRecord: example.com}]
+`,
+				"conf.d/a.yaml": `groupsDns:
+  - groupName: ny
+    dnsServers: [{serverID: s2, IP: 192.0.2.2, requestedRecord: example.com}]
+  - groupName: bad
+    dnsServers:
+      - serverID: s3
+        IP: 999.1.1.1
+        requestedRecord: example.com
+`,
+			},
+			want: []string{
+				`DIR/conf.d/a.yaml: line 2: groupsDns[0].groupName: duplicate group name "ny"`,
+				`DIR/conf.d/a.yaml: line 7: groupsDns[1].dnsServers[0].IP: "999.1.1.1" is not a valid IP address or hostname`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			_, err := LoadConfig(ConfigSource{Path: filepath.Join(dir, "config.yaml")})
+			var errs ConfigErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("error %v is not ConfigErrors", err)
+			}
+			for _, want := range tt.want {
+				want = strings.ReplaceAll(want, "DIR", dir)
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("missing error %q in:\n%s", want, err)
+				}
+			}
+		})
+	}
+}
+
+// TestConfigSum проверяет, что контрольная сумма конфигурации меняется при изменении, добавлении
+// и удалении подключенных файлов
+func TestConfigSum(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	include := []string{"conf.d"}
+	writeFiles(t, dir, map[string]string{"config.yaml": "include: [conf.d]\n", "conf.d/a.yaml": "groupsDns: []\n"})
+	sum := configSum(path, include)
+
+	steps := []struct {
+		name   string
+		change func()
+	}{
+		{"file added", func() { writeFiles(t, dir, map[string]string{"conf.d/b.yaml": "groupsDns: []\n"}) }},
+		{"file changed", func() { writeFiles(t, dir, map[string]string{"conf.d/a.yaml": "groupsDns: [] # changed\n"}) }},
+		{"file removed", func() { os.Remove(filepath.Join(dir, "conf.d", "b.yaml")) }},
+		{"main file changed", func() { writeFiles(t, dir, map[string]string{"config.yaml": "include: [conf.d] # changed\n"}) }},
+	}
+	for _, step := range steps {
+		step.change()
+		next := configSum(path, include)
+		if next == sum {
+			t.Errorf("%s: checksum did not change", step.name)
+		}
+		sum = next
+	}
+	writeFiles(t, dir, map[string]string{"conf.d/notes.txt": "ignored"})
+	if configSum(path, include) != sum {
+		t.Error("checksum changed after adding a file that is not included")
+	}
+}
@@ -0,0 +1,74 @@
+package pdns
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// DefaultShutdownTimeout - время на завершение начатых проверок и HTTP запросов при остановке, если оно не задано в конфигурации
+const DefaultShutdownTimeout = 15 * time.Second
+
+// Ошибки Run, по которым вызывающий определяет код завершения процесса.
+// Возвращаемая ошибка оборачивает одну из них вместе с причиной.
+var (
+	ErrConfig          = errors.New("invalid configuration")       // Конфигурация не прочитана или некорректна
+	ErrStartup         = errors.New("startup failed")              // Не открыты выходы логов, файл состояния или адреса HTTP сервера
+	ErrServer          = errors.New("HTTP server failed")          // HTTP сервер остановился с ошибкой во время работы
+	ErrShutdownTimeout = errors.New("graceful shutdown timed out") // Начатые проверки или запросы не завершились за shutdownTimeout
+)
+
+// shutdownTimeout возвращает время на корректную остановку: из конфигурации или DefaultShutdownTimeout
+func (conf *Config) shutdownTimeout() time.Duration {
+	if conf.ShutdownTimeout > 0 {
+		return time.Duration(conf.ShutdownTimeout)
+	}
+	return DefaultShutdownTimeout
+}
+
+// shutdownServers останавливает HTTP серверы: закрывает адреса и ожидает завершения начатых запросов
+// до отмены ctx, после чего закрывает оставшиеся соединения и возвращает ошибку ctx
+func shutdownServers(ctx context.Context, servers []*http.Server) error {
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			err := server.Shutdown(ctx)
+			if err != nil {
+				server.Close()
+			}
+			errs <- err
+		}(server)
+	}
+	var result error
+	for range servers {
+		if err := <-errs; err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// shutdown корректно останавливает демон за время timeout: HTTP серверы перестают принимать соединения
+// и завершают начатые запросы, а планировщик перестает начинать проверки и завершает начатые.
+// Если что-то не завершилось вовремя, оно прерывается и возвращается ErrShutdownTimeout.
+func shutdown(timeout time.Duration, servers []*http.Server, scheduler *Scheduler) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	started := time.Now()
+	serversErr := make(chan error, 1)
+	go func() {
+		serversErr <- shutdownServers(ctx, servers)
+	}()
+	var schedulerErr error
+	if scheduler != nil {
+		schedulerErr = scheduler.Shutdown(ctx)
+	}
+	if err := errors.Join(<-serversErr, schedulerErr); err != nil {
+		slog.Error("Graceful shutdown timed out, running checks and requests were aborted", slog.Duration("timeout", timeout), slog.String("error", err.Error()))
+		return ErrShutdownTimeout
+	}
+	slog.Info("Shutdown complete", slog.Duration("duration", time.Since(started)))
+	return nil
+}
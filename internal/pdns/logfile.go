@@ -0,0 +1,221 @@
+package pdns
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat - формат времени в имени ротированного файла ("dnsexporter-20261016T150405.000.log")
+const rotatedTimeFormat = "20060102T150405.000"
+
+// rotatingFile - файл логов с ротацией по размеру и по времени.
+// При ротации текущий файл переименовывается с добавлением времени ротации в имя и создается новый;
+// ротированные файлы сжимаются gzip и удаляются сверх maxBackups или старше maxBackupAge.
+type rotatingFile struct {
+	mu      sync.Mutex    // Последовательная запись в файл
+	path    string        // Путь к текущему файлу логов
+	output  LogOutput     // Параметры ротации
+	file    *os.File      // Текущий файл
+	size    int64         // Размер текущего файла
+	opened  time.Time     // Время открытия текущего файла (для ротации по времени)
+	cleanup chan struct{} // Сигнал фоновой горутине сжать и удалить ротированные файлы
+	stop    sync.Once     // Однократное закрытие cleanup
+	done    chan struct{} // Закрывается после завершения фоновой горутины
+}
+
+// openRotatingFile открывает (или создает) файл логов output.Path с параметрами ротации output
+func openRotatingFile(output LogOutput) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:    output.Path,
+		output:  output,
+		cleanup: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	go f.runCleanup()
+	// Файлы, оставшиеся от предыдущего запуска, тоже подлежат сжатию и удалению
+	f.cleanup <- struct{}{}
+	return f, nil
+}
+
+// open открывает файл для записи, создает его, если он не существует, и добавляет новые записи в конец
+func (f *rotatingFile) open() error {
+	file, size, err := openLogFile(f.path)
+	if err != nil {
+		return err
+	}
+	f.file, f.size, f.opened = file, size, time.Now()
+	return nil
+}
+
+// openLogFile открывает файл path для добавления записей и возвращает его текущий размер
+func openLogFile(path string) (*os.File, int64, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// Write записывает запись лога, предварительно ротируя файл, если он превысит размер или устарел
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.needRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			// Продолжаем писать в текущий файл, чтобы не потерять записи. Ошибка выводится в stderr,
+			// так как запись в лог снова вызвала бы Write.
+			fmt.Fprintf(os.Stderr, "log file %s rotation failed: %s\n", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// needRotate проверяет, нужно ли ротировать файл перед записью n байт
+func (f *rotatingFile) needRotate(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if maxSize := int64(f.output.MaxSizeMB) << 20; maxSize > 0 && f.size+int64(n) > maxSize {
+		return true
+	}
+	return f.output.RotateEvery > 0 && time.Since(f.opened) >= time.Duration(f.output.RotateEvery)
+}
+
+// rotate переименовывает текущий файл и открывает новый. Прежний файл закрывается только после того,
+// как новый открыт: если переименовать или открыть файл не удалось, запись продолжается в прежний.
+func (f *rotatingFile) rotate() error {
+	ext := filepath.Ext(f.path)
+	rotated := strings.TrimSuffix(f.path, ext) + "-" + time.Now().Format(rotatedTimeFormat) + ext
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	file, size, err := openLogFile(f.path)
+	if err != nil {
+		// Возвращаем прежнее имя, чтобы записи продолжали попадать в файл path
+		if renameErr := os.Rename(rotated, f.path); renameErr != nil {
+			return fmt.Errorf("%w (restoring %s: %w)", err, f.path, renameErr)
+		}
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "log file %s close failed: %s\n", rotated, err)
+	}
+	f.file, f.size, f.opened = file, size, time.Now()
+	select {
+	case f.cleanup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close закрывает файл и дожидается завершения сжатия и удаления ротированных файлов.
+// Повторный вызов ничего не делает.
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stop.Do(func() { close(f.cleanup) })
+	<-f.done
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// runCleanup сжимает и удаляет ротированные файлы по сигналу cleanup, пока канал не будет закрыт
+func (f *rotatingFile) runCleanup() {
+	defer close(f.done)
+	for range f.cleanup {
+		if err := f.removeBackups(); err != nil {
+			fmt.Fprintf(os.Stderr, "log file %s cleanup failed: %s\n", f.path, err)
+		}
+	}
+}
+
+// removeBackups сжимает несжатые ротированные файлы (если включено compress) и удаляет файлы
+// сверх maxBackups (начиная со старых) и старше maxBackupAge
+func (f *rotatingFile) removeBackups() error {
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(f.path, ext) + "-"
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, name := range matches {
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		if _, err := time.Parse(rotatedTimeFormat, stamp); err == nil {
+			backups = append(backups, name)
+		}
+	}
+	// Время ротации в имени файла, поэтому сортировка по имени - от старых к новым
+	sort.Strings(backups)
+	for i, name := range backups {
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		tooMany := f.output.MaxBackups > 0 && i < len(backups)-f.output.MaxBackups
+		tooOld := f.output.MaxBackupAge > 0 && time.Since(info.ModTime()) > time.Duration(f.output.MaxBackupAge)
+		if tooMany || tooOld {
+			os.Remove(name)
+			continue
+		}
+		if f.output.Compress && !strings.HasSuffix(name, ".gz") {
+			if err := compressFile(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compressFile сжимает файл name в name.gz и удаляет исходный файл
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,202 @@
+package pdns
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// backupName возвращает имя ротированного файла для логов dir/dns.log со временем ротации at
+func backupName(dir string, at time.Time) string {
+	return filepath.Join(dir, "dns-"+at.Format(rotatedTimeFormat)+".log")
+}
+
+// listDir возвращает отсортированные имена файлов каталога dir
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TestRotatingFileRotate проверяет ротацию по размеру и по времени: записи не теряются,
+// а новая запись попадает в новый файл
+func TestRotatingFileRotate(t *testing.T) {
+	chunk := bytes.Repeat([]byte("x"), 600<<10)
+	tests := []struct {
+		name   string
+		output LogOutput
+		before func(f *rotatingFile)
+	}{
+		{"size", LogOutput{MaxSizeMB: 1}, func(*rotatingFile) {}},
+		{"time", LogOutput{RotateEvery: Duration(time.Hour)}, func(f *rotatingFile) { f.opened = time.Now().Add(-2 * time.Hour) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.output.Path = filepath.Join(dir, "dns.log")
+			f, err := openRotatingFile(tt.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.Write(chunk); err != nil {
+				t.Fatal(err)
+			}
+			tt.before(f)
+			if _, err := f.Write(chunk); err != nil {
+				t.Fatal(err)
+			}
+			names := listDir(t, dir)
+			if len(names) != 2 || names[1] != "dns.log" {
+				t.Fatalf("files %v, want one rotated file and dns.log", names)
+			}
+			for _, name := range names {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() != int64(len(chunk)) {
+					t.Errorf("%s: size %d, want %d", name, info.Size(), len(chunk))
+				}
+			}
+		})
+	}
+}
+
+// TestRotatingFileRotateFailure проверяет, что при ошибке ротации запись продолжается в текущий файл
+func TestRotatingFileRotateFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openRotatingFile(LogOutput{Path: filepath.Join(dir, "dns.log"), MaxSizeMB: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(bytes.Repeat([]byte("x"), 600<<10)); err != nil {
+		t.Fatal(err)
+	}
+	// Без каталога файл не переименовать: ротация не удастся
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := f.Write(bytes.Repeat([]byte("y"), 600<<10)); err != nil {
+			t.Fatalf("write %d after failed rotation: %v", i, err)
+		}
+	}
+}
+
+// TestRotatingFileCleanup проверяет удаление ротированных файлов сверх maxBackups и старше maxBackupAge
+// и сжатие оставшихся
+func TestRotatingFileCleanup(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		output LogOutput
+		want   func(names []string) []string // Ожидаемые файлы по именам ротированных файлов от старых к новым
+	}{
+		{"keep all", LogOutput{}, func(names []string) []string { return names }},
+		{"max backups", LogOutput{MaxBackups: 2}, func(names []string) []string { return names[2:] }},
+		{"max age", LogOutput{MaxBackupAge: Duration(90 * time.Minute)}, func(names []string) []string { return names[2:] }},
+		{"compress", LogOutput{MaxBackups: 3, Compress: true}, func(names []string) []string {
+			return []string{names[1] + ".gz", names[2] + ".gz", names[3] + ".gz"}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// Ротированные файлы четырехчасовой, трехчасовой, часовой и минутной давности
+			var names []string
+			for _, age := range []time.Duration{4 * time.Hour, 3 * time.Hour, time.Hour, time.Minute} {
+				name := backupName(dir, now.Add(-age))
+				writeTestFile(t, name, []byte(filepath.Base(name)))
+				if err := os.Chtimes(name, now.Add(-age), now.Add(-age)); err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, filepath.Base(name))
+			}
+			writeTestFile(t, filepath.Join(dir, "dns-other.log"), nil) // Не ротированный файл не удаляется
+
+			tt.output.Path = filepath.Join(dir, "dns.log")
+			f, err := openRotatingFile(tt.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Close дожидается завершения очистки, запущенной при открытии
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			want := append(tt.want(names), "dns-other.log", "dns.log")
+			slices.Sort(want)
+			if got := listDir(t, dir); !slices.Equal(got, want) {
+				t.Fatalf("files %v, want %v", got, want)
+			}
+			if !tt.output.Compress {
+				return
+			}
+			for _, name := range tt.want(names) {
+				if got := readGzip(t, filepath.Join(dir, name)); got != name[:len(name)-len(".gz")] {
+					t.Errorf("%s contains %q", name, got)
+				}
+			}
+		})
+	}
+}
+
+// readGzip возвращает распакованное содержимое файла name
+func readGzip(t *testing.T, name string) string {
+	t.Helper()
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestRotatingFileClose проверяет, что Close завершает фоновую горутину, а повторный вызов и запись после
+// закрытия не приводят к панике
+func TestRotatingFileClose(t *testing.T) {
+	f, err := openRotatingFile(LogOutput{Path: filepath.Join(t.TempDir(), "dns.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-f.done:
+	default:
+		t.Error("cleanup goroutine is still running after Close")
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if _, err := f.Write([]byte("x")); err != os.ErrClosed {
+		t.Errorf("Write after Close: %v, want %v", err, os.ErrClosed)
+	}
+}
@@ -1,105 +1,434 @@
-package pdns
-
-import (
-	"log/slog"   // Импортируем стандартный логгер из пакета log/slog для логирования
-	"log/syslog" // Импортируем пакет для работы с системным журналом syslog
-	"os"         // Импортируем пакет для работы с операционной системой, в частности с файлами
-)
-
-func initLogger(logPath, logLevel string, LogToSyslog, LogToFile bool) {
-	// Устанавливаем уровень логирования (DEBUG, INFO, WARN, ERROR)
-	// В зависимости от переданного logLevel, определяется уровень детализации логирования
-	var level slog.Level
-	var src bool
-	switch logLevel {
-	case "DEBUG":
-		level = slog.LevelDebug // Для уровня DEBUG включаем более подробное логирование
-		src = true              // Включаем информацию о файле и строке (источник)
-	case "INFO":
-		level = slog.LevelInfo // Для уровня INFO логируются основные сообщения
-		src = false            // Не добавляем информацию о файле и строке
-	case "WARN":
-		level = slog.LevelWarn // Для уровня WARN логируются предупреждения
-		src = false            // Не добавляем информацию о файле и строке
-	case "ERROR":
-		level = slog.LevelError // Для уровня ERROR логируются только ошибки
-		src = false             // Не добавляем информацию о файле и строке
-	}
-
-	// Переменная для хранения обработчика логов, который будет использоваться для записи сообщений
-	var handler slog.Handler
-	var err error
-
-	// Проверяем, что параметры логирования корректно настроены:
-	// Если оба флага (LogToFile и LogToSyslog) установлены в true или оба в false,
-	// выводим предупреждающее сообщение о неправильной настройке.
-	if LogToFile && LogToSyslog {
-		// Если оба флага установлены в true, выводим предупреждение.
-		slog.Warn("The logging parameters are incorrectly configured (logToSyslog and logToFile should not be set to both false or both true; by default, syslog is used).")
-	} else if !LogToFile && !LogToSyslog {
-		// Если оба флага установлены в false, выводим предупреждение.
-		slog.Warn("The logging parameters are incorrectly configured (logToSyslog and logToFile should not be set to both false or both true; by default, syslog is used).")
-	}
-
-	// Используем switch для выбора логирования в зависимости от значений LogToSyslog и LogToFile
-	// Важно, чтобы хотя бы один из флагов был установлен в true.
-	switch {
-	case LogToSyslog:
-		// Логируем только в syslog
-		// Создаем обработчик для syslog с уровнем LOG_INFO и меткой "dns-monitor"
-		syslogHandler, err := syslog.New(syslog.LOG_INFO, "dns-monitor")
-		if err != nil {
-			// Если произошла ошибка при создании обработчика, логируем ошибку
-			slog.Error(err.Error())
-		}
-		// Настроим обработчик для записи в syslog в формате JSON
-		handler = slog.NewJSONHandler(syslogHandler, &slog.HandlerOptions{
-			AddSource: src,   // Добавляем информацию о файле и строке в лог
-			Level:     level, // Устанавливаем уровень логирования
-		})
-
-	case LogToFile:
-		// Логируем только в файл
-		// Открываем файл для записи, создаем его, если он не существует, и добавляем новые записи в конец
-		var logFile *os.File
-		logFile, err = os.OpenFile(logPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
-		if err != nil {
-			// Если произошла ошибка при открытии файла, логируем ошибку
-			slog.Error(err.Error())
-		}
-		// Настроим обработчик для записи в файл в формате JSON
-		handler = slog.NewJSONHandler(logFile, &slog.HandlerOptions{
-			AddSource: src,   // Добавляем информацию о файле и строке в лог
-			Level:     level, // Устанавливаем уровень логирования
-		})
-
-	default:
-		// Если оба флага false, используем syslog по умолчанию
-		// Создаем обработчик для syslog с уровнем LOG_INFO и меткой "dns-monitor"
-		syslogHandler, err := syslog.New(syslog.LOG_INFO, "dns-monitor")
-		if err != nil {
-			// Если произошла ошибка при создании обработчика, логируем ошибку
-			slog.Error(err.Error())
-		}
-		// Настроим обработчик для записи в syslog в формате JSON
-		handler = slog.NewJSONHandler(syslogHandler, &slog.HandlerOptions{
-			AddSource: src,   // Добавляем информацию о файле и строке в лог
-			Level:     level, // Устанавливаем уровень логирования
-		})
-	}
-
-	// Создаем новый логгер с выбранным обработчиком
-	var logger = slog.New(handler)
-
-	// Добавляем теги к логгеру. Теги позволяют фильтровать и классифицировать логи.
-	// Пример:
-	// - "component" может использоваться для указания компонента приложения
-	// - "environment" может использоваться для указания окружения (например, "production" или "development")
-	logger = logger.With(
-		slog.String("component", "dns-group-monitor"), // Добавляем тег для компонента приложения
-		slog.String("environment", "production"),      // Добавляем тег для окружения (например, production, development)
-	)
-
-	// Устанавливаем созданный логгер как дефолтный для всей программы
-	slog.SetDefault(logger)
-}
+package pdns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"   // Импортируем стандартный логгер из пакета log/slog для логирования
+	"log/syslog" // Импортируем пакет для работы с системным журналом syslog
+	"os"         // Импортируем пакет для работы с операционной системой, в частности с файлами
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Типы выходов логов
+const (
+	LogOutputStdout = "stdout" // Стандартный вывод (например, в контейнере)
+	LogOutputStderr = "stderr" // Стандартный вывод ошибок
+	LogOutputFile   = "file"   // Файл с ротацией по размеру и времени
+	LogOutputSyslog = "syslog" // Локальный или удаленный syslog
+)
+
+// Форматы записей логов
+const (
+	LogFormatJSON   = "json"   // JSON объект на строку (по умолчанию)
+	LogFormatLogfmt = "logfmt" // key=value на строку
+	LogFormatText   = "text"   // Время, уровень и сообщение, затем атрибуты key=value (для чтения человеком)
+)
+
+// LogOutput - один выход логов со своим уровнем логирования.
+// Логи можно одновременно писать в несколько выходов, например INFO в stdout и DEBUG в файл.
+type LogOutput struct {
+	Type         string   `json:"type" validate:"required,oneof=stdout stderr file syslog"` // Тип выхода: stdout, stderr, file или syslog
+	Level        string   `json:"level" validate:"omitempty,oneof=DEBUG INFO WARN ERROR"`   // Уровень логирования выхода (по умолчанию logLevel)
+	Format       string   `json:"format" validate:"omitempty,oneof=json logfmt text"`       // Формат записей выхода (по умолчанию logFormat)
+	Path         string   `json:"path" validate:"required_if=Type file"`                    // file: путь к файлу логов
+	MaxSizeMB    int      `json:"maxSizeMB" validate:"min=0"`                               // file: размер файла в мегабайтах, после которого он ротируется (0 - без ограничения)
+	RotateEvery  Duration `json:"rotateEvery"`                                              // file: интервал ротации файла (например, "24h"; если не задан, файл ротируется только по размеру)
+	MaxBackups   int      `json:"maxBackups" validate:"min=0"`                              // file: количество хранимых ротированных файлов (0 - без ограничения)
+	MaxBackupAge Duration `json:"maxBackupAge"`                                             // file: максимальный возраст ротированных файлов (если не задан, файлы не удаляются по возрасту)
+	Compress     bool     `json:"compress"`                                                 // file: сжимать ротированные файлы gzip
+	Network      string   `json:"network" validate:"omitempty,oneof=udp tcp unix unixgram"` // syslog: протокол удаленного syslog (если не задан, используется локальный syslog)
+	Address      string   `json:"address" validate:"required_with=Network"`                 // syslog: адрес удаленного syslog ("logs.example.com:514")
+	Facility     string   `json:"facility"`                                                 // syslog: facility (daemon, local0..local7 и т.д.), по умолчанию daemon
+	Tag          string   `json:"tag"`                                                      // syslog: тег сообщений, по умолчанию dns-monitor
+}
+
+// logOutputs возвращает выходы логов с учетом устаревших параметров logToFile и logToSyslog:
+// если logOutputs не заданы, логи пишутся в файл logPath или в локальный syslog.
+func (conf *Config) logOutputs() []LogOutput {
+	if len(conf.LogOutputs) > 0 {
+		return conf.LogOutputs
+	}
+	if conf.LogToFile && !conf.LogToSyslog {
+		return []LogOutput{{Type: LogOutputFile, Path: conf.LogPath}}
+	}
+	if conf.LogToFile == conf.LogToSyslog {
+		// Если оба флага установлены в true или оба в false, выводим предупреждение; по умолчанию используется syslog
+		slog.Warn("The logging parameters are incorrectly configured (logToSyslog and logToFile should not be set to both false or both true; by default, syslog is used).")
+	}
+	return []LogOutput{{Type: LogOutputSyslog}}
+}
+
+// validate проверяет facility syslog, интервалы ротации и то, что параметры заданы для своего типа выхода
+// (обязательность полей проверяется тегами)
+func (output LogOutput) validate(check *configCheck, path string) {
+	if _, err := parseFacility(output.Facility); err != nil {
+		check.add(fieldPath(path, "facility"), err)
+	}
+	for _, duration := range []struct {
+		name  string
+		value Duration
+	}{{"rotateEvery", output.RotateEvery}, {"maxBackupAge", output.MaxBackupAge}} {
+		if duration.value < 0 {
+			check.addf(fieldPath(path, duration.name), "must not be negative, got %s", time.Duration(duration.value))
+		}
+	}
+	if output.Type != LogOutputFile && (output.Path != "" || output.MaxSizeMB != 0 || output.RotateEvery != 0 || output.MaxBackups != 0 || output.MaxBackupAge != 0 || output.Compress) {
+		check.addf(path, "path and rotation settings apply only to the file output")
+	}
+	if output.Type != LogOutputSyslog && (output.Network != "" || output.Address != "" || output.Facility != "" || output.Tag != "") {
+		check.addf(path, "network, address, facility and tag apply only to the syslog output")
+	}
+}
+
+// syslogFacilities - facility syslog по имени
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL, "daemon": syslog.LOG_DAEMON,
+	"auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG, "lpr": syslog.LOG_LPR, "news": syslog.LOG_NEWS,
+	"uucp": syslog.LOG_UUCP, "cron": syslog.LOG_CRON, "authpriv": syslog.LOG_AUTHPRIV, "ftp": syslog.LOG_FTP,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2, "local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5, "local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+// parseFacility преобразует имя facility syslog в его значение. Пустая строка означает daemon.
+func parseFacility(name string) (syslog.Priority, error) {
+	if name == "" {
+		return syslog.LOG_DAEMON, nil
+	}
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+	return facility, nil
+}
+
+// parseLogLevel преобразует уровень логирования (DEBUG, INFO, WARN, ERROR) в slog.Level; пустая строка означает INFO
+func parseLogLevel(name string) (slog.Level, error) {
+	switch name {
+	case "DEBUG":
+		return slog.LevelDebug, nil // Для уровня DEBUG включаем более подробное логирование
+	case "", "INFO":
+		return slog.LevelInfo, nil // Для уровня INFO логируются основные сообщения
+	case "WARN":
+		return slog.LevelWarn, nil // Для уровня WARN логируются предупреждения
+	case "ERROR":
+		return slog.LevelError, nil // Для уровня ERROR логируются только ошибки
+	}
+	return 0, fmt.Errorf("unknown log level %q (expected DEBUG, INFO, WARN or ERROR)", name)
+}
+
+// reservedLogKeys - ключи, которые slog добавляет к каждой записи; их нельзя задать в logAttributes
+var reservedLogKeys = []string{slog.TimeKey, slog.LevelKey, slog.MessageKey, slog.SourceKey}
+
+// DefaultLogComponent - значение атрибута component каждой записи лога, если он не задан в logAttributes
+const DefaultLogComponent = "dns-group-monitor"
+
+// logAttributes возвращает постоянные атрибуты записей логов: component и атрибуты из logAttributes,
+// отсортированные по имени
+func (conf *Config) logAttributes() []any {
+	attrs := []any{slog.String("component", DefaultLogComponent)}
+	keys := make([]string, 0, len(conf.LogAttributes))
+	for key := range conf.LogAttributes {
+		if key == "component" {
+			attrs = attrs[:0]
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		attrs = append(attrs, slog.String(key, conf.LogAttributes[key]))
+	}
+	return attrs
+}
+
+// initLogger открывает выходы логов из конфигурации conf и устанавливает логгер, пишущий во все выходы,
+// как логгер по умолчанию для всей программы. Возвращает Logging для изменения уровней во время работы
+// и закрытия открытых файлов и соединений.
+func initLogger(conf *Config) (*Logging, error) {
+	var handlers []slog.Handler
+	logging := &Logging{}
+	for _, output := range conf.logOutputs() {
+		levelName := output.Level
+		if levelName == "" {
+			levelName = conf.LogLevel
+		}
+		// Уровень уже проверен при загрузке конфигурации
+		level, _ := parseLogLevel(levelName)
+		levelVar := logging.addOutput(output, level) // Уровень выхода, который можно изменить во время работы
+		format := output.Format
+		if format == "" {
+			format = conf.LogFormat
+		}
+		handler, closer, err := openLogOutput(output, debugSourceHandler(levelVar, format, &slog.HandlerOptions{Level: levelVar}))
+		if err != nil {
+			logging.Close()
+			return nil, fmt.Errorf("log output %s: %w", output.Type, err)
+		}
+		handlers = append(handlers, handler)
+		if closer != nil {
+			logging.closers = append(logging.closers, closer)
+		}
+	}
+
+	// Создаем новый логгер, который передает записи во все выходы, и добавляем к нему постоянные атрибуты,
+	// по которым можно фильтровать и классифицировать логи (component, environment и т.д.)
+	logger := slog.New(multiHandler(handlers)).With(conf.logAttributes()...)
+
+	// Устанавливаем созданный логгер как дефолтный для всей программы
+	slog.SetDefault(logger)
+	return logging, nil
+}
+
+// logHandler возвращает конструктор обработчиков записей в формате format (json, logfmt или text)
+func logHandler(format string, options *slog.HandlerOptions) func(w io.Writer) slog.Handler {
+	return func(w io.Writer) slog.Handler {
+		switch format {
+		case LogFormatLogfmt:
+			return slog.NewTextHandler(w, options)
+		case LogFormatText:
+			return newTextHandler(w, options)
+		}
+		return slog.NewJSONHandler(w, options)
+	}
+}
+
+// debugSourceHandler возвращает конструктор обработчиков записей в формате format, которые добавляют
+// информацию о файле и строке, пока уровень выхода level - DEBUG
+func debugSourceHandler(level *slog.LevelVar, format string, options *slog.HandlerOptions) func(w io.Writer) slog.Handler {
+	sourceOptions := *options
+	sourceOptions.AddSource = true
+	plain, source := logHandler(format, options), logHandler(format, &sourceOptions)
+	return func(w io.Writer) slog.Handler {
+		return &sourceHandler{level: level, plain: plain(w), source: source(w)}
+	}
+}
+
+// openLogOutput открывает выход логов и создает для него обработчик с помощью newHandler
+func openLogOutput(output LogOutput, newHandler func(w io.Writer) slog.Handler) (slog.Handler, io.Closer, error) {
+	switch output.Type {
+	case LogOutputStdout:
+		return newHandler(os.Stdout), nil, nil
+	case LogOutputStderr:
+		return newHandler(os.Stderr), nil, nil
+	case LogOutputFile:
+		file, err := openRotatingFile(output)
+		if err != nil {
+			return nil, nil, err
+		}
+		return newHandler(file), file, nil
+	case LogOutputSyslog:
+		facility, err := parseFacility(output.Facility)
+		if err != nil {
+			return nil, nil, err
+		}
+		tag := output.Tag
+		if tag == "" {
+			tag = "dns-monitor"
+		}
+		// Без адреса syslog.Dial подключается к локальному syslog
+		writer, err := syslog.Dial(output.Network, output.Address, facility|syslog.LOG_INFO, tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		return newSyslogHandler(writer, newHandler), writer, nil
+	}
+	return nil, nil, fmt.Errorf("unknown log output type %q", output.Type)
+}
+
+// syslogHandler передает записи в syslog с важностью (severity), соответствующей уровню записи.
+// Для каждой важности используется свой обработчик, пишущий через соответствующий метод syslog.Writer.
+type syslogHandler struct {
+	debug, info, warn, err slog.Handler
+}
+
+// newSyslogHandler создает обработчик записей для syslog writer
+func newSyslogHandler(writer *syslog.Writer, newHandler func(w io.Writer) slog.Handler) *syslogHandler {
+	return &syslogHandler{
+		debug: newHandler(severityWriter(writer.Debug)),
+		info:  newHandler(severityWriter(writer.Info)),
+		warn:  newHandler(severityWriter(writer.Warning)),
+		err:   newHandler(severityWriter(writer.Err)),
+	}
+}
+
+// severityWriter - метод syslog.Writer, записывающий сообщение с определенной важностью
+type severityWriter func(message string) error
+
+// Write записывает одну запись лога в syslog
+func (write severityWriter) Write(p []byte) (int, error) {
+	if err := write(strings.TrimSuffix(string(p), "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// handler возвращает обработчик для важности, соответствующей уровню level
+func (h *syslogHandler) handler(level slog.Level) slog.Handler {
+	switch {
+	case level >= slog.LevelError:
+		return h.err
+	case level >= slog.LevelWarn:
+		return h.warn
+	case level >= slog.LevelInfo:
+		return h.info
+	}
+	return h.debug
+}
+
+// Enabled реализует интерфейс slog.Handler
+func (h *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler(level).Enabled(ctx, level)
+}
+
+// Handle реализует интерфейс slog.Handler
+func (h *syslogHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler(record.Level).Handle(ctx, record)
+}
+
+// WithAttrs реализует интерфейс slog.Handler
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{h.debug.WithAttrs(attrs), h.info.WithAttrs(attrs), h.warn.WithAttrs(attrs), h.err.WithAttrs(attrs)}
+}
+
+// WithGroup реализует интерфейс slog.Handler
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{h.debug.WithGroup(name), h.info.WithGroup(name), h.warn.WithGroup(name), h.err.WithGroup(name)}
+}
+
+// multiHandler передает каждую запись во все обработчики, уровень которых позволяет ее записать
+type multiHandler []slog.Handler
+
+// Enabled реализует интерфейс slog.Handler: запись нужна, если ее принимает хотя бы один обработчик
+func (handlers multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle реализует интерфейс slog.Handler. Ошибка одного выхода не мешает записи в остальные.
+func (handlers multiHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, handler := range handlers {
+		if handler.Enabled(ctx, record.Level) {
+			if err := handler.Handle(ctx, record.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WithAttrs реализует интерфейс slog.Handler
+func (handlers multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	result := make(multiHandler, 0, len(handlers))
+	for _, handler := range handlers {
+		result = append(result, handler.WithAttrs(attrs))
+	}
+	return result
+}
+
+// WithGroup реализует интерфейс slog.Handler
+func (handlers multiHandler) WithGroup(name string) slog.Handler {
+	result := make(multiHandler, 0, len(handlers))
+	for _, handler := range handlers {
+		result = append(result, handler.WithGroup(name))
+	}
+	return result
+}
+
+// sourceHandler передает запись обработчику с информацией о файле и строке, если текущий уровень выхода - DEBUG,
+// и обработчику без нее в остальных случаях. Уровень проверяется для каждой записи, поэтому источник
+// появляется и при включении DEBUG во время работы (через API или SIGUSR1) и пропадает при возврате уровня.
+type sourceHandler struct {
+	level  *slog.LevelVar // Действующий уровень выхода
+	plain  slog.Handler   // Обработчик без источника записи
+	source slog.Handler   // Обработчик с источником записи
+}
+
+// Enabled реализует интерфейс slog.Handler
+func (h *sourceHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.plain.Enabled(ctx, level)
+}
+
+// Handle реализует интерфейс slog.Handler
+func (h *sourceHandler) Handle(ctx context.Context, record slog.Record) error {
+	if h.level.Level() <= slog.LevelDebug {
+		return h.source.Handle(ctx, record)
+	}
+	return h.plain.Handle(ctx, record)
+}
+
+// WithAttrs реализует интерфейс slog.Handler
+func (h *sourceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sourceHandler{level: h.level, plain: h.plain.WithAttrs(attrs), source: h.source.WithAttrs(attrs)}
+}
+
+// WithGroup реализует интерфейс slog.Handler
+func (h *sourceHandler) WithGroup(name string) slog.Handler {
+	return &sourceHandler{level: h.level, plain: h.plain.WithGroup(name), source: h.source.WithGroup(name)}
+}
+
+// textHandler записывает записи для чтения человеком: время, уровень и сообщение,
+// затем атрибуты в формате key=value ("2024-06-01T10:00:00.000Z WARN  DNS request failed. serverID=ny-1")
+type textHandler struct {
+	mu    *sync.Mutex   // Последовательная запись (общая для обработчиков, созданных WithAttrs и WithGroup)
+	w     io.Writer     // Выход логов
+	buf   *bytes.Buffer // Атрибуты текущей записи
+	attrs slog.Handler  // Обработчик logfmt, записывающий в buf только атрибуты
+}
+
+// newTextHandler создает обработчик записей для чтения человеком
+func newTextHandler(w io.Writer, options *slog.HandlerOptions) *textHandler {
+	buf := &bytes.Buffer{}
+	attrsOptions := *options
+	attrsOptions.ReplaceAttr = func(groups []string, attr slog.Attr) slog.Attr {
+		// Время, уровень и сообщение записываются в начале строки без ключей
+		if len(groups) == 0 && (attr.Key == slog.TimeKey || attr.Key == slog.LevelKey || attr.Key == slog.MessageKey) {
+			return slog.Attr{}
+		}
+		return attr
+	}
+	return &textHandler{mu: &sync.Mutex{}, w: w, buf: buf, attrs: slog.NewTextHandler(buf, &attrsOptions)}
+}
+
+// Enabled реализует интерфейс slog.Handler
+func (h *textHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.attrs.Enabled(ctx, level)
+}
+
+// Handle реализует интерфейс slog.Handler
+func (h *textHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	fmt.Fprintf(h.buf, "%s %-5s %s ", record.Time.Format("2006-01-02T15:04:05.000Z07:00"), record.Level, record.Message)
+	prefix := h.buf.Len()
+	if err := h.attrs.Handle(ctx, record); err != nil {
+		return err
+	}
+	line := h.buf.Bytes()
+	if len(line) == prefix+1 {
+		// Атрибутов нет, остался только перевод строки
+		line = append(line[:prefix-1], '\n')
+	}
+	_, err := h.w.Write(line)
+	return err
+}
+
+// WithAttrs реализует интерфейс slog.Handler
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &textHandler{mu: h.mu, w: h.w, buf: h.buf, attrs: h.attrs.WithAttrs(attrs)}
+}
+
+// WithGroup реализует интерфейс slog.Handler
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	return &textHandler{mu: h.mu, w: h.w, buf: h.buf, attrs: h.attrs.WithGroup(name)}
+}
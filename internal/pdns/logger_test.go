@@ -0,0 +1,45 @@
+package pdns
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestDebugSourceHandler проверяет, что файл и строка добавляются к записям, только пока уровень выхода - DEBUG,
+// в том числе после изменения уровня во время работы
+func TestDebugSourceHandler(t *testing.T) {
+	for _, format := range []string{LogFormatJSON, LogFormatLogfmt, LogFormatText} {
+		t.Run(format, func(t *testing.T) {
+			level := &slog.LevelVar{}
+			var buf bytes.Buffer
+			logger := slog.New(debugSourceHandler(level, format, &slog.HandlerOptions{Level: level})(&buf)).With("component", "test")
+			tests := []struct {
+				level      slog.Level
+				log        func(msg string, args ...any)
+				wantSource bool
+			}{
+				{slog.LevelInfo, logger.Info, false},
+				{slog.LevelDebug, logger.Debug, true},
+				{slog.LevelDebug, logger.Warn, true},
+				{slog.LevelWarn, logger.Warn, false},
+			}
+			for _, tt := range tests {
+				level.Set(tt.level)
+				buf.Reset()
+				tt.log("message")
+				line := buf.String()
+				if line == "" {
+					t.Fatalf("level %s: record is not written", tt.level)
+				}
+				if got := strings.Contains(line, "logger_test.go"); got != tt.wantSource {
+					t.Errorf("level %s: source %t, want %t: %s", tt.level, got, tt.wantSource, line)
+				}
+				if !strings.Contains(line, "component") {
+					t.Errorf("level %s: attributes are lost: %s", tt.level, line)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package pdns
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogLevelPath - путь обработчика просмотра и изменения уровня логирования во время работы
+const LogLevelPath = "/-/log-level"
+
+// Logging - открытые выходы логов и их уровни. Уровень всех выходов можно временно изменить во время работы
+// (например, включить DEBUG на время разбора инцидента) через API или сигналом SIGUSR1 без перезапуска.
+type Logging struct {
+	mu         sync.Mutex
+	outputs    []string         // Типы выходов (для ответа API)
+	levels     []*slog.LevelVar // Действующие уровни выходов
+	configured []slog.Level     // Уровни выходов из конфигурации
+	override   *slog.Level      // Уровень, временно установленный для всех выходов (nil, если действуют уровни из конфигурации)
+	expires    time.Time        // Время возврата к уровням из конфигурации (нулевое - бессрочно)
+	timer      *time.Timer      // Таймер возврата к уровням из конфигурации
+	closers    []io.Closer      // Открытые файлы и соединения выходов
+}
+
+// addOutput добавляет выход output с уровнем из конфигурации level и возвращает его изменяемый уровень
+func (l *Logging) addOutput(output LogOutput, level slog.Level) *slog.LevelVar {
+	levelVar := &slog.LevelVar{}
+	levelVar.Set(level)
+	l.outputs = append(l.outputs, output.Type)
+	l.levels = append(l.levels, levelVar)
+	l.configured = append(l.configured, level)
+	return levelVar
+}
+
+// SetLevel устанавливает уровень level для всех выходов. Если duration больше нуля, по его истечении
+// восстанавливаются уровни из конфигурации.
+func (l *Logging) SetLevel(level slog.Level, duration time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopTimer()
+	l.override = &level
+	for _, levelVar := range l.levels {
+		levelVar.Set(level)
+	}
+	if duration > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(duration, func() { l.expire(timer) })
+		l.timer, l.expires = timer, time.Now().Add(duration)
+	}
+}
+
+// expire восстанавливает уровни из конфигурации по таймеру timer, если уровень с тех пор не изменялся
+func (l *Logging) expire(timer *time.Timer) {
+	l.mu.Lock()
+	if l.timer != timer {
+		l.mu.Unlock()
+		return
+	}
+	l.mu.Unlock()
+	l.ResetLevel()
+	slog.Info("Temporary log level expired, configured levels restored")
+}
+
+// ResetLevel восстанавливает уровни выходов из конфигурации
+func (l *Logging) ResetLevel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopTimer()
+	l.override = nil
+	for i, levelVar := range l.levels {
+		levelVar.Set(l.configured[i])
+	}
+}
+
+// ToggleDebug включает уровень DEBUG для всех выходов, а если он уже включен - восстанавливает уровни
+// из конфигурации. Вызывается по сигналу SIGUSR1.
+func (l *Logging) ToggleDebug() {
+	l.mu.Lock()
+	debug := l.override != nil && *l.override == slog.LevelDebug
+	l.mu.Unlock()
+	if debug {
+		l.ResetLevel()
+		slog.Info("Log level restored from the configuration")
+		return
+	}
+	l.SetLevel(slog.LevelDebug, 0)
+	slog.Info("Log level set to DEBUG until the next SIGUSR1")
+}
+
+// stopTimer останавливает таймер возврата к уровням из конфигурации. Вызывается под блокировкой l.mu.
+func (l *Logging) stopTimer() {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	l.expires = time.Time{}
+}
+
+// Close закрывает файлы и соединения выходов логов, дописав в них буферизованные записи.
+// Последующие записи (например, ошибка завершения процесса) выводятся в stderr.
+func (l *Logging) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopTimer()
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	for _, closer := range l.closers {
+		closer.Close()
+	}
+	l.closers = nil
+}
+
+// logLevelStatus - ответ API уровня логирования
+type logLevelStatus struct {
+	Override string           `json:"override,omitempty"` // Временно установленный уровень
+	Expires  *time.Time       `json:"expires,omitempty"`  // Время возврата к уровням из конфигурации
+	Outputs  []logOutputLevel `json:"outputs"`            // Уровни выходов
+}
+
+// logOutputLevel - уровень одного выхода логов
+type logOutputLevel struct {
+	Type       string `json:"type"`       // Тип выхода
+	Level      string `json:"level"`      // Действующий уровень
+	Configured string `json:"configured"` // Уровень из конфигурации
+}
+
+// status возвращает действующие уровни выходов
+func (l *Logging) status() logLevelStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	status := logLevelStatus{Outputs: make([]logOutputLevel, 0, len(l.levels))}
+	if l.override != nil {
+		status.Override = l.override.String()
+	}
+	if !l.expires.IsZero() {
+		expires := l.expires
+		status.Expires = &expires
+	}
+	for i, levelVar := range l.levels {
+		status.Outputs = append(status.Outputs, logOutputLevel{
+			Type:       l.outputs[i],
+			Level:      levelVar.Level().String(),
+			Configured: l.configured[i].String(),
+		})
+	}
+	return status
+}
+
+// logLevelRequest - тело запроса на изменение уровня логирования
+type logLevelRequest struct {
+	Level    string   `json:"level"`    // Новый уровень для всех выходов: DEBUG, INFO, WARN или ERROR
+	Duration Duration `json:"duration"` // Через сколько вернуть уровни из конфигурации (необязательно)
+}
+
+// RegisterLogLevelAPI регистрирует обработчики уровня логирования в mux:
+//   - GET /-/log-level - действующие уровни выходов;
+//   - PUT /-/log-level - временно изменить уровень всех выходов, тело {"level": "DEBUG", "duration": "30m"};
+//   - DELETE /-/log-level - вернуть уровни из конфигурации.
+//
+// Доступ проверяется по настройкам группы admin секции auth; без них обработчики недоступны (см. requireAuth).
+func RegisterLogLevelAPI(mux *http.ServeMux, logging *Logging) {
+	mux.HandleFunc("GET "+LogLevelPath, requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, logging.status())
+	}))
+	mux.HandleFunc("PUT "+LogLevelPath, requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		var req logLevelRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "invalid request body: " + err.Error()})
+			return
+		}
+		level, err := parseLogLevel(req.Level)
+		if err != nil || req.Level == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "level must be one of DEBUG, INFO, WARN, ERROR"})
+			return
+		}
+		if req.Duration < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "duration must not be negative"})
+			return
+		}
+		logging.SetLevel(level, time.Duration(req.Duration))
+		slog.Warn("Log level changed", slog.String("newLevel", level.String()), slog.Duration("duration", time.Duration(req.Duration)), slog.String("requestedBy", requestIdentity(r)))
+		writeJSON(w, http.StatusOK, logging.status())
+	}))
+	mux.HandleFunc("DELETE "+LogLevelPath, requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		logging.ResetLevel()
+		slog.Warn("Log level restored from the configuration", slog.String("requestedBy", requestIdentity(r)))
+		writeJSON(w, http.StatusOK, logging.status())
+	}))
+}
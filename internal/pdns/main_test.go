@@ -0,0 +1,216 @@
+package pdns
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TestMain отключает логи, чтобы вывод тестов не терялся среди записей о каждом DNS запросе
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// Имена, на которые отвечает тестовый DNS сервер
+const (
+	fakeNameOK       = "ok.test."       // Ответ NOERROR с записью A 192.0.2.1
+	fakeNameServfail = "servfail.test." // Ответ SERVFAIL
+	fakeNameSlow     = "slow.test."     // Ответ только после закрытия fakeDNSServer.release
+)
+
+// fakeDNSServer - DNS сервер в процессе теста, отвечающий на запросы по UDP
+type fakeDNSServer struct {
+	port     int           // Порт сервера на 127.0.0.1
+	release  chan struct{} // Закрытие разрешает ответы на fakeNameSlow
+	unblock  sync.Once     // Однократное закрытие release
+	started  chan string   // Имена полученных запросов к fakeNameSlow
+	inFlight atomic.Int32  // Количество запросов, обрабатываемых в данный момент
+	maxSeen  atomic.Int32  // Наибольшее количество одновременно обрабатываемых запросов
+	delay    time.Duration // Задержка ответа на каждый запрос
+}
+
+// startFakeDNSServer запускает тестовый DNS сервер, который останавливается по завершении теста
+func startFakeDNSServer(t *testing.T, delay time.Duration) *fakeDNSServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	fake := &fakeDNSServer{
+		port:    conn.LocalAddr().(*net.UDPAddr).Port,
+		release: make(chan struct{}),
+		started: make(chan string, 1024),
+		delay:   delay,
+	}
+	var ready sync.WaitGroup
+	ready.Add(1)
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(fake.serve), NotifyStartedFunc: ready.Done}
+	go server.ActivateAndServe()
+	ready.Wait()
+	t.Cleanup(func() {
+		fake.releaseSlow()
+		server.Shutdown()
+	})
+	return fake
+}
+
+// releaseSlow разрешает ответы на запросы к fakeNameSlow
+func (fake *fakeDNSServer) releaseSlow() {
+	fake.unblock.Do(func() { close(fake.release) })
+}
+
+// serve отвечает на запрос в зависимости от запрошенного имени
+func (fake *fakeDNSServer) serve(w dns.ResponseWriter, req *dns.Msg) {
+	w.WriteMsg(fake.reply(req))
+}
+
+// reply формирует ответ на запрос req; используется и серверами DoH и DoT в тестах транспортов
+func (fake *fakeDNSServer) reply(req *dns.Msg) *dns.Msg {
+	current := fake.inFlight.Add(1)
+	defer fake.inFlight.Add(-1)
+	for {
+		seen := fake.maxSeen.Load()
+		if current <= seen || fake.maxSeen.CompareAndSwap(seen, current) {
+			break
+		}
+	}
+	if fake.delay > 0 {
+		time.Sleep(fake.delay)
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	switch name := req.Question[0].Name; name {
+	case fakeNameOK:
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+	case fakeNameServfail:
+		resp.Rcode = dns.RcodeServerFailure
+	case fakeNameSlow:
+		fake.started <- name
+		<-fake.release
+	default:
+		resp.Rcode = dns.RcodeNameError
+	}
+	return resp
+}
+
+// target возвращает описание сервера, запрашивающего у тестового сервера запись record
+func (fake *fakeDNSServer) target(serverID, record string) DNSTarget {
+	return DNSTarget{ServerID: serverID, IP: "127.0.0.1", DNSPort: fake.port, RequestedRecord: record}
+}
+
+// testCA - удостоверяющий центр в процессе теста, выпускающий сертификаты серверов и клиентов
+type testCA struct {
+	cert   *x509.Certificate // Сертификат CA
+	key    *ecdsa.PrivateKey // Ключ CA
+	serial int64             // Серийный номер последнего выпущенного сертификата
+}
+
+// newTestCA создает самоподписанный CA с именем name
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	ca := &testCA{key: newTestKey(t)}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &ca.key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	ca.serial = 1
+	return ca
+}
+
+// newTestKey создает ключ ECDSA P-256
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// issueServer выпускает сертификат сервера для 127.0.0.1 и localhost
+func (ca *testCA) issueServer(t *testing.T) tls.Certificate {
+	t.Helper()
+	return ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+// issueClient выпускает клиентский сертификат с CN name
+func (ca *testCA) issueClient(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+	return ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+}
+
+// issue выпускает сертификат по шаблону template со следующим серийным номером
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) tls.Certificate {
+	t.Helper()
+	ca.serial++
+	template.SerialNumber = big.NewInt(ca.serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	key := newTestKey(t)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePEM записывает сертификат CA в файл PEM во временном каталоге теста и возвращает путь к нему
+func (ca *testCA) writePEM(t *testing.T) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	writeTestFile(t, file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))
+	return file
+}
+
+// writeTestFile записывает data в файл file
+func writeTestFile(t *testing.T, file string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
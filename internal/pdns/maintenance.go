@@ -0,0 +1,383 @@
+package pdns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"main/pkg/auth"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaintenanceEntry - перевод сервера или всей группы на обслуживание во время работы экспортера.
+// Для группы заполняется только Group, для сервера - Group и ServerID.
+type MaintenanceEntry struct {
+	Group    string     `json:"group"`              // Имя группы
+	ServerID string     `json:"serverID,omitempty"` // Идентификатор сервера (пустой для всей группы)
+	Reason   string     `json:"reason"`             // Причина обслуживания
+	SetBy    string     `json:"setBy"`              // Кто перевел на обслуживание
+	SetAt    time.Time  `json:"setAt"`              // Когда перевели на обслуживание
+	Expires  *time.Time `json:"expires,omitempty"`  // Когда обслуживание закончится (nil - бессрочно)
+}
+
+// active проверяет, действует ли обслуживание в момент now
+func (entry MaintenanceEntry) active(now time.Time) bool {
+	return entry.Expires == nil || now.Before(*entry.Expires)
+}
+
+// key возвращает ключ записи в хранилище
+func (entry MaintenanceEntry) key() maintenanceKey {
+	return maintenanceKey{Group: entry.Group, ServerID: entry.ServerID}
+}
+
+// maintenanceKey - ключ записи в хранилище: группа и сервер (пустой для всей группы).
+// Сервер учитывается вместе с группой, чтобы запись не переходила к серверу с тем же
+// идентификатором в другой группе после перезагрузки конфигурации.
+type maintenanceKey struct {
+	Group    string // Имя группы
+	ServerID string // Идентификатор сервера
+}
+
+// maintenanceState - формат файла состояния
+type maintenanceState struct {
+	Maintenance []MaintenanceEntry `json:"maintenance"` // Записи об обслуживании
+}
+
+// MaintenanceStore - потокобезопасное хранилище записей об обслуживании, заданных через API.
+// Если задан путь к файлу состояния, записи сохраняются в него при каждом изменении
+// и загружаются из него при запуске, поэтому переживают перезапуск экспортера.
+type MaintenanceStore struct {
+	mu      sync.RWMutex
+	path    string                              // Путь к файлу состояния (пустой - без сохранения)
+	entries map[maintenanceKey]MaintenanceEntry // Записи по группе и серверу
+}
+
+// NewMaintenanceStore создает хранилище и загружает записи из файла состояния path, если он существует.
+// Истекшие записи при загрузке отбрасываются.
+func NewMaintenanceStore(path string) (*MaintenanceStore, error) {
+	store := &MaintenanceStore{path: path, entries: make(map[maintenanceKey]MaintenanceEntry)}
+	if path == "" {
+		return store, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state maintenanceState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("state file %s: %w", path, err)
+	}
+	now := time.Now()
+	for _, entry := range state.Maintenance {
+		if entry.active(now) {
+			store.entries[entry.key()] = entry
+		}
+	}
+	slog.Info("Maintenance state loaded", slog.String("path", path), slog.Int("entries", len(store.entries)))
+	return store, nil
+}
+
+// Set переводит сервер или группу на обслуживание, заменяя предыдущую запись для них.
+// Если файл состояния не удалось записать, предыдущая запись восстанавливается.
+func (m *MaintenanceStore) Set(entry MaintenanceEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := entry.key()
+	previous, existed := m.entries[key]
+	m.entries[key] = entry
+	if err := m.save(); err != nil {
+		if existed {
+			m.entries[key] = previous
+		} else {
+			delete(m.entries, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// Clear снимает сервер (serverID непустой) или группу с обслуживания.
+// Возвращает false, если записи не было. Если файл состояния не удалось записать, запись восстанавливается.
+func (m *MaintenanceStore) Clear(group, serverID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := maintenanceKey{Group: group, ServerID: serverID}
+	entry, ok := m.entries[key]
+	if !ok {
+		return false, nil
+	}
+	delete(m.entries, key)
+	if err := m.save(); err != nil {
+		m.entries[key] = entry
+		return false, err
+	}
+	return true, nil
+}
+
+// Retain удаляет записи групп и серверов, которых нет в конфигурации conf (в том числе серверов,
+// перенесенных в другую группу), и возвращает удаленные записи. Вызывается при запуске и перезагрузке
+// конфигурации, чтобы не отдавать метрики обслуживания удаленных групп и серверов.
+// Если файл состояния не удалось записать, записи восстанавливаются.
+func (m *MaintenanceStore) Retain(conf *Config) ([]MaintenanceEntry, error) {
+	if m == nil {
+		return nil, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var removed []MaintenanceEntry
+	for key, entry := range m.entries {
+		if !conf.hasGroup(key.Group) {
+			removed = append(removed, entry)
+			continue
+		}
+		if group, ok := conf.serverGroup(key.ServerID); key.ServerID != "" && (!ok || group != key.Group) {
+			removed = append(removed, entry)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	for _, entry := range removed {
+		delete(m.entries, entry.key())
+	}
+	if err := m.save(); err != nil {
+		for _, entry := range removed {
+			m.entries[entry.key()] = entry
+		}
+		return nil, err
+	}
+	return removed, nil
+}
+
+// retainMaintenance удаляет записи об обслуживании групп и серверов, которых нет в конфигурации conf,
+// и сообщает об этом в логах
+func retainMaintenance(store *MaintenanceStore, conf *Config) {
+	removed, err := store.Retain(conf)
+	if err != nil {
+		slog.Error("Error removing maintenance of servers missing from the configuration", slog.String("error", err.Error()))
+		return
+	}
+	for _, entry := range removed {
+		slog.Warn("Maintenance removed: group or server is no longer in the configuration", slog.String("group", entry.Group), slog.String("serverID", entry.ServerID))
+	}
+}
+
+// Lookup возвращает действующую запись об обслуживании сервера: запись самого сервера
+// или, если ее нет, запись его группы
+func (m *MaintenanceStore) Lookup(group, serverID string, now time.Time) (MaintenanceEntry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range []maintenanceKey{{Group: group, ServerID: serverID}, {Group: group}} {
+		if entry, ok := m.entries[key]; ok && entry.active(now) {
+			return entry, true
+		}
+	}
+	return MaintenanceEntry{}, false
+}
+
+// Entries возвращает действующие записи, отсортированные по группе и серверу
+func (m *MaintenanceStore) Entries(now time.Time) []MaintenanceEntry {
+	if m == nil {
+		return nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := make([]MaintenanceEntry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		if entry.active(now) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Group != entries[j].Group {
+			return entries[i].Group < entries[j].Group
+		}
+		return entries[i].ServerID < entries[j].ServerID
+	})
+	return entries
+}
+
+// Apply возвращает копию группы, в которой серверы с действующим обслуживанием помечены флагом Maintenance.
+// Конфигурация группы не изменяется.
+func (m *MaintenanceStore) Apply(group GroupDNS, now time.Time) GroupDNS {
+	if m == nil {
+		return group
+	}
+	servers := make([]DNSTarget, len(group.DNSServers))
+	copy(servers, group.DNSServers)
+	for i := range servers {
+		if _, ok := m.Lookup(group.GroupName, servers[i].ServerID, now); ok {
+			servers[i].Maintenance = true
+		}
+	}
+	group.DNSServers = servers
+	return group
+}
+
+// save записывает действующие записи в файл состояния.
+// Файл заменяется атомарно, чтобы при сбое не остался частично записанный файл.
+// Вызывается под блокировкой m.mu.
+func (m *MaintenanceStore) save() error {
+	if m.path == "" {
+		return nil
+	}
+	now := time.Now()
+	state := maintenanceState{Maintenance: []MaintenanceEntry{}}
+	for key, entry := range m.entries {
+		if !entry.active(now) {
+			delete(m.entries, key) // Истекшие записи больше не нужны
+			continue
+		}
+		state.Maintenance = append(state.Maintenance, entry)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+// maintenanceRequest - тело запроса на перевод на обслуживание
+type maintenanceRequest struct {
+	Reason   string    `json:"reason"`   // Причина обслуживания
+	Expires  time.Time `json:"expires"`  // Время окончания обслуживания (RFC 3339)
+	Duration Duration  `json:"duration"` // Длительность обслуживания ("2h"), альтернатива expires
+}
+
+// requestIdentity возвращает, кто выполняет запрос: клиент, аутентифицированный при проверке доступа
+// (CN сертификата, имя токена или пользователя), CN клиентского сертификата при mTLS или адрес клиента
+func requestIdentity(r *http.Request) string {
+	if identity, ok := auth.IdentityFrom(r.Context()); ok && identity.Method != auth.MethodNetwork {
+		return identity.Name
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	return r.RemoteAddr
+}
+
+// RegisterMaintenanceAPI регистрирует обработчики API обслуживания в mux:
+//   - GET /api/v1/maintenance - действующие записи;
+//   - PUT /api/v1/maintenance/servers/{id} и DELETE /api/v1/maintenance/servers/{id} - обслуживание сервера;
+//   - PUT /api/v1/maintenance/groups/{name} и DELETE /api/v1/maintenance/groups/{name} - обслуживание группы;
+//   - PUT /api/v1/maintenance/groups/{name}/servers/{id} и DELETE /api/v1/maintenance/groups/{name}/servers/{id} -
+//     обслуживание сервера группы (в том числе снятие записи сервера, которого уже нет в группе).
+//
+// Группа сервера в путях /servers/{id} определяется по текущей конфигурации.
+// Тело PUT запроса: {"reason": "...", "expires": "2024-01-02T15:04:05Z"} или {"reason": "...", "duration": "2h"};
+// без expires и duration обслуживание бессрочное. Изменения учитываются при следующей проверке группы.
+// Существование групп и серверов проверяется по текущей конфигурации current.
+// PUT и DELETE доступны только аутентифицированным клиентам (см. requireAuth).
+func RegisterMaintenanceAPI(mux *http.ServeMux, current func() *Config, store *MaintenanceStore) {
+	mux.HandleFunc("GET "+APIPrefix+"/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, store.Entries(time.Now()))
+	})
+	mux.HandleFunc("PUT "+APIPrefix+"/maintenance/servers/{id}", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		group, ok := current().serverGroup(r.PathValue("id"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "server not found"})
+			return
+		}
+		setMaintenance(w, r, store, group, r.PathValue("id"))
+	}))
+	mux.HandleFunc("DELETE "+APIPrefix+"/maintenance/servers/{id}", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		group, _ := current().serverGroup(r.PathValue("id"))
+		clearMaintenance(w, r, store, group, r.PathValue("id"))
+	}))
+	mux.HandleFunc("PUT "+APIPrefix+"/maintenance/groups/{name}", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if !current().hasGroup(r.PathValue("name")) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "group not found"})
+			return
+		}
+		setMaintenance(w, r, store, r.PathValue("name"), "")
+	}))
+	mux.HandleFunc("DELETE "+APIPrefix+"/maintenance/groups/{name}", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		clearMaintenance(w, r, store, r.PathValue("name"), "")
+	}))
+	mux.HandleFunc("PUT "+APIPrefix+"/maintenance/groups/{name}/servers/{id}", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if group, ok := current().serverGroup(r.PathValue("id")); !ok || group != r.PathValue("name") {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "server not found in group"})
+			return
+		}
+		setMaintenance(w, r, store, r.PathValue("name"), r.PathValue("id"))
+	}))
+	mux.HandleFunc("DELETE "+APIPrefix+"/maintenance/groups/{name}/servers/{id}", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		clearMaintenance(w, r, store, r.PathValue("name"), r.PathValue("id"))
+	}))
+}
+
+// setMaintenance обрабатывает запрос на перевод сервера или группы на обслуживание
+func setMaintenance(w http.ResponseWriter, r *http.Request, store *MaintenanceStore, group, serverID string) {
+	var req maintenanceRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "invalid request body: " + err.Error()})
+			return
+		}
+	}
+	now := time.Now()
+	entry := MaintenanceEntry{
+		Group:    group,
+		ServerID: serverID,
+		Reason:   req.Reason,
+		SetBy:    requestIdentity(r),
+		SetAt:    now,
+	}
+	switch {
+	case !req.Expires.IsZero() && req.Duration != 0:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "expires and duration are mutually exclusive"})
+		return
+	case !req.Expires.IsZero():
+		entry.Expires = &req.Expires
+	case req.Duration != 0:
+		expires := now.Add(time.Duration(req.Duration))
+		entry.Expires = &expires
+	}
+	if entry.Expires != nil && !entry.Expires.After(now) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "expiry time must be in the future"})
+		return
+	}
+	if err := store.Set(entry); err != nil {
+		slog.Error("Failed to save maintenance state", slog.String("error", err.Error()))
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"message": "failed to save maintenance state"})
+		return
+	}
+	slog.Info("Maintenance set", slog.String("group", group), slog.String("serverID", serverID), slog.String("reason", entry.Reason), slog.String("setBy", entry.SetBy))
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// clearMaintenance обрабатывает запрос на снятие сервера или группы с обслуживания
+func clearMaintenance(w http.ResponseWriter, r *http.Request, store *MaintenanceStore, group, serverID string) {
+	removed, err := store.Clear(group, serverID)
+	if err != nil {
+		slog.Error("Failed to save maintenance state", slog.String("error", err.Error()))
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"message": "failed to save maintenance state"})
+		return
+	}
+	if !removed {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "maintenance not found"})
+		return
+	}
+	slog.Info("Maintenance cleared", slog.String("group", group), slog.String("serverID", serverID), slog.String("clearedBy", requestIdentity(r)))
+	w.WriteHeader(http.StatusNoContent)
+}
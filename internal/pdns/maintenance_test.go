@@ -0,0 +1,148 @@
+package pdns
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMaintenanceStoreRollback проверяет, что при ошибке записи файла состояния записи в памяти не изменяются
+func TestMaintenanceStoreRollback(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewMaintenanceStore(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := MaintenanceEntry{Group: "g", ServerID: "s1", Reason: "first"}
+	if err := store.Set(first); err != nil {
+		t.Fatal(err)
+	}
+	store.path = filepath.Join(dir, "missing", "state.json")
+
+	if err := store.Set(MaintenanceEntry{Group: "g", ServerID: "s1", Reason: "second"}); err == nil {
+		t.Fatal("Set succeeded without a writable state file")
+	}
+	if err := store.Set(MaintenanceEntry{Group: "g", ServerID: "s2"}); err == nil {
+		t.Fatal("Set succeeded without a writable state file")
+	}
+	if removed, err := store.Clear("g", "s1"); err == nil || removed {
+		t.Fatalf("Clear: removed %t, error %v", removed, err)
+	}
+
+	entries := store.Entries(time.Now())
+	if len(entries) != 1 || entries[0].Reason != "first" {
+		t.Errorf("entries after failed writes: %+v, want only %+v", entries, first)
+	}
+
+	reloaded, err := NewMaintenanceStore(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := reloaded.Entries(time.Now()); len(entries) != 1 || entries[0].Reason != "first" {
+		t.Errorf("state file entries: %+v", entries)
+	}
+}
+
+// TestMaintenanceStoreLookup проверяет, что запись сервера относится только к серверу в своей группе
+func TestMaintenanceStoreLookup(t *testing.T) {
+	store, err := NewMaintenanceStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	for _, entry := range []MaintenanceEntry{
+		{Group: "a", ServerID: "s1", Reason: "server"},
+		{Group: "b", Reason: "group"},
+		{Group: "c", ServerID: "s1", Expires: &expired},
+	} {
+		if err := store.Set(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		group, serverID string
+		reason          string // Ожидаемая причина, пустая - обслуживания нет
+	}{
+		{"a", "s1", "server"},
+		{"a", "s2", ""},
+		{"b", "s1", "group"},
+		{"c", "s1", ""},
+		{"d", "s1", ""},
+	}
+	for _, tt := range tests {
+		entry, ok := store.Lookup(tt.group, tt.serverID, now)
+		if ok != (tt.reason != "") || entry.Reason != tt.reason {
+			t.Errorf("Lookup(%q, %q) = %q, %t; want %q", tt.group, tt.serverID, entry.Reason, ok, tt.reason)
+		}
+	}
+
+	if removed, err := store.Clear("b", "s1"); err != nil || removed {
+		t.Errorf("Clear of a missing server entry: removed %t, error %v", removed, err)
+	}
+	if removed, err := store.Clear("a", "s1"); err != nil || !removed {
+		t.Errorf("Clear: removed %t, error %v", removed, err)
+	}
+	if _, ok := store.Lookup("a", "s1", now); ok {
+		t.Error("server is still under maintenance after Clear")
+	}
+}
+
+// TestMaintenanceStoreRetain проверяет удаление записей групп и серверов, которых нет в конфигурации
+func TestMaintenanceStoreRetain(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.json")
+	store, err := NewMaintenanceStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []MaintenanceEntry{
+		{Group: "a"},
+		{Group: "a", ServerID: "s1"},
+		{Group: "a", ServerID: "s2"}, // Сервер перенесен в группу b
+		{Group: "a", ServerID: "s9"}, // Сервер удален
+		{Group: "c"},                 // Группа удалена
+		{Group: "c", ServerID: "s3"},
+	} {
+		if err := store.Set(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := &Config{GroupsDNS: []GroupDNS{
+		{GroupName: "a", DNSServers: []DNSTarget{{ServerID: "s1"}}},
+		{GroupName: "b", DNSServers: []DNSTarget{{ServerID: "s2"}, {ServerID: "s3"}}},
+	}}
+
+	removed, err := store.Retain(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 4 {
+		t.Errorf("removed %+v, want 4 entries", removed)
+	}
+	want := []maintenanceKey{{Group: "a"}, {Group: "a", ServerID: "s1"}}
+	for _, store := range []*MaintenanceStore{store, mustLoadMaintenance(t, file)} {
+		entries := store.Entries(time.Now())
+		if len(entries) != len(want) {
+			t.Fatalf("entries %+v, want %v", entries, want)
+		}
+		for i, entry := range entries {
+			if entry.key() != want[i] {
+				t.Errorf("entry %d: %+v, want %v", i, entry.key(), want[i])
+			}
+		}
+	}
+	if removed, err := store.Retain(conf); err != nil || len(removed) != 0 {
+		t.Errorf("second Retain: removed %+v, error %v", removed, err)
+	}
+}
+
+// mustLoadMaintenance загружает записи об обслуживании из файла состояния file
+func mustLoadMaintenance(t *testing.T, file string) *MaintenanceStore {
+	t.Helper()
+	store, err := NewMaintenanceStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
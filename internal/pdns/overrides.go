@@ -0,0 +1,326 @@
+package pdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigSource - откуда загружается конфигурация: файл, его формат и значения параметров,
+// заданные переменными окружения и флагами командной строки.
+// Конфигурация собирается слоями: значения по умолчанию, файл (с подключенными файлами),
+// переменные окружения и флаги; каждый следующий слой переопределяет предыдущий.
+// Переопределения применяются и при каждой перезагрузке файла.
+type ConfigSource struct {
+	Path      string           // Путь к файлу конфигурации
+	Format    string           // Формат файла (json, yaml или toml); если пустой, определяется по расширению файла
+	Overrides []ConfigOverride // Переопределения параметров в порядке применения (сначала переменные окружения, затем флаги)
+}
+
+// ConfigOverride - значение параметра конфигурации из переменной окружения или флага командной строки
+type ConfigOverride struct {
+	Source  string // Источник значения для сообщений об ошибках ("env DGM_LOG_LEVEL", "flag -log.level")
+	Path    string // Путь к параметру в формате JSON ("mtlsExporter.cert")
+	Value   string // Значение в виде строки
+	Default bool   // Значение по умолчанию: применяется, только если параметр не задан в файле (см. ConfigSetting.Default)
+}
+
+// ConfigSetting - параметр конфигурации, который можно переопределить переменной окружения и флагом
+type ConfigSetting struct {
+	Path  string // Путь к параметру в формате JSON
+	Env   string // Имя переменной окружения
+	Flag  string // Имя флага командной строки
+	Usage string // Описание для справки по флагам
+	// Значение используется по умолчанию, только если параметр не задан в файле конфигурации,
+	// и не переопределяет его (например, выходы логов в Docker образе)
+	Default bool
+
+	set func(conf *Config, value string) error // Установка значения, если параметр задается не одним полем
+}
+
+// ConfigSettings - параметры, которые можно переопределить переменными окружения и флагами,
+// в порядке их применения. Списки (allowedCN, адреса) задаются через запятую.
+var ConfigSettings = []ConfigSetting{
+	{Path: "logLevel", Env: "DGM_LOG_LEVEL", Flag: "log.level", Usage: "log level: DEBUG, INFO, WARN or ERROR"},
+	{Path: "logPath", Env: "DGM_LOG_PATH", Flag: "log.path", Usage: "path to the log file"},
+	{Path: "logToFile", Env: "DGM_LOG_TO_FILE", Flag: "log.to-file", Usage: "write logs to the log file"},
+	{Path: "logToSyslog", Env: "DGM_LOG_TO_SYSLOG", Flag: "log.to-syslog", Usage: "write logs to syslog"},
+	{Path: "logOutputs", Env: "DGM_DEFAULT_LOG_OUTPUT", Flag: "log.default-output", Usage: "comma-separated default log outputs: stdout, stderr, syslog or file:<path>", Default: true, set: setLogOutputs},
+	{Path: "logOutputs", Env: "DGM_LOG_OUTPUT", Flag: "log.output", Usage: "comma-separated log outputs: stdout, stderr, syslog or file:<path>", set: setLogOutputs},
+	{Path: "logFormat", Env: "DGM_LOG_FORMAT", Flag: "log.format", Usage: "log format: json, logfmt or text"},
+	{Path: "logAttributes", Env: "DGM_LOG_ATTRIBUTES", Flag: "log.attributes", Usage: "comma-separated static log attributes: key=value,...", set: setLogAttributes},
+	{Path: "mtlsExporter.enabled", Env: "DGM_MTLS_ENABLED", Flag: "mtls.enabled", Usage: "enable mTLS for the exporter"},
+	{Path: "mtlsExporter.cert", Env: "DGM_MTLS_CERT", Flag: "mtls.cert", Usage: "path to the server certificate"},
+	{Path: "mtlsExporter.key", Env: "DGM_MTLS_KEY", Flag: "mtls.key", Usage: "path to the server private key"},
+	{Path: "mtlsExporter.clientCA", Env: "DGM_MTLS_CLIENT_CA", Flag: "mtls.client-ca", Usage: "path to the CA certificates that verify client certificates"},
+	{Path: "mtlsExporter.crl", Env: "DGM_MTLS_CRL", Flag: "mtls.crl", Usage: "comma-separated list of certificate revocation list files"},
+	{Path: "mtlsExporter.reloadInterval", Env: "DGM_MTLS_RELOAD_INTERVAL", Flag: "mtls.reload-interval", Usage: "interval of certificate file change checks"},
+	{Path: "mtlsExporter.allowedCN", Env: "DGM_MTLS_ALLOWED_CN", Flag: "mtls.allowed-cn", Usage: "comma-separated list of allowed client certificate CNs"},
+	{Path: "web.listeners", Env: "DGM_WEB_LISTEN_ADDRESS", Flag: "web.listen-address", Usage: "comma-separated listen addresses, served with the mtlsExporter settings", set: setListenAddresses},
+	{Path: "web.metricsPath", Env: "DGM_WEB_METRICS_PATH", Flag: "web.metrics-path", Usage: "path under which metrics are exposed"},
+	{Path: "checkInterval", Env: "DGM_CHECK_INTERVAL", Flag: "check.interval", Usage: "default group check interval"},
+	{Path: "probeWorkers", Env: "DGM_PROBE_WORKERS", Flag: "probe.workers", Usage: "maximum number of concurrent DNS queries"},
+	{Path: "stateFile", Env: "DGM_STATE_FILE", Flag: "state-file", Usage: "path to the maintenance state file"},
+	{Path: "configWatch", Env: "DGM_CONFIG_WATCH", Flag: "config.watch", Usage: "interval of configuration file change checks"},
+	{Path: "shutdownTimeout", Env: "DGM_SHUTDOWN_TIMEOUT", Flag: "shutdown-timeout", Usage: "time to finish running checks and HTTP requests on shutdown"},
+}
+
+// IsBool проверяет, является ли параметр флагом (true/false), который можно задать без значения
+func (setting ConfigSetting) IsBool() bool {
+	field, ok := configField(reflect.ValueOf(&Config{}).Elem(), setting.Path)
+	return ok && field.Kind() == reflect.Bool
+}
+
+// EnvOverrides возвращает переопределения параметров из переменных окружения.
+// Пустые переменные не учитываются.
+func EnvOverrides() []ConfigOverride {
+	var overrides []ConfigOverride
+	for _, setting := range ConfigSettings {
+		if value := os.Getenv(setting.Env); value != "" {
+			overrides = append(overrides, ConfigOverride{Source: "env " + setting.Env, Path: setting.Path, Value: value, Default: setting.Default})
+		}
+	}
+	return overrides
+}
+
+// applyOverrides применяет переопределения overrides к конфигурации conf в порядке ConfigSettings;
+// для каждого параметра действует последнее значение. Значения по умолчанию (Default) применяются
+// только к параметрам, не заданным в файле, до остальных переопределений. Источник значения запоминается в check,
+// чтобы ошибки переопределенных параметров указывали на переменную окружения или флаг, а не на файл.
+func applyOverrides(conf *Config, overrides []ConfigOverride, check *configCheck) {
+	for _, setting := range ConfigSettings {
+		var override *ConfigOverride
+		for i := range overrides {
+			if overrides[i].Path == setting.Path && overrides[i].Default == setting.Default {
+				override = &overrides[i]
+			}
+		}
+		if override == nil {
+			continue
+		}
+		if setting.Default {
+			if field, ok := configField(reflect.ValueOf(conf).Elem(), setting.Path); ok && !field.IsZero() {
+				continue
+			}
+		}
+		if check.overrides == nil {
+			check.overrides = make(map[string]string)
+		}
+		check.overrides[setting.Path] = override.Source
+		var err error
+		if setting.set != nil {
+			err = setting.set(conf, override.Value)
+		} else {
+			err = setConfigValue(conf, setting.Path, override.Value)
+		}
+		if err != nil {
+			check.addf(setting.Path, "invalid value %q: %s", override.Value, err)
+		}
+	}
+}
+
+// setListenAddresses заменяет адреса HTTP сервера адресами из списка value (через запятую).
+// Как и адрес по умолчанию, все адреса используют настройки mtlsExporter.
+func setListenAddresses(conf *Config, value string) error {
+	if conf.Web == nil {
+		conf.Web = &WebConfig{}
+	}
+	conf.Web.Listeners = nil
+	for _, address := range splitList(value) {
+		mtls := conf.MtlsExporter
+		conf.Web.Listeners = append(conf.Web.Listeners, ListenerConfig{Address: address, Mtls: &mtls})
+	}
+	if len(conf.Web.Listeners) == 0 {
+		return fmt.Errorf("at least one address is required")
+	}
+	return nil
+}
+
+// setLogOutputs заменяет выходы логов выходами из списка value (через запятую): stdout, stderr, syslog
+// (локальный) или file:<путь> (без ротации). Уровень всех выходов - logLevel.
+func setLogOutputs(conf *Config, value string) error {
+	conf.LogOutputs = nil
+	for _, item := range splitList(value) {
+		output := LogOutput{Type: item}
+		if path, ok := strings.CutPrefix(item, LogOutputFile+":"); ok {
+			output = LogOutput{Type: LogOutputFile, Path: path}
+		}
+		conf.LogOutputs = append(conf.LogOutputs, output)
+	}
+	if len(conf.LogOutputs) == 0 {
+		return fmt.Errorf("at least one output is required")
+	}
+	return nil
+}
+
+// setLogAttributes добавляет к постоянным атрибутам логов атрибуты из списка value ("environment=staging,cluster=eu1")
+func setLogAttributes(conf *Config, value string) error {
+	if conf.LogAttributes == nil {
+		conf.LogAttributes = make(map[string]string)
+	}
+	for _, item := range splitList(value) {
+		key, attr, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %q", item)
+		}
+		conf.LogAttributes[strings.TrimSpace(key)] = strings.TrimSpace(attr)
+	}
+	return nil
+}
+
+// splitList разбивает список значений через запятую, пропуская пустые значения
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// configField возвращает поле структуры v по пути path из имен полей в JSON,
+// создавая незаданные промежуточные структуры (например, секцию web)
+func configField(v reflect.Value, path string) (reflect.Value, bool) {
+	for _, name := range strings.Split(path, ".") {
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ","); tag == name {
+				v, found = v.Field(i), true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, false
+		}
+	}
+	return v, true
+}
+
+// setConfigValue устанавливает параметр path конфигурации conf из строкового значения value
+func setConfigValue(conf *Config, path, value string) error {
+	field, ok := configField(reflect.ValueOf(conf).Elem(), path)
+	if !ok {
+		return fmt.Errorf("unknown setting %q", path)
+	}
+	switch {
+	case field.Type() == reflect.TypeOf(Duration(0)):
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("expected a duration such as \"30s\"")
+		}
+		field.SetInt(int64(duration))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false")
+		}
+		field.SetBool(enabled)
+	case field.Kind() == reflect.Int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("expected an integer")
+		}
+		field.SetInt(int64(number))
+	case field.Type() == reflect.TypeOf([]string(nil)):
+		field.Set(reflect.ValueOf(splitList(value)))
+	default:
+		return fmt.Errorf("setting %q of type %s can not be overridden", path, field.Type())
+	}
+	return nil
+}
+
+// WriteEffectiveConfig записывает в w итоговую конфигурацию conf в формате JSON: значения из файла
+// и переопределений вместе со значениями по умолчанию. Секреты (пароли в URL) скрываются.
+func WriteEffectiveConfig(w io.Writer, conf *Config) error {
+	// Копия через JSON, чтобы не изменять действующую конфигурацию
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return err
+	}
+	var effective Config
+	if err := json.Unmarshal(data, &effective); err != nil {
+		return err
+	}
+	effective.applyDefaults()
+	effective.redactSecrets()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&effective)
+}
+
+// applyDefaults записывает в конфигурацию значения по умолчанию незаданных глобальных параметров
+// (используется только для вывода итоговой конфигурации)
+func (conf *Config) applyDefaults() {
+	if conf.LogLevel == "" {
+		conf.LogLevel = "INFO"
+	}
+	if len(conf.RttBuckets) == 0 {
+		conf.RttBuckets = DefaultRttBuckets
+	}
+	if conf.CheckInterval <= 0 {
+		conf.CheckInterval = Duration(DefaultCheckInterval)
+	}
+	if conf.CheckJitter <= 0 {
+		conf.CheckJitter = conf.CheckInterval / 10
+	}
+	if conf.ProbeWorkers <= 0 {
+		conf.ProbeWorkers = DefaultProbeWorkers
+	}
+	conf.ShutdownTimeout = Duration(conf.shutdownTimeout())
+	conf.ProbeDefaults = conf.ProbeDefaults.resolve()
+	web := &WebConfig{MetricsPath: conf.metricsPath(), Listeners: conf.listeners()}
+	if conf.Web != nil {
+		web.Auth = conf.Web.Auth
+	}
+	conf.Web = web
+	mtlsConfigs := []*MtlsConfig{&conf.MtlsExporter}
+	for _, listener := range conf.Web.Listeners {
+		if listener.Mtls != nil {
+			mtlsConfigs = append(mtlsConfigs, listener.Mtls)
+		}
+	}
+	for _, mtls := range mtlsConfigs {
+		if mtls.Enabled && mtls.ReloadInterval <= 0 {
+			mtls.ReloadInterval = Duration(DefaultTLSReloadInterval)
+		}
+	}
+}
+
+// redacted - значение, которым в итоговой конфигурации заменяются секреты
+const redacted = "xxxxx"
+
+// redactSecrets скрывает секреты в конфигурации: пароли в URL DoH серверов, bearer токены и хэши паролей
+func (conf *Config) redactSecrets() {
+	if conf.Web != nil {
+		conf.Web.Auth.redactSecrets()
+		for _, listener := range conf.Web.Listeners {
+			listener.Auth.redactSecrets()
+		}
+	}
+	for i := range conf.GroupsDNS {
+		for j := range conf.GroupsDNS[i].DNSServers {
+			target := &conf.GroupsDNS[i].DNSServers[j]
+			if u, err := url.Parse(target.DohURL); err == nil && u.User != nil {
+				target.DohURL = u.Redacted()
+			}
+		}
+	}
+}
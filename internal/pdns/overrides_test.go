@@ -0,0 +1,144 @@
+package pdns
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOverrides проверяет переопределение параметров файла переменными окружения и флагами
+// и ошибки в переопределенных значениях с указанием их источника
+func TestOverrides(t *testing.T) {
+	file := `logLevel: INFO
+checkInterval: 30s
+groupsDns:
+  - groupName: g
+    dnsServers: [{serverID: s1, IP: 192.0.2.1, requestedRecord: example.com}]
+`
+	env := func(path, value string) ConfigOverride {
+		return ConfigOverride{Source: "env " + path, Path: path, Value: value}
+	}
+	flag := func(path, value string) ConfigOverride {
+		return ConfigOverride{Source: "flag " + path, Path: path, Value: value}
+	}
+	tests := []struct {
+		name      string
+		overrides []ConfigOverride
+		check     func(t *testing.T, conf *Config)
+		wantErr   string
+	}{
+		{
+			name: "file values",
+			check: func(t *testing.T, conf *Config) {
+				if conf.LogLevel != "INFO" || conf.CheckInterval != Duration(30*time.Second) {
+					t.Errorf("logLevel %q, checkInterval %v", conf.LogLevel, conf.CheckInterval)
+				}
+			},
+		},
+		{
+			name:      "flag after env",
+			overrides: []ConfigOverride{env("logLevel", "WARN"), flag("logLevel", "DEBUG"), env("checkInterval", "1m")},
+			check: func(t *testing.T, conf *Config) {
+				if conf.LogLevel != "DEBUG" || conf.CheckInterval != Duration(time.Minute) {
+					t.Errorf("logLevel %q, checkInterval %v; want DEBUG, 1m", conf.LogLevel, conf.CheckInterval)
+				}
+			},
+		},
+		{
+			name:      "lists",
+			overrides: []ConfigOverride{env("mtlsExporter.allowedCN", "a, b"), flag("web.listeners", "127.0.0.1:9100,[::1]:9100")},
+			check: func(t *testing.T, conf *Config) {
+				if !reflect.DeepEqual(conf.MtlsExporter.AllowedCN, []string{"a", "b"}) {
+					t.Errorf("allowedCN %q", conf.MtlsExporter.AllowedCN)
+				}
+				var addresses []string
+				for _, listener := range conf.Web.Listeners {
+					addresses = append(addresses, listener.Address)
+				}
+				if !reflect.DeepEqual(addresses, []string{"127.0.0.1:9100", "[::1]:9100"}) {
+					t.Errorf("listeners %q", addresses)
+				}
+			},
+		},
+		{
+			name:      "bool",
+			overrides: []ConfigOverride{env("logToFile", "true")},
+			check: func(t *testing.T, conf *Config) {
+				if !conf.LogToFile {
+					t.Error("logToFile is not set")
+				}
+			},
+		},
+		{
+			name:      "invalid value",
+			overrides: []ConfigOverride{env("checkInterval", "soon")},
+			wantErr:   `env checkInterval: checkInterval: invalid value "soon"`,
+		},
+		{
+			name:      "validated value",
+			overrides: []ConfigOverride{flag("logLevel", "TRACE")},
+			wantErr:   `flag logLevel: logLevel: must be one of DEBUG, INFO, WARN, ERROR, got "TRACE"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"config.yaml": file})
+			conf, err := LoadConfig(ConfigSource{Path: filepath.Join(dir, "config.yaml"), Overrides: tt.overrides})
+			if tt.wantErr != "" {
+				var errs ConfigErrors
+				if !errors.As(err, &errs) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, conf)
+		})
+	}
+}
+
+// TestDefaultOverrides проверяет, что значение по умолчанию (DGM_DEFAULT_LOG_OUTPUT) применяется,
+// только если выходы логов не заданы в файле, и не мешает явному переопределению
+func TestDefaultOverrides(t *testing.T) {
+	groups := `groupsDns:
+  - groupName: g
+    dnsServers: [{serverID: s1, IP: 192.0.2.1, requestedRecord: example.com}]
+`
+	withOutputs := "logOutputs: [{type: stderr}]\n" + groups
+	defaultStdout := ConfigOverride{Source: "env DGM_DEFAULT_LOG_OUTPUT", Path: "logOutputs", Value: "stdout", Default: true}
+	explicitSyslog := ConfigOverride{Source: "env DGM_LOG_OUTPUT", Path: "logOutputs", Value: "syslog"}
+	tests := []struct {
+		name      string
+		file      string
+		overrides []ConfigOverride
+		want      []string
+	}{
+		{"default without outputs", groups, []ConfigOverride{defaultStdout}, []string{LogOutputStdout}},
+		{"file outputs kept", withOutputs, []ConfigOverride{defaultStdout}, []string{LogOutputStderr}},
+		{"explicit wins", groups, []ConfigOverride{explicitSyslog, defaultStdout}, []string{LogOutputSyslog}},
+		{"explicit over file", withOutputs, []ConfigOverride{defaultStdout, explicitSyslog}, []string{LogOutputSyslog}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"config.yaml": tt.file})
+			conf, err := LoadConfig(ConfigSource{Path: filepath.Join(dir, "config.yaml"), Overrides: tt.overrides})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, output := range conf.LogOutputs {
+				got = append(got, output.Type)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("log outputs %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
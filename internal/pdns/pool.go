@@ -0,0 +1,94 @@
+package pdns
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// DefaultProbeWorkers - количество одновременно выполняемых DNS запросов, если оно не задано в конфигурации
+const DefaultProbeWorkers = 64
+
+// ProbePool - ограниченный пул воркеров, выполняющих DNS запросы.
+// Пул общий для всех групп, поэтому количество одновременных запросов не превышает числа воркеров
+// независимо от количества групп и серверов в них.
+type ProbePool struct {
+	jobs   chan probeJob  // Очередь запросов
+	wg     sync.WaitGroup // Ожидание завершения воркеров
+	closed sync.Once      // Защита от повторного закрытия очереди
+}
+
+// probeJob - один DNS запрос в очереди пула.
+// Результат записывается в собственный элемент среза results, поэтому воркерам не нужна общая блокировка.
+type probeJob struct {
+	ctx     context.Context  // Контекст проверки, при отмене которого запрос не выполняется
+	request DnsRequestData   // Данные запроса
+	result  *DnsResponseData // Куда записать результат
+	done    *sync.WaitGroup  // Ожидание завершения всех запросов группы
+}
+
+// NewProbePool создает пул и запускает workers воркеров (при workers <= 0 используется DefaultProbeWorkers).
+// Пул необходимо остановить вызовом Close.
+func NewProbePool(workers int) *ProbePool {
+	if workers <= 0 {
+		workers = DefaultProbeWorkers
+	}
+	pool := &ProbePool{jobs: make(chan probeJob)}
+	pool.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go pool.worker()
+	}
+	return pool
+}
+
+// worker выполняет запросы из очереди до закрытия пула
+func (p *ProbePool) worker() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		if err := job.ctx.Err(); err != nil {
+			*job.result = cancelledResponse(job.request, err)
+		} else {
+			*job.result = DnsRequest(job.ctx, job.request)
+		}
+		job.done.Done()
+	}
+}
+
+// ProbeAll выполняет запросы requests в пуле и возвращает результаты в том же порядке.
+// При отмене ctx еще не начатые запросы не выполняются и возвращаются с ошибкой отмены.
+func (p *ProbePool) ProbeAll(ctx context.Context, requests []DnsRequestData) []DnsResponseData {
+	results := make([]DnsResponseData, len(requests))
+	var done sync.WaitGroup
+	done.Add(len(requests))
+	for i, request := range requests {
+		job := probeJob{ctx: ctx, request: request, result: &results[i], done: &done}
+		select {
+		case p.jobs <- job:
+		case <-ctx.Done():
+			results[i] = cancelledResponse(request, ctx.Err())
+			done.Done()
+		}
+	}
+	done.Wait()
+	return results
+}
+
+// Close останавливает пул после завершения уже принятых запросов.
+// Вызывать ProbeAll после Close нельзя.
+func (p *ProbePool) Close() {
+	p.closed.Do(func() { close(p.jobs) })
+	p.wg.Wait()
+}
+
+// cancelledResponse формирует результат запроса, который не был выполнен из-за отмены проверки
+func cancelledResponse(drd DnsRequestData, err error) DnsResponseData {
+	slog.Debug("DNS request cancelled.", slog.String("serverID", drd.ServerID), slog.String("address", drd.Address), slog.String("error", err.Error()))
+	return DnsResponseData{
+		ServerID:      drd.ServerID,
+		Request:       drd,
+		Status:        ProbeStatusNetworkError,
+		Rcode:         -1,
+		FailureReason: string(ProbeStatusNetworkError),
+		FailureDetail: err.Error(),
+	}
+}
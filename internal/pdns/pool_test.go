@@ -0,0 +1,158 @@
+package pdns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestProbePoolProbeAll проверяет, что результаты возвращаются в порядке запросов,
+// а одновременно выполняется не больше запросов, чем воркеров в пуле
+func TestProbePoolProbeAll(t *testing.T) {
+	fake := startFakeDNSServer(t, 2*time.Millisecond)
+	const workers = 4
+	pool := NewProbePool(workers)
+	defer pool.Close()
+
+	var requests []DnsRequestData
+	for i := 0; i < 200; i++ {
+		record := fakeNameOK
+		if i%3 == 0 {
+			record = fakeNameServfail
+		}
+		requests = append(requests, CreateDnsRequestData(fake.target(fmt.Sprintf("s%d", i), record)))
+	}
+	results := pool.ProbeAll(context.Background(), requests)
+
+	if len(results) != len(requests) {
+		t.Fatalf("got %d results, want %d", len(results), len(requests))
+	}
+	for i, result := range results {
+		if result.ServerID != requests[i].ServerID {
+			t.Fatalf("result %d is for server %q, want %q", i, result.ServerID, requests[i].ServerID)
+		}
+		want := ProbeStatusOK
+		if i%3 == 0 {
+			want = ProbeStatusBadRcode
+		}
+		if result.Status != want {
+			t.Errorf("server %s: status %s, want %s (%s)", result.ServerID, result.Status, want, result.FailureDetail)
+		}
+	}
+	if max := fake.maxSeen.Load(); max > workers {
+		t.Errorf("%d requests ran concurrently, pool has %d workers", max, workers)
+	}
+}
+
+// TestProbePoolConcurrentGroups проверяет общий пул при одновременных вызовах ProbeAll из многих горутин
+func TestProbePoolConcurrentGroups(t *testing.T) {
+	fake := startFakeDNSServer(t, 0)
+	pool := NewProbePool(16)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 100; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			requests := []DnsRequestData{
+				CreateDnsRequestData(fake.target(fmt.Sprintf("g%d-a", g), fakeNameOK)),
+				CreateDnsRequestData(fake.target(fmt.Sprintf("g%d-b", g), fakeNameOK)),
+			}
+			for i, result := range pool.ProbeAll(context.Background(), requests) {
+				if result.ServerID != requests[i].ServerID || result.Status != ProbeStatusOK {
+					t.Errorf("group %d: result %d: server %q status %s", g, i, result.ServerID, result.Status)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// TestProbePoolCancelled проверяет, что после отмены проверки запросы из очереди не выполняются
+// и возвращаются с ошибкой отмены (cancelledResponse)
+func TestProbePoolCancelled(t *testing.T) {
+	t.Run("before start", func(t *testing.T) {
+		fake := startFakeDNSServer(t, 0)
+		pool := NewProbePool(2)
+		defer pool.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		requests := []DnsRequestData{
+			CreateDnsRequestData(fake.target("s1", fakeNameOK)),
+			CreateDnsRequestData(fake.target("s2", fakeNameOK)),
+			CreateDnsRequestData(fake.target("s3", fakeNameOK)),
+		}
+		for i, result := range pool.ProbeAll(ctx, requests) {
+			assertCancelled(t, result, requests[i].ServerID)
+		}
+	})
+
+	t.Run("while queued", func(t *testing.T) {
+		fake := startFakeDNSServer(t, 0)
+		pool := NewProbePool(1)
+		defer pool.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Единственный воркер занят запросом, на который сервер не отвечает до отмены проверки
+		requests := []DnsRequestData{CreateDnsRequestData(fake.target("slow", fakeNameSlow))}
+		for i := 0; i < 5; i++ {
+			requests = append(requests, CreateDnsRequestData(fake.target(fmt.Sprintf("queued%d", i), fakeNameOK)))
+		}
+		done := make(chan []DnsResponseData)
+		go func() { done <- pool.ProbeAll(ctx, requests) }()
+		select {
+		case <-fake.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("slow request did not reach the server")
+		}
+		cancel()
+
+		var results []DnsResponseData
+		select {
+		case results = <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("ProbeAll did not return after cancellation")
+		}
+		if results[0].Status == ProbeStatusOK {
+			t.Errorf("slow request succeeded after cancellation")
+		}
+		for i, result := range results[1:] {
+			assertCancelled(t, result, requests[i+1].ServerID)
+		}
+	})
+}
+
+// assertCancelled проверяет, что result - результат невыполненного из-за отмены запроса к серверу serverID
+func assertCancelled(t *testing.T, result DnsResponseData, serverID string) {
+	t.Helper()
+	if result.ServerID != serverID {
+		t.Errorf("result for %q, want %q", result.ServerID, serverID)
+	}
+	if result.Status != ProbeStatusNetworkError || result.Rcode != -1 || result.Availability {
+		t.Errorf("server %s: status %s, rcode %d, available %t; want network_error, -1, false", serverID, result.Status, result.Rcode, result.Availability)
+	}
+	if result.Attempts != 0 {
+		t.Errorf("server %s: %d attempts, want none", serverID, result.Attempts)
+	}
+	if !strings.Contains(result.FailureDetail, context.Canceled.Error()) {
+		t.Errorf("server %s: failure detail %q does not mention cancellation", serverID, result.FailureDetail)
+	}
+}
+
+// TestCancelledResponse проверяет результат, формируемый для отмененного запроса
+func TestCancelledResponse(t *testing.T) {
+	request := DnsRequestData{ServerID: "s1", Address: "192.0.2.1"}
+	result := cancelledResponse(request, context.DeadlineExceeded)
+	if result.ServerID != "s1" || result.Request.Address != "192.0.2.1" {
+		t.Errorf("request data is not kept: %+v", result)
+	}
+	if result.FailureReason != string(ProbeStatusNetworkError) || result.FailureDetail != context.DeadlineExceeded.Error() {
+		t.Errorf("failure %q / %q", result.FailureReason, result.FailureDetail)
+	}
+}
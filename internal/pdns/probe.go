@@ -0,0 +1,180 @@
+package pdns
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"main/pkg/contain"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ProbePath - путь обработчика запросов к произвольным серверам (аналог /probe у blackbox_exporter)
+const ProbePath = "/probe"
+
+// ProbeHandler возвращает обработчик /probe, который выполняет один DNS запрос к серверу из параметров
+// HTTP запроса и отдает его результат в виде метрик из отдельного реестра. Параметры:
+//   - target - адрес сервера ("10.0.0.1", "10.0.0.1:53", "[::1]:53") или URL DoH из dohURLs модуля, обязательный;
+//   - record - запрашиваемая запись, обязательный;
+//   - module - имя модуля из probeModules (если не задан, используются параметры по умолчанию);
+//   - type - тип запроса, переопределяет тип из модуля.
+//
+// Серверы не обязаны быть перечислены в groupsDns. Модули берутся из текущей конфигурации current.
+func ProbeHandler(current func() *Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf := current()
+		params := r.URL.Query()
+		// Без модуля используются параметры по умолчанию и probeDefaults
+		module := ProbeModule{Probe: conf.ProbeDefaults}
+		if name := params.Get("module"); name != "" {
+			var ok bool
+			if module, ok = conf.ProbeModules[name]; !ok {
+				http.Error(w, fmt.Sprintf("unknown module %q", name), http.StatusBadRequest)
+				return
+			}
+		}
+		if queryType := params.Get("type"); queryType != "" {
+			if _, err := parseQueryType(queryType); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			module.QueryType = queryType
+		}
+		record := params.Get("record")
+		if record == "" {
+			http.Error(w, "record parameter is missing", http.StatusBadRequest)
+			return
+		}
+		if !validDomainName(record, true) {
+			http.Error(w, fmt.Sprintf("record %q is not a valid domain name", record), http.StatusBadRequest)
+			return
+		}
+		target, err := probeTarget(module, params.Get("target"), record)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Запрос не должен выполняться дольше, чем Prometheus ждет ответа
+		ctx := r.Context()
+		if timeout, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout*float64(time.Second)))
+			defer cancel()
+		}
+
+		slog.Debug("Probing DNS server.", slog.String("target", params.Get("target")), slog.String("record", record), slog.String("module", params.Get("module")))
+		start := time.Now()
+		result := DnsRequest(ctx, CreateDnsRequestData(target))
+		duration := time.Since(start)
+
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(newProbeCollector(result, duration))
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// probeTarget формирует описание сервера по параметру target и модулю.
+// Если порт не указан, используется стандартный порт транспорта модуля.
+// URL DoH принимается, только если он перечислен в dohURLs модуля: иначе через /probe
+// можно было бы отправлять HTTP запросы на произвольные адреса.
+func probeTarget(module ProbeModule, address, record string) (DNSTarget, error) {
+	if address == "" {
+		return DNSTarget{}, fmt.Errorf("target parameter is missing")
+	}
+	if strings.Contains(address, "://") {
+		if !contain.ContainString(module.DohURLs, address) {
+			return DNSTarget{}, fmt.Errorf("target %q: DoH URL is not listed in dohURLs of the module", address)
+		}
+		target := module.target("", 0, record)
+		target.ServerID, target.DohURL = address, address
+		target.Transport = TransportDoH
+		return target, nil
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		// Адрес без порта, в том числе IPv6 ("::1" или "[::1]")
+		host, portStr = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]"), ""
+	}
+	port := 0
+	if portStr != "" {
+		port, err = strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return DNSTarget{}, fmt.Errorf("target %q: invalid port", address)
+		}
+	}
+	if host == "" {
+		return DNSTarget{}, fmt.Errorf("target %q: empty host", address)
+	}
+	target := module.target(host, port, record)
+	target.ServerID = address
+	return target, nil
+}
+
+// probeCollector отдает метрики одного запроса обработчика /probe
+type probeCollector struct {
+	result   DnsResponseData // Результат запроса
+	duration time.Duration   // Общая длительность запроса вместе с повторами
+}
+
+var (
+	probeSuccessDesc      = prometheus.NewDesc("probe_success", "Whether the DNS probe succeeded (1) or not (0)", nil, nil)
+	probeDurationDesc     = prometheus.NewDesc("probe_duration_seconds", "Total probe duration including retries, in seconds", nil, nil)
+	probeResponseTimeDesc = prometheus.NewDesc("probe_dns_response_time_seconds", "Response time of the last attempt in seconds", nil, nil)
+	probeRcodeDesc        = prometheus.NewDesc("probe_dns_rcode", "Rcode of the response (-1 if no response was received)", nil, nil)
+	probeAttemptsDesc     = prometheus.NewDesc("probe_dns_attempts", "Number of attempts made", nil, nil)
+	probeAnswersDesc      = prometheus.NewDesc("probe_dns_answer_records", "Number of records in the answer section", nil, nil)
+	probeStatusDesc       = prometheus.NewDesc("probe_dns_status", "Probe result classification (1 for the current status)", []string{"status"}, nil)
+	probeFailureDesc      = prometheus.NewDesc("probe_dns_failure_reason", "Reason the probe failed; present only for failed probes", []string{"reason"}, nil)
+)
+
+// newProbeCollector создает коллектор метрик результата запроса
+func newProbeCollector(result DnsResponseData, duration time.Duration) *probeCollector {
+	return &probeCollector{result: result, duration: duration}
+}
+
+// Describe передает дескрипторы метрик /probe
+func (c *probeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- probeSuccessDesc
+	ch <- probeDurationDesc
+	ch <- probeResponseTimeDesc
+	ch <- probeRcodeDesc
+	ch <- probeAttemptsDesc
+	ch <- probeAnswersDesc
+	ch <- probeStatusDesc
+	ch <- probeFailureDesc
+}
+
+// Collect отдает метрики результата запроса
+func (c *probeCollector) Collect(ch chan<- prometheus.Metric) {
+	result := c.result
+	success, answers := 0.0, 0.0
+	if result.Availability {
+		success = 1
+	}
+	if result.Msg != nil {
+		answers = float64(len(result.Msg.Answer))
+	}
+	ch <- prometheus.MustNewConstMetric(probeSuccessDesc, prometheus.GaugeValue, success)
+	ch <- prometheus.MustNewConstMetric(probeDurationDesc, prometheus.GaugeValue, c.duration.Seconds())
+	ch <- prometheus.MustNewConstMetric(probeResponseTimeDesc, prometheus.GaugeValue, result.TimeToResponse.Seconds())
+	ch <- prometheus.MustNewConstMetric(probeRcodeDesc, prometheus.GaugeValue, float64(result.Rcode))
+	ch <- prometheus.MustNewConstMetric(probeAttemptsDesc, prometheus.GaugeValue, float64(result.Attempts))
+	ch <- prometheus.MustNewConstMetric(probeAnswersDesc, prometheus.GaugeValue, answers)
+	for _, status := range ProbeStatuses {
+		value := 0.0
+		if result.Status == status {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(probeStatusDesc, prometheus.GaugeValue, value, string(status))
+	}
+	if result.FailureReason != "" {
+		ch <- prometheus.MustNewConstMetric(probeFailureDesc, prometheus.GaugeValue, 1, result.FailureReason)
+	}
+}
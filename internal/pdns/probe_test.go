@@ -0,0 +1,79 @@
+package pdns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestProbeTarget проверяет разбор параметра target: адреса с портом и без, а URL DoH только из dohURLs модуля
+func TestProbeTarget(t *testing.T) {
+	module := ProbeModule{DohURLs: []string{"https://dns.example.org/dns-query"}}
+	tests := []struct {
+		address       string
+		wantIP        string
+		wantPort      int
+		wantDohURL    string
+		wantTransport string
+		wantErr       bool
+	}{
+		{address: "192.0.2.1", wantIP: "192.0.2.1"},
+		{address: "192.0.2.1:5353", wantIP: "192.0.2.1", wantPort: 5353},
+		{address: "[2001:db8::53]:53", wantIP: "2001:db8::53", wantPort: 53},
+		{address: "2001:db8::53", wantIP: "2001:db8::53"},
+		{address: "https://dns.example.org/dns-query", wantDohURL: "https://dns.example.org/dns-query", wantTransport: TransportDoH},
+		{address: "https://dns.example.org/other", wantErr: true},
+		{address: "http://dns.example.org/dns-query", wantErr: true},
+		{address: "https://169.254.169.254/latest/meta-data", wantErr: true},
+		{address: "file:///etc/passwd", wantErr: true},
+		{address: "192.0.2.1:0", wantErr: true},
+		{address: "192.0.2.1:65536", wantErr: true},
+		{address: ":53", wantErr: true},
+		{address: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			target, err := probeTarget(module, tt.address, "example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if target.IP != tt.wantIP || target.DNSPort != tt.wantPort || target.DohURL != tt.wantDohURL || target.Transport != tt.wantTransport {
+				t.Errorf("target IP %q, port %d, dohURL %q, transport %q", target.IP, target.DNSPort, target.DohURL, target.Transport)
+			}
+		})
+	}
+	if _, err := probeTarget(ProbeModule{}, "https://dns.example.org/dns-query", "example.com"); err == nil {
+		t.Error("DoH URL accepted without a module")
+	}
+}
+
+// TestProbeHandlerBadRequest проверяет, что некорректные параметры отклоняются до выполнения запроса
+func TestProbeHandlerBadRequest(t *testing.T) {
+	conf := &Config{ProbeModules: map[string]ProbeModule{"doh": {Transport: TransportDoH}}}
+	handler := ProbeHandler(func() *Config { return conf })
+	tests := []struct {
+		name   string
+		params url.Values
+	}{
+		{"no target", url.Values{"record": {"example.com"}}},
+		{"no record", url.Values{"target": {"192.0.2.1"}}},
+		{"invalid record", url.Values{"target": {"192.0.2.1"}, "record": {"example.com;rm"}}},
+		{"record with spaces", url.Values{"target": {"192.0.2.1"}, "record": {"exa mple.com"}}},
+		{"unknown module", url.Values{"target": {"192.0.2.1"}, "record": {"example.com"}, "module": {"auth"}}},
+		{"unknown type", url.Values{"target": {"192.0.2.1"}, "record": {"example.com"}, "type": {"BOGUS"}}},
+		{"unlisted DoH URL", url.Values{"target": {"https://192.0.2.1/dns-query"}, "record": {"example.com"}, "module": {"doh"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ProbePath+"?"+tt.params.Encode(), nil))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package pdns
+
+import (
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ReloadPath - путь обработчика перезагрузки конфигурации (как /-/reload у Prometheus)
+const ReloadPath = "/-/reload"
+
+// Reloader - текущая конфигурация экспортера и ее перезагрузка из файла.
+// Новая конфигурация сначала проверяется и только затем заменяет текущую; при ошибке
+// продолжает действовать прежняя конфигурация. Перезагрузки выполняются последовательно.
+type Reloader struct {
+	mu      sync.Mutex              // Последовательное выполнение перезагрузок
+	source  ConfigSource            // Файл конфигурации, его формат и переопределения параметров
+	current atomic.Pointer[Config]  // Действующая конфигурация
+	apply   func(old, conf *Config) // Применяет новую конфигурацию (перезапуск проверок и т.д.)
+	sum     [sha256.Size]byte       // Контрольная сумма последнего прочитанного содержимого файла и подключенных файлов
+	updated chan struct{}           // Сигнал об успешной перезагрузке для отслеживания файла
+
+	success   prometheus.Gauge       // Результат последней перезагрузки
+	timestamp prometheus.Gauge       // Время последней успешной загрузки конфигурации
+	total     *prometheus.CounterVec // Количество перезагрузок по результату
+}
+
+// NewReloader создает перезагрузчик с уже загруженной из source конфигурацией conf.
+// При перезагрузке файл читается заново, а переопределения source.Overrides применяются повторно.
+// apply вызывается при каждой успешной перезагрузке до того, как новая конфигурация станет текущей.
+func NewReloader(source ConfigSource, conf *Config, apply func(old, conf *Config)) *Reloader {
+	r := &Reloader{
+		source:  source,
+		apply:   apply,
+		updated: make(chan struct{}, 1),
+		success: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful (1 - success, 0 - failure)",
+		}),
+		timestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful configuration load",
+		}),
+		total: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "config_reloads_total",
+			Help: "Total number of configuration reload attempts by result (success, failure)",
+		}, []string{"result"}),
+	}
+	r.current.Store(conf)
+	r.sum = configSum(source.Path, conf.Include)
+	r.success.Set(1)
+	r.timestamp.SetToCurrentTime()
+	return r
+}
+
+// Config возвращает действующую конфигурацию
+func (r *Reloader) Config() *Config {
+	return r.current.Load()
+}
+
+// Describe реализует интерфейс prometheus.Collector для метрик перезагрузки
+func (r *Reloader) Describe(ch chan<- *prometheus.Desc) {
+	r.success.Describe(ch)
+	r.timestamp.Describe(ch)
+	r.total.Describe(ch)
+}
+
+// Collect реализует интерфейс prometheus.Collector для метрик перезагрузки
+func (r *Reloader) Collect(ch chan<- prometheus.Metric) {
+	r.success.Collect(ch)
+	r.timestamp.Collect(ch)
+	r.total.Collect(ch)
+}
+
+// Reload перечитывает файл конфигурации и подключенные файлы и, если конфигурация корректна, применяет ее.
+// source - что вызвало перезагрузку (sighup, api, watch), используется в логах.
+func (r *Reloader) Reload(source string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Сумма запоминается и при ошибке, чтобы отслеживание файлов не повторяло перезагрузку до следующего изменения
+	r.sum = configSum(r.source.Path, r.Config().Include)
+	conf, err := LoadConfig(r.source)
+	if err == nil {
+		r.sum = configSum(r.source.Path, conf.Include)
+		r.swap(conf, source)
+		return nil
+	}
+	slog.Error("Configuration reload failed, keeping the current configuration", slog.String("source", source), slog.String("path", r.source.Path), slog.String("error", err.Error()))
+	r.success.Set(0)
+	r.total.WithLabelValues("failure").Inc()
+	return err
+}
+
+// swap применяет проверенную конфигурацию conf и делает ее текущей. Вызывается под блокировкой r.mu.
+func (r *Reloader) swap(conf *Config, source string) {
+	old := r.current.Load()
+	if changed := restartRequired(old, conf); len(changed) > 0 {
+		slog.Warn("Some configuration changes take effect only after a restart", slog.Any("settings", changed))
+	}
+	if r.apply != nil {
+		r.apply(old, conf)
+	}
+	r.current.Store(conf)
+	r.success.Set(1)
+	r.timestamp.SetToCurrentTime()
+	r.total.WithLabelValues("success").Inc()
+	slog.Info("Configuration reloaded", slog.String("source", source), slog.String("path", r.source.Path), slog.Int("groups", len(conf.GroupsDNS)))
+	select {
+	case r.updated <- struct{}{}:
+	default:
+	}
+}
+
+// Watch отслеживает изменения файла конфигурации и подключенных файлов и перезагружает ее при изменении содержимого.
+// Файл проверяется с интервалом configWatch из текущей конфигурации; если интервал не задан,
+// файл не проверяется, пока он не будет задан перезагрузкой. Работает до отмены ctx.
+func (r *Reloader) Watch(ctx context.Context) {
+	for {
+		var tick <-chan time.Time
+		if interval := time.Duration(r.Config().ConfigWatch); interval > 0 {
+			tick = time.After(interval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.updated:
+			// Интервал мог измениться, начинаем ожидание заново
+		case <-tick:
+			if r.changed() {
+				r.Reload("watch")
+			}
+		}
+	}
+}
+
+// changed проверяет, изменилось ли содержимое файла конфигурации или подключенных файлов с последнего чтения
+// (в том числе добавлены или удалены файлы в подключенных каталогах).
+// Отсутствующий или недоступный основной файл изменением не считается (например, во время его замены).
+func (r *Reloader) changed() bool {
+	if _, err := os.Stat(r.source.Path); err != nil {
+		slog.Debug("Failed to read configuration file", slog.String("path", r.source.Path), slog.String("error", err.Error()))
+		return false
+	}
+	sum := configSum(r.source.Path, r.Config().Include)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return sum != r.sum
+}
+
+// restartRequired возвращает параметры, изменения которых не применяются перезагрузкой:
+// адреса HTTP сервера, путь метрик и аутентификация (обработчики HTTP сервера создаются при запуске),
+// mTLS, границы корзин гистограмм, файл состояния и настройки логирования
+func restartRequired(old, conf *Config) []string {
+	settings := []struct {
+		name     string
+		old, new any
+	}{
+		{"web.metricsPath", old.metricsPath(), conf.metricsPath()},
+		{"web.listeners", old.listeners(), conf.listeners()},
+		{"web.auth", old.webAuth(), conf.webAuth()},
+		{"mtlsExporter", old.MtlsExporter, conf.MtlsExporter},
+		{"rttBuckets", old.RttBuckets, conf.RttBuckets},
+		{"stateFile", old.StateFile, conf.StateFile},
+		{"logPath", old.LogPath, conf.LogPath},
+		{"logLevel", old.LogLevel, conf.LogLevel},
+		{"logToFile", old.LogToFile, conf.LogToFile},
+		{"logToSyslog", old.LogToSyslog, conf.LogToSyslog},
+		{"logOutputs", old.LogOutputs, conf.LogOutputs},
+		{"logFormat", old.LogFormat, conf.LogFormat},
+		{"logAttributes", old.LogAttributes, conf.LogAttributes},
+	}
+	var changed []string
+	for _, setting := range settings {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
+
+// RegisterReloadAPI регистрирует обработчик POST /-/reload, перезагружающий конфигурацию.
+// Доступ проверяется по настройкам группы admin секции auth; без них обработчик недоступен (см. requireAuth).
+func RegisterReloadAPI(mux *http.ServeMux, reloader *Reloader) {
+	mux.HandleFunc("POST "+ReloadPath, requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		slog.Info("Configuration reload requested", slog.String("requestedBy", requestIdentity(r)))
+		if err := reloader.Reload("api"); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"message": "failed to reload configuration: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "configuration reloaded"})
+	}))
+}
@@ -0,0 +1,44 @@
+package pdns
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestRestartRequired проверяет, что о параметрах HTTP сервера, которые применяются только при запуске,
+// сообщается по отдельности, а изменения групп перезапуска не требуют
+func TestRestartRequired(t *testing.T) {
+	base := func() *Config {
+		return &Config{
+			Web: &WebConfig{
+				Listeners: []ListenerConfig{{Address: "127.0.0.1:9100"}},
+				Auth:      &AuthConfig{Admin: &EndpointAuth{AllowedNetworks: []string{"127.0.0.1"}}},
+			},
+			GroupsDNS: []GroupDNS{{GroupName: "g"}},
+		}
+	}
+	tests := []struct {
+		name   string
+		change func(conf *Config)
+		want   []string
+	}{
+		{"no changes", func(conf *Config) {}, nil},
+		{"groups", func(conf *Config) { conf.GroupsDNS = append(conf.GroupsDNS, GroupDNS{GroupName: "h"}) }, nil},
+		{"metrics path", func(conf *Config) { conf.Web.MetricsPath = "/m" }, []string{"web.metricsPath"}},
+		{"default metrics path", func(conf *Config) { conf.Web.MetricsPath = DefaultMetricsPath }, nil},
+		{"listeners", func(conf *Config) { conf.Web.Listeners[0].Address = "127.0.0.1:9101" }, []string{"web.listeners"}},
+		{"listener auth", func(conf *Config) { conf.Web.Listeners[0].Auth = &AuthConfig{} }, []string{"web.listeners"}},
+		{"auth", func(conf *Config) { conf.Web.Auth.Admin.AllowedNetworks = []string{"10.0.0.0/8"} }, []string{"web.auth"}},
+		{"auth removed", func(conf *Config) { conf.Web.Auth = nil }, []string{"web.auth"}},
+		{"web and logging", func(conf *Config) { conf.Web = nil; conf.LogLevel = "debug" }, []string{"web.listeners", "web.auth", "logLevel"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := base()
+			tt.change(conf)
+			if got := restartRequired(base(), conf); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("restartRequired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package pdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Форматы отчета о разовой проверке групп
+const (
+	ReportFormatTable = "table" // Таблица для чтения человеком
+	ReportFormatJSON  = "json"  // JSON в формате ответа /api/v1/groups
+)
+
+// WriteReport записывает результаты разовой проверки групп results в w в формате format (table или json).
+// checkedAt - время завершения проверки.
+func WriteReport(w io.Writer, results []AvailabilityGroup, checkedAt time.Time, format string) error {
+	switch format {
+	case ReportFormatJSON:
+		groups := make([]apiGroup, 0, len(results))
+		for _, result := range results {
+			groups = append(groups, newAPIGroup(GroupSnapshot{Result: result, CheckedAt: checkedAt}))
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(groups)
+	case ReportFormatTable:
+		return writeReportTable(w, results)
+	}
+	return fmt.Errorf("unknown report format %q (expected %s or %s)", format, ReportFormatTable, ReportFormatJSON)
+}
+
+// writeReportTable записывает результаты проверки в виде таблицы: строка на каждый сервер
+// и итоговая строка на каждую группу
+func writeReportTable(w io.Writer, results []AvailabilityGroup) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "GROUP\tSERVER\tADDRESS\tTRANSPORT\tRECORD\tSTATUS\tRCODE\tRTT\tDETAIL")
+	for _, result := range results {
+		for _, resp := range result.Responses {
+			server := newAPIServer(result.GroupName, resp, ServerHistory{})
+			detail := server.FailureReason
+			if server.FailureDetail != "" && server.FailureDetail != detail {
+				detail += ": " + server.FailureDetail
+			}
+			rtt := "-"
+			if !resp.Maintenance {
+				rtt = resp.TimeToResponse.Round(time.Microsecond).String()
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s %s\t%s\t%s\t%s\t%s\n",
+				result.GroupName, server.ID, net.JoinHostPort(server.Address, strconv.Itoa(server.Port)), server.Transport,
+				server.Record, server.QueryType, server.Status, server.Rcode, rtt, detail)
+		}
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "GROUP\tSTATE\tAVAILABLE\tUNAVAILABLE\tMAINTENANCE\tTOTAL")
+	for _, result := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\n", result.GroupName, result.State,
+			result.AvailabileServers, result.UnavailableServers, result.MaintenanceServers, result.AllServers)
+	}
+	return tw.Flush()
+}
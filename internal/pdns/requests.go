@@ -1,113 +1,312 @@
-package pdns
-
-import (
-	"fmt"
-	"log/slog"
-	"net"
-	"sync"
-	"time"
-
-	"github.com/miekg/dns"
-)
-
-// DnsResponseData хранит результаты выполнения DNS запроса:
-// - ID сервера,
-// - время отклика,
-// - сообщение с ответом от сервера,
-// - доступность сервера (успешно ли выполнен запрос).
-type DnsResponseData struct {
-	ServerID       string        // Идентификатор сервера
-	TimeToResponse time.Duration // Время отклика от DNS сервера
-	Msg            *dns.Msg      // Сообщение с ответом DNS сервера
-	Availability   bool          // Указывает, был ли сервер доступен (запрос успешен)
-}
-
-// DnsRequestData содержит данные, необходимые для выполнения DNS запроса:
-// - ID сервера,
-// - адрес и порт сервера,
-// - полностью квалифицированное доменное имя (FQDN).
-type DnsRequestData struct {
-	ServerID string // Идентификатор сервера
-	Address  string // IP адрес или хостнейм DNS сервера
-	Fqdn     string // Полностью квалифицированное доменное имя для запроса
-	Port     int32  // Порт DNS сервера
-}
-
-// CreateDnsRequestData создает и возвращает структуру DnsRequestData с необходимыми данными для DNS запроса
-// Аргументы:
-// - serverID: идентификатор DNS сервера,
-// - address: адрес DNS сервера (IP или хостнейм),
-// - record: FQDN для DNS запроса,
-// - dnsPort: порт для DNS запроса.
-func CreateDnsRequestData(serverID, address, record string, dnsPort int32) DnsRequestData {
-	slog.Debug("Creating DNS request data.", slog.String("serverID", serverID), slog.String("address", address), slog.String("record", record), slog.Int("dnsPort", int(dnsPort)))
-	return DnsRequestData{
-		ServerID: serverID,
-		Address:  address,
-		Fqdn:     record,
-		Port:     dnsPort,
-	}
-}
-
-// CreateDnsClient создает и настраивает новый DNS клиент с нужными тайм-аутами для операций записи и чтения.
-// Возвращает указатель на сконфигурированный DNS клиент.
-func CreateDnsClient() *dns.Client {
-	slog.Debug("Creating DNS client.")
-	var dnsClient dns.Client
-	// Настройка Dialer для соединений с DNS сервером
-	dnsClient.Dialer = &net.Dialer{
-		Timeout: 1 * time.Second, // Тайм-аут соединения с DNS сервером
-	}
-	// Устанавливаем тайм-ауты для операций чтения и записи
-	dnsClient.ReadTimeout = 2 * time.Second
-	dnsClient.WriteTimeout = 2 * time.Second
-	return &dnsClient
-}
-
-// DnsRequest выполняет DNS запрос к указанному серверу и передает результат в канал chDns.
-// Для выполнения используется DNS клиент, переданный в качестве аргумента.
-// После выполнения запроса горутина завершает работу (defer wg.Done()).
-func DnsRequest(drd DnsRequestData, chDns chan DnsResponseData, dnsClient *dns.Client, wg *sync.WaitGroup) {
-	defer wg.Done() // Обеспечиваем, что горутина завершится при выходе из функции
-	var (
-		msg        dns.Msg // Сообщение для запроса
-		checkAvail bool    // Флаг доступности DNS сервера
-	)
-	slog.Debug("Preparing DNS request", slog.String("serverID", drd.ServerID), slog.String("fqdn", drd.Fqdn), slog.String("address", drd.Address))
-
-	// Формируем запрос DNS на основе FQDN
-	fqdn := dns.Fqdn(drd.Fqdn)
-
-	// Устанавливаем тип запроса (A-запись)
-	msg.SetQuestion(fqdn, dns.TypeA)
-
-	// Логируем начало запроса
-	slog.Info("Sending DNS request.", slog.String("address", drd.Address), slog.String("fqdn", fqdn), slog.Int("port", int(drd.Port)))
-
-	// Выполняем запрос к DNS серверу по указанному адресу и порту
-	resp, ttr, err := dnsClient.Exchange(&msg, fmt.Sprintf("%s:%d", drd.Address, drd.Port))
-	if err != nil {
-		// В случае ошибки считаем сервер недоступным
-		checkAvail = false
-		slog.Warn("DNS request failed.", slog.String("serverID", drd.ServerID), slog.String("address", drd.Address), slog.String("error", err.Error()))
-	} else {
-		// Если запрос успешен, считаем сервер доступным
-		checkAvail = true
-		slog.Info("DNS request succeeded.", slog.String("serverID", drd.ServerID), slog.String("address", drd.Address), slog.Duration("responseTime", time.Duration(ttr.Milliseconds())))
-	}
-	// Формируем структуру с результатами запроса
-	responseDns := DnsResponseData{
-		ServerID:       drd.ServerID,                      // Идентификатор сервера
-		Availability:   checkAvail,                        // Доступность сервера
-		TimeToResponse: time.Duration(ttr.Milliseconds()), // Время отклика сервера (в миллисекундах)
-		Msg:            resp,                              // Ответ от DNS сервера
-	}
-	// Логируем результат запроса
-	if checkAvail {
-		slog.Info("DNS response received.", slog.String("serverID", drd.ServerID), slog.String("address", drd.Address), slog.Duration("timeToResponse", responseDns.TimeToResponse))
-	} else {
-		slog.Warn("DNS response not received.", slog.String("serverID", drd.ServerID), slog.String("address", drd.Address))
-	}
-	// Отправляем результат в канал для дальнейшей обработки
-	chDns <- responseDns
-}
+package pdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ProbeStatus - классификация результата DNS запроса к серверу
+type ProbeStatus string
+
+const (
+	ProbeStatusOK           ProbeStatus = "ok"               // Получен ответ с ожидаемым кодом
+	ProbeStatusBadRcode     ProbeStatus = "bad_rcode"        // Получен ответ с неожиданным кодом (например, SERVFAIL или REFUSED)
+	ProbeStatusTimeout      ProbeStatus = "timeout"          // Сервер не ответил за отведенное время
+	ProbeStatusNetworkError ProbeStatus = "network_error"    // Ошибка сети (соединение отклонено, хост недоступен и т.д.)
+	ProbeStatusTruncated    ProbeStatus = "truncated"        // Получен усеченный ответ (установлен флаг TC)
+	ProbeStatusAssertion    ProbeStatus = "assertion_failed" // Ответ получен, но не прошел проверки содержимого
+)
+
+// ProbeStatuses - список всех возможных результатов DNS запроса
+var ProbeStatuses = []ProbeStatus{
+	ProbeStatusOK,
+	ProbeStatusBadRcode,
+	ProbeStatusTimeout,
+	ProbeStatusNetworkError,
+	ProbeStatusTruncated,
+	ProbeStatusAssertion,
+}
+
+// DnsResponseData хранит результаты выполнения DNS запроса:
+// - ID сервера,
+// - время отклика,
+// - сообщение с ответом от сервера,
+// - классификацию результата и код ответа,
+// - причину недоступности сервера,
+// - доступность сервера (успешно ли выполнен запрос) и количество попыток,
+// - данные исходного запроса.
+type DnsResponseData struct {
+	ServerID       string         // Идентификатор сервера
+	Request        DnsRequestData // Данные запроса, по которому получен результат (адрес, порт, запись)
+	Maintenance    bool           // Сервер находится на обслуживании, запрос не выполнялся
+	TimeToResponse time.Duration  // Время отклика от DNS сервера
+	Msg            *dns.Msg       // Сообщение с ответом DNS сервера
+	Status         ProbeStatus    // Классификация результата запроса
+	Rcode          int            // Код ответа DNS сервера (-1, если ответ не получен)
+	FailureReason  string         // Краткая причина недоступности (классификация или непройденная проверка), пусто для доступного сервера
+	FailureDetail  string         // Подробное описание причины недоступности для логов
+	Availability   bool           // Указывает, был ли сервер доступен (Status == ProbeStatusOK)
+	Attempts       int            // Количество выполненных попыток запроса (с учетом повторов)
+}
+
+// DnsRequestData содержит данные, необходимые для выполнения DNS запроса:
+// - ID сервера,
+// - адрес и порт сервера,
+// - полностью квалифицированное доменное имя (FQDN),
+// - тип и класс DNS запроса,
+// - ожидаемые коды ответа,
+// - проверки содержимого ответа,
+// - транспорт и его параметры (TLS, DoH),
+// - тайм-ауты, количество повторов и задержку между ними.
+type DnsRequestData struct {
+	ServerID       string              // Идентификатор сервера
+	Address        string              // IP адрес или хостнейм DNS сервера
+	Fqdn           string              // Полностью квалифицированное доменное имя для запроса
+	Port           int32               // Порт DNS сервера
+	QueryType      uint16              // Тип DNS запроса (dns.TypeA, dns.TypeSOA и т.д.)
+	QueryClass     uint16              // Класс DNS запроса (dns.ClassINET, dns.ClassCHAOS и т.д.)
+	ExpectedRcodes []int               // Коды ответа, при которых сервер считается доступным
+	Assertions     *ResponseAssertions // Проверки содержимого ответа (nil, если не заданы)
+	Transport      string              // Транспорт запроса: udp, tcp, dot, doh или doq
+	TLSServerName  string              // Имя сервера для проверки TLS сертификата (DoT, DoH, DoQ)
+	TLSCAFile      string              // Путь к файлу с CA для проверки TLS сертификата сервера
+	DohURL         string              // URL DoH сервера
+	DohMethod      string              // HTTP метод DoH запроса: GET или POST
+	Timeouts       clientTimeouts      // Тайм-ауты соединения, записи и чтения
+	Retries        int                 // Количество повторов при тайм-ауте или ошибке сети
+	RetryDelay     time.Duration       // Задержка перед первым повтором (удваивается с каждым повтором)
+}
+
+// CreateDnsRequestData создает и возвращает структуру DnsRequestData с необходимыми данными для DNS запроса
+// на основе описания сервера из конфигурации. Тип и класс запроса к этому моменту уже проверены
+// при загрузке конфигурации, поэтому при ошибке разбора используются значения по умолчанию (A, IN, NOERROR).
+func CreateDnsRequestData(target DNSTarget) DnsRequestData {
+	qtype, err := parseQueryType(target.QueryType)
+	if err != nil {
+		qtype = dns.TypeA
+	}
+	qclass, err := parseQueryClass(target.QueryClass)
+	if err != nil {
+		qclass = dns.ClassINET
+	}
+	rcodes, err := parseRcodes(target.ExpectedRcodes)
+	if err != nil {
+		rcodes = []int{dns.RcodeSuccess}
+	}
+	// Если ожидается NXDOMAIN, а коды ответа явно не заданы, то NXDOMAIN и есть ожидаемый код
+	if target.Assertions != nil && target.Assertions.MustBeNXDOMAIN && len(target.ExpectedRcodes) == 0 {
+		rcodes = []int{dns.RcodeNameError}
+	}
+	transport, err := parseTransport(target.Transport)
+	if err != nil {
+		transport = TransportUDP
+	}
+	dohMethod, err := parseDohMethod(target.DohMethod)
+	if err != nil {
+		dohMethod = http.MethodPost
+	}
+	// Если порт не задан, используем стандартный порт транспорта
+	port := target.DNSPort
+	if port == 0 {
+		port = defaultTransportPorts[transport]
+	}
+	probe := target.Probe.resolve()
+	dohURL := target.DohURL
+	if transport == TransportDoH && dohURL == "" {
+		dohURL = "https://" + net.JoinHostPort(target.IP, strconv.Itoa(port)) + "/dns-query"
+	}
+	slog.Debug("Creating DNS request data.", slog.String("serverID", target.ServerID), slog.String("address", target.IP), slog.String("record", target.RequestedRecord), slog.Int("dnsPort", target.DNSPort), slog.String("queryType", dns.TypeToString[qtype]), slog.String("queryClass", dns.ClassToString[qclass]))
+	return DnsRequestData{
+		ServerID:       target.ServerID,
+		Address:        target.IP,
+		Fqdn:           target.RequestedRecord,
+		Port:           int32(port),
+		QueryType:      qtype,
+		QueryClass:     qclass,
+		ExpectedRcodes: rcodes,
+		Assertions:     target.Assertions,
+		Transport:      transport,
+		TLSServerName:  target.TLSServerName,
+		TLSCAFile:      target.TLSCAFile,
+		DohURL:         dohURL,
+		DohMethod:      dohMethod,
+		Timeouts:       probe.timeouts(),
+		Retries:        *probe.Retries,
+		RetryDelay:     time.Duration(*probe.RetryDelay),
+	}
+}
+
+// CreateDnsClient создает и настраивает новый DNS клиент для транспорта запроса с тайм-аутами
+// соединения, операций записи и чтения из конфигурации сервера. Для DoT, DoH и DoQ также настраивается проверка TLS сертификата сервера.
+// Возвращает ошибку, если не удалось подготовить TLS конфигурацию (например, прочитать файл CA).
+func CreateDnsClient(drd DnsRequestData) (DnsExchanger, error) {
+	slog.Debug("Creating DNS client.", slog.String("serverID", drd.ServerID), slog.String("transport", drd.Transport))
+	timeouts := drd.Timeouts
+	switch drd.Transport {
+	case TransportTCP:
+		return newClassicClient("tcp", timeouts, nil), nil
+	case TransportDoT:
+		tlsConfig, err := drd.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		return newClassicClient("tcp-tls", timeouts, tlsConfig), nil
+	case TransportDoH:
+		tlsConfig, err := drd.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		return newDohClient(drd.DohMethod, timeouts, tlsConfig), nil
+	case TransportDoQ:
+		tlsConfig, err := drd.tlsConfig("doq")
+		if err != nil {
+			return nil, err
+		}
+		return &doqClient{tlsConfig: tlsConfig, timeouts: timeouts}, nil
+	default:
+		return newClassicClient("udp", timeouts, nil), nil
+	}
+}
+
+// classifyResponse определяет результат DNS запроса по ошибке обмена и полученному ответу.
+// Возвращает классификацию результата и код ответа (-1, если ответ не получен).
+func classifyResponse(resp *dns.Msg, err error, expectedRcodes []int) (ProbeStatus, int) {
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+			return ProbeStatusTimeout, -1
+		}
+		return ProbeStatusNetworkError, -1
+	}
+	if resp == nil {
+		return ProbeStatusNetworkError, -1
+	}
+	if resp.Truncated {
+		return ProbeStatusTruncated, resp.Rcode
+	}
+	for _, rcode := range expectedRcodes {
+		if resp.Rcode == rcode {
+			return ProbeStatusOK, resp.Rcode
+		}
+	}
+	return ProbeStatusBadRcode, resp.Rcode
+}
+
+// DnsRequest выполняет DNS запрос к указанному серверу и возвращает результат.
+// DNS клиент создается для транспорта, указанного в drd.
+// Сервер считается доступным, только если получен неусеченный ответ с одним из ожидаемых кодов,
+// прошедший все проверки содержимого.
+// При отмене ctx запрос прерывается, а повторы не выполняются.
+func DnsRequest(ctx context.Context, drd DnsRequestData) DnsResponseData {
+	var msg dns.Msg // Сообщение для запроса
+	slog.Debug("Preparing DNS request", slog.String("serverID", drd.ServerID), slog.String("fqdn", drd.Fqdn), slog.String("address", drd.Address))
+
+	// Формируем запрос DNS на основе FQDN
+	fqdn := dns.Fqdn(drd.Fqdn)
+
+	// Устанавливаем тип и класс запроса из конфигурации сервера
+	msg.SetQuestion(fqdn, drd.QueryType)
+	msg.Question[0].Qclass = drd.QueryClass
+
+	// Логируем начало запроса
+	slog.Info("Sending DNS request.", slog.String("address", drd.Address), slog.String("fqdn", fqdn), slog.Int("port", int(drd.Port)), slog.String("transport", drd.Transport), slog.String("queryType", dns.TypeToString[drd.QueryType]), slog.String("queryClass", dns.ClassToString[drd.QueryClass]))
+
+	// Выполняем запрос к DNS серверу по указанному адресу и порту (или URL для DoH)
+	var (
+		resp *dns.Msg
+		ttr  time.Duration
+	)
+	dnsClient, err := CreateDnsClient(drd)
+	// Классифицируем результат: только ответ с ожидаемым кодом означает доступность сервера
+	status, rcode := classifyResponse(nil, err, drd.ExpectedRcodes)
+	attempts := 0
+	if err == nil {
+		// Повторяем запрос при тайм-ауте или ошибке сети (например, потерянном UDP пакете),
+		// увеличивая задержку между попытками вдвое после каждой неудачи
+		delay := drd.RetryDelay
+		for attempts < drd.Retries+1 {
+			attempts++
+			resp, ttr, err = dnsClient.ExchangeContext(ctx, &msg, drd.serverAddress())
+			status, rcode = classifyResponse(resp, err, drd.ExpectedRcodes)
+			if status != ProbeStatusTimeout && status != ProbeStatusNetworkError {
+				break
+			}
+			if attempts <= drd.Retries {
+				slog.Debug("Retrying DNS request.", slog.String("serverID", drd.ServerID), slog.String("address", drd.Address), slog.Int("attempt", attempts), slog.String("status", string(status)), slog.Duration("delay", delay))
+				if !sleepContext(ctx, delay) {
+					break
+				}
+				delay *= 2
+			}
+		}
+	}
+	var failureReason, failureDetail string
+	switch {
+	case status == ProbeStatusOK:
+		// Ответ с ожидаемым кодом получен, проверяем его содержимое
+		if reason, detail := drd.Assertions.check(resp); reason != "" {
+			status, failureReason, failureDetail = ProbeStatusAssertion, reason, detail
+			slog.Warn("DNS response assertion failed.", slog.String("serverID", drd.ServerID), slog.String("address", drd.Address), slog.String("reason", reason), slog.String("detail", detail))
+		}
+	case err != nil:
+		failureReason, failureDetail = string(status), err.Error()
+	default:
+		failureReason, failureDetail = string(status), fmt.Sprintf("rcode %s, truncated %t", rcodeName(rcode), resp.Truncated)
+	}
+	if err != nil {
+		slog.Warn("DNS request failed.", slog.String("serverID", drd.ServerID), slog.String("address", drd.Address), slog.String("status", string(status)), slog.Int("attempts", attempts), slog.String("error", err.Error()))
+	} else {
+		slog.Info("DNS request succeeded.", slog.String("serverID", drd.ServerID), slog.String("address", drd.Address), slog.String("rcode", rcodeName(rcode)), slog.Int("attempts", attempts), slog.Duration("responseTime", ttr))
+	}
+	// Формируем структуру с результатами запроса
+	responseDns := DnsResponseData{
+		ServerID:       drd.ServerID,            // Идентификатор сервера
+		Request:        drd,                     // Данные запроса
+		Status:         status,                  // Классификация результата
+		Rcode:          rcode,                   // Код ответа сервера
+		FailureReason:  failureReason,           // Причина недоступности сервера
+		FailureDetail:  failureDetail,           // Подробности причины недоступности
+		Availability:   status == ProbeStatusOK, // Доступность сервера
+		TimeToResponse: ttr,                     // Время отклика сервера
+		Attempts:       attempts,                // Количество выполненных попыток
+		Msg:            resp,                    // Ответ от DNS сервера
+	}
+	// Логируем результат запроса
+	if responseDns.Availability {
+		slog.Info("DNS response received.", slog.String("serverID", drd.ServerID), slog.String("address", drd.Address), slog.Duration("timeToResponse", responseDns.TimeToResponse))
+	} else {
+		slog.Warn("DNS server considered unavailable.", slog.String("serverID", drd.ServerID), slog.String("address", drd.Address), slog.String("status", string(status)), slog.String("rcode", rcodeName(rcode)), slog.String("reason", failureReason), slog.String("detail", failureDetail))
+	}
+	return responseDns
+}
+
+// sleepContext ожидает delay и возвращает false, если ctx был отменен раньше
+func sleepContext(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// rcodeName возвращает строковое имя кода ответа DNS; для отсутствующего ответа (-1) возвращает "none".
+func rcodeName(rcode int) string {
+	if rcode < 0 {
+		return "none"
+	}
+	if name, ok := dns.RcodeToString[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
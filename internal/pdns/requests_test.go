@@ -0,0 +1,49 @@
+package pdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// TestClassifyResponse проверяет классификацию результата запроса по ошибке и ответу
+func TestClassifyResponse(t *testing.T) {
+	reply := func(rcode int, truncated bool) *dns.Msg {
+		return &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: rcode, Truncated: truncated}}
+	}
+	timeout := &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}
+	refused := &net.OpError{Op: "read", Net: "udp", Err: errors.New("connection refused")}
+	tests := []struct {
+		name       string
+		resp       *dns.Msg
+		err        error
+		expected   []int
+		wantStatus ProbeStatus
+		wantRcode  int
+	}{
+		{"expected rcode", reply(dns.RcodeSuccess, false), nil, []int{dns.RcodeSuccess}, ProbeStatusOK, dns.RcodeSuccess},
+		{"one of expected", reply(dns.RcodeNameError, false), nil, []int{dns.RcodeSuccess, dns.RcodeNameError}, ProbeStatusOK, dns.RcodeNameError},
+		{"unexpected rcode", reply(dns.RcodeServerFailure, false), nil, []int{dns.RcodeSuccess}, ProbeStatusBadRcode, dns.RcodeServerFailure},
+		{"no expected rcodes", reply(dns.RcodeSuccess, false), nil, nil, ProbeStatusBadRcode, dns.RcodeSuccess},
+		{"truncated", reply(dns.RcodeSuccess, true), nil, []int{dns.RcodeSuccess}, ProbeStatusTruncated, dns.RcodeSuccess},
+		{"network timeout", nil, timeout, []int{dns.RcodeSuccess}, ProbeStatusTimeout, -1},
+		{"context deadline", nil, fmt.Errorf("exchange: %w", context.DeadlineExceeded), []int{dns.RcodeSuccess}, ProbeStatusTimeout, -1},
+		{"network error", nil, refused, []int{dns.RcodeSuccess}, ProbeStatusNetworkError, -1},
+		{"cancelled", nil, context.Canceled, []int{dns.RcodeSuccess}, ProbeStatusNetworkError, -1},
+		{"error with response", reply(dns.RcodeSuccess, false), refused, []int{dns.RcodeSuccess}, ProbeStatusNetworkError, -1},
+		{"no response", nil, nil, []int{dns.RcodeSuccess}, ProbeStatusNetworkError, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, rcode := classifyResponse(tt.resp, tt.err, tt.expected)
+			if status != tt.wantStatus || rcode != tt.wantRcode {
+				t.Errorf("classifyResponse = %s, %d; want %s, %d", status, rcode, tt.wantStatus, tt.wantRcode)
+			}
+		})
+	}
+}
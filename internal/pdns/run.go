@@ -1,184 +1,630 @@
-package pdns
-
-import (
-	"log/slog"
-	"main/pkg/web"
-	"net/http"
-	"sync"
-
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-)
-
-// DnsMetricsDesc содержит дескрипторы метрик для мониторинга состояния DNS серверов в группе
-type DnsMetricsDesc struct {
-	AllServers         *prometheus.Desc // Дескриптор метрики для общего количества DNS серверов в группе
-	AvailabileServers  *prometheus.Desc // Дескриптор метрики для доступных DNS серверов
-	UnavailableServers *prometheus.Desc // Дескриптор метрики для недоступных DNS серверов
-	MaintenanceServers *prometheus.Desc // Дескриптор метрики для серверов, находящихся на обслуживании
-}
-
-// глобальные переменные для конфигурации и ошибок при чтении конфигурации
-var Conf, ConfErr = GetConfig()
-
-// Describe реализует интерфейс prometheus.Collector, описывая метрики, которые будет собирать данный коллектор
-// В канал ch передаются дескрипторы всех метрик, собранных этим коллектором
-func (DnsMetrics *DnsMetricsDesc) Describe(ch chan<- *prometheus.Desc) {
-	// Логирование описания метрик
-	slog.Debug("Describing DNS metrics.")
-	// Описание всех метрик DNS
-	ch <- DnsMetrics.AllServers
-	ch <- DnsMetrics.AvailabileServers
-	ch <- DnsMetrics.UnavailableServers
-	ch <- DnsMetrics.MaintenanceServers
-}
-
-// Collect реализует интерфейс prometheus.Collector, собирая метрики для мониторинга
-// В канал ch передаются сами метрики для Prometheus
-// Каждый раз, когда вызывается Collect, обновляются значения метрик
-func (DnsMetrics *DnsMetricsDesc) Collect(ch chan<- prometheus.Metric) {
-	var wg sync.WaitGroup
-	var resultCheckingAuth []AvailabilityGroup
-	chAvailGrp := make(chan []AvailabilityGroup, 50) // Канал для передачи результатов проверки доступности
-
-	wg.Add(1) // Увеличиваем счетчик горутин
-
-	// Логируем начало сбора метрик
-	slog.Debug("Starting collection of DNS metrics.")
-	go func() {
-		defer wg.Done() // Уменьшаем счетчик горутин по завершению
-		// Выполняем проверку доступности DNS серверов для каждой группы
-		slog.Debug("Checking DNS server availability.")
-		CheckAvailabilityDns(Conf.GroupsDNS, chAvailGrp)
-	}()
-
-	// Ожидаем завершения горутины
-	wg.Wait()
-	close(chAvailGrp)
-
-	seenMetrics := make(map[string]struct{}) // Карта для отслеживания уже отправленных метрик
-
-	// Обрабатываем результаты проверки доступности DNS серверов
-	for result := range chAvailGrp {
-		resultCheckingAuth = append(resultCheckingAuth, result...)
-	}
-	// Логируем количество групп, для которых будут отправлены метрики
-	slog.Debug("Sending metrics for groups.", slog.Int("num_groups", len(resultCheckingAuth)))
-	// Отправляем метрики для каждой группы
-	for _, item := range resultCheckingAuth {
-		// Создаем уникальный ключ для метрики на основе имени группы
-		key := item.GroupName
-
-		// Проверяем, была ли уже отправлена метрика для данной группы
-		if _, exists := seenMetrics[key]; exists {
-			continue // Пропускаем, если метрика уже была отправлена
-		}
-		// Отметим, что метрика была отправлена
-		seenMetrics[key] = struct{}{}
-
-		// Отправляем метрики в канал Prometheus
-		slog.Debug("Sending metric for group.", slog.String("group", item.GroupName))
-		ch <- prometheus.MustNewConstMetric(
-			DnsMetrics.AllServers, // Метрика общего количества серверов
-			prometheus.GaugeValue,
-			float64(item.AllServers), // Значение метрики
-			item.GroupName,           // Лейбл, идентифицирующий группу серверов
-		)
-		ch <- prometheus.MustNewConstMetric(
-			DnsMetrics.AvailabileServers, // Метрика доступных серверов
-			prometheus.GaugeValue,
-			float64(item.AvailabileServers),
-			item.GroupName,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			DnsMetrics.UnavailableServers, // Метрика недоступных серверов
-			prometheus.GaugeValue,
-			float64(item.UnavailableServers),
-			item.GroupName,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			DnsMetrics.MaintenanceServers, // Метрика серверов на обслуживании
-			prometheus.GaugeValue,
-			float64(item.MaintenanceServers),
-			item.GroupName,
-		)
-	}
-}
-
-// NewDnsMetrics создает новый объект DnsMetricsDesc с дескрипторами для метрик DNS серверов
-// Каждая метрика будет собираться с лейблом, соответствующим группе серверов
-func NewDnsMetrics() *DnsMetricsDesc {
-	return &DnsMetricsDesc{
-		AllServers: prometheus.NewDesc(
-			"all_servers", // Имя метрики для общего количества серверов
-			"Total number of DNS servers in the group", // Описание метрики
-			[]string{"group"},                          // Лейблы метрики: идентификатор группы серверов
-			prometheus.Labels{},                        // Нет предустановленных лейблов
-		),
-		AvailabileServers: prometheus.NewDesc(
-			"available_servers",                            // Имя метрики для доступных серверов
-			"Number of available DNS servers in the group", // Описание метрики
-			[]string{"group"},                              // Лейблы метрики: идентификатор группы серверов
-			prometheus.Labels{},                            // Нет предустановленных лейблов
-		),
-		UnavailableServers: prometheus.NewDesc(
-			"unavailable_servers",                            // Имя метрики для недоступных серверов
-			"Number of unavailable DNS servers in the group", // Описание метрики
-			[]string{"group"},                                // Лейблы метрики: идентификатор группы серверов
-			prometheus.Labels{},                              // Нет предустановленных лейблов
-		),
-		MaintenanceServers: prometheus.NewDesc(
-			"maintenance_servers", // Имя метрики для серверов на обслуживании
-			"Number of DNS servers in the group under maintenance", // Описание метрики
-			[]string{"group"},   // Лейблы метрики: идентификатор группы серверов
-			prometheus.Labels{}, // Нет предустановленных лейблов
-		),
-	}
-}
-
-// Run инициализирует сервер и запускает сбор метрик для Prometheus
-// В зависимости от конфигурации может быть включен mTLS для безопасного соединения
-func Run() error {
-	if ConfErr != nil {
-		// Логируем ошибку при чтении конфигурации
-		slog.Error("Error reading configuration", "error", ConfErr)
-		return ConfErr // Если ошибка при чтении конфигурации, возвращаем ошибку
-	}
-
-	// Инициализация логгера с заданными параметрами
-	initLogger(Conf.LogPath, Conf.LogLevel, Conf.LogToFile, Conf.LogToSyslog)
-
-	// Логируем успешное чтение конфигурации
-	slog.Info("Configuration loaded successfully.")
-
-	// Регистрируем коллектор метрик для Prometheus
-	reg := prometheus.NewPedanticRegistry()
-	workerDns := NewDnsMetrics()
-
-	// Настройки для mTLS (если включен)
-	mtlsSett := web.MtlsSettings{
-		Enabled:   Conf.MtlsExporter.Enabled,
-		Key:       Conf.MtlsExporter.Key,
-		Cert:      Conf.MtlsExporter.Cert,
-		AllowedCN: Conf.MtlsExporter.AllowedCN,
-	}
-
-	// Регистрируем наш коллектор метрик в Prometheus
-	reg.MustRegister(workerDns)
-
-	// Обрабатываем запросы к меткам с использованием mTLS или без него
-	promHandler := promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
-	http.Handle("/metrics", web.AuthenticationCN(promHandler, mtlsSett))
-
-	if Conf.MtlsExporter.Enabled {
-		// Запускаем сервер с поддержкой mTLS
-		slog.Info("Run server with mtls.")
-		RunServerWithTls(promHandler, Conf.MtlsExporter)
-	} else {
-		// Запускаем сервер без mTLS
-		slog.Info("Run server without mtls.")
-		RunServerWithousTls(promHandler)
-	}
-
-	return nil
-}
+package pdns
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DnsMetricsDesc содержит дескрипторы метрик для мониторинга состояния DNS серверов в группе
+type DnsMetricsDesc struct {
+	AllServers         *prometheus.Desc // Дескриптор метрики для общего количества DNS серверов в группе
+	AvailabileServers  *prometheus.Desc // Дескриптор метрики для доступных DNS серверов
+	UnavailableServers *prometheus.Desc // Дескриптор метрики для недоступных DNS серверов
+	MaintenanceServers *prometheus.Desc // Дескриптор метрики для серверов, находящихся на обслуживании
+	ServerQueryInfo    *prometheus.Desc // Дескриптор информационной метрики с типом и классом запроса каждого сервера
+	ServerFailure      *prometheus.Desc // Дескриптор метрики с причиной недоступности сервера
+
+	ServerUp           *prometheus.Desc // Дескриптор метрики доступности отдельного сервера
+	ServerResponseTime *prometheus.Desc // Дескриптор метрики времени отклика отдельного сервера
+	ServerLastRcode    *prometheus.Desc // Дескриптор метрики последнего кода ответа отдельного сервера
+	ServerMaintenance  *prometheus.Desc // Дескриптор метрики состояния обслуживания отдельного сервера
+	ServerAttempts     *prometheus.Desc // Дескриптор метрики количества попыток последнего запроса к серверу
+
+	GroupRtt  *prometheus.HistogramVec // Гистограмма времени отклика серверов по группам
+	ServerRtt *prometheus.HistogramVec // Гистограмма времени отклика отдельных серверов
+
+	ProbesTotal *prometheus.CounterVec // Счетчик запросов к серверам по группам и классификации результата
+	RcodesTotal *prometheus.CounterVec // Счетчик полученных ответов по группам и кодам ответа (rcode)
+
+	LastCheck  *prometheus.Desc // Дескриптор метрики времени последней проверки группы
+	GroupState *prometheus.Desc // Дескриптор метрики состояния группы по политике кворума
+
+	MaintenanceInfo   *prometheus.Desc // Дескриптор информационной метрики обслуживания, заданного через API
+	MaintenanceExpiry *prometheus.Desc // Дескриптор метрики времени окончания обслуживания, заданного через API
+
+	Snapshot    *Snapshot         // Последние результаты проверок, из которых строятся метрики
+	Maintenance *MaintenanceStore // Обслуживание, заданное через API
+}
+
+// Describe реализует интерфейс prometheus.Collector, описывая метрики, которые будет собирать данный коллектор
+// В канал ch передаются дескрипторы всех метрик, собранных этим коллектором
+func (DnsMetrics *DnsMetricsDesc) Describe(ch chan<- *prometheus.Desc) {
+	// Логирование описания метрик
+	slog.Debug("Describing DNS metrics.")
+	// Описание всех метрик DNS
+	ch <- DnsMetrics.AllServers
+	ch <- DnsMetrics.AvailabileServers
+	ch <- DnsMetrics.UnavailableServers
+	ch <- DnsMetrics.MaintenanceServers
+	ch <- DnsMetrics.ServerQueryInfo
+	ch <- DnsMetrics.ServerFailure
+	ch <- DnsMetrics.ServerUp
+	ch <- DnsMetrics.ServerResponseTime
+	ch <- DnsMetrics.ServerLastRcode
+	ch <- DnsMetrics.ServerMaintenance
+	ch <- DnsMetrics.ServerAttempts
+	ch <- DnsMetrics.LastCheck
+	ch <- DnsMetrics.GroupState
+	ch <- DnsMetrics.MaintenanceInfo
+	ch <- DnsMetrics.MaintenanceExpiry
+	DnsMetrics.GroupRtt.Describe(ch)
+	DnsMetrics.ServerRtt.Describe(ch)
+	DnsMetrics.ProbesTotal.Describe(ch)
+	DnsMetrics.RcodesTotal.Describe(ch)
+}
+
+// Collect реализует интерфейс prometheus.Collector, собирая метрики для мониторинга
+// В канал ch передаются сами метрики для Prometheus
+// Запросы к DNS серверам здесь не выполняются: метрики строятся из последних результатов,
+// сохраненных планировщиком проверок в Snapshot
+func (DnsMetrics *DnsMetricsDesc) Collect(ch chan<- prometheus.Metric) {
+	// Логируем начало сбора метрик
+	slog.Debug("Starting collection of DNS metrics.")
+	groups := DnsMetrics.Snapshot.Groups()
+
+	// Логируем количество групп, для которых будут отправлены метрики
+	slog.Debug("Sending metrics for groups.", slog.Int("num_groups", len(groups)))
+	// Отправляем метрики для каждой группы
+	for _, snap := range groups {
+		item := snap.Result
+
+		// Отправляем метрики в канал Prometheus
+		slog.Debug("Sending metric for group.", slog.String("group", item.GroupName))
+		ch <- prometheus.MustNewConstMetric(
+			DnsMetrics.AllServers, // Метрика общего количества серверов
+			prometheus.GaugeValue,
+			float64(item.AllServers), // Значение метрики
+			item.GroupName,           // Лейбл, идентифицирующий группу серверов
+		)
+		ch <- prometheus.MustNewConstMetric(
+			DnsMetrics.AvailabileServers, // Метрика доступных серверов
+			prometheus.GaugeValue,
+			float64(item.AvailabileServers),
+			item.GroupName,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			DnsMetrics.UnavailableServers, // Метрика недоступных серверов
+			prometheus.GaugeValue,
+			float64(item.UnavailableServers),
+			item.GroupName,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			DnsMetrics.MaintenanceServers, // Метрика серверов на обслуживании
+			prometheus.GaugeValue,
+			float64(item.MaintenanceServers),
+			item.GroupName,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			DnsMetrics.LastCheck, // Метрика времени последней проверки группы
+			prometheus.GaugeValue,
+			float64(snap.CheckedAt.UnixNano())/1e9,
+			item.GroupName,
+		)
+		// Состояние группы: 1 для текущего состояния, 0 для остальных
+		for _, state := range GroupStates {
+			var value float64
+			if item.State == state {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(DnsMetrics.GroupState, prometheus.GaugeValue, value, item.GroupName, string(state))
+		}
+
+		// Отправляем метрики по каждому серверу группы
+		for _, resp := range item.Responses {
+			DnsMetrics.collectServer(ch, item.GroupName, resp)
+		}
+	}
+
+	// Обслуживание, заданное через API, отдается сразу, не дожидаясь следующей проверки групп
+	for _, entry := range DnsMetrics.Maintenance.Entries(time.Now()) {
+		ch <- prometheus.MustNewConstMetric(DnsMetrics.MaintenanceInfo, prometheus.GaugeValue, 1, entry.Group, entry.ServerID, entry.Reason, entry.SetBy)
+		if entry.Expires != nil {
+			ch <- prometheus.MustNewConstMetric(DnsMetrics.MaintenanceExpiry, prometheus.GaugeValue, float64(entry.Expires.UnixNano())/1e9, entry.Group, entry.ServerID)
+		}
+	}
+	DnsMetrics.GroupRtt.Collect(ch)
+	DnsMetrics.ServerRtt.Collect(ch)
+	DnsMetrics.ProbesTotal.Collect(ch)
+	DnsMetrics.RcodesTotal.Collect(ch)
+}
+
+// Observe учитывает результаты очередной проверки группы в счетчиках и гистограммах.
+// Вызывается планировщиком один раз на каждую проверку, а не на каждый запрос Prometheus,
+// поэтому счетчики отражают реальное количество запросов к DNS серверам.
+func (DnsMetrics *DnsMetricsDesc) Observe(result AvailabilityGroup) {
+	for _, resp := range result.Responses {
+		if resp.Maintenance {
+			continue
+		}
+		// Учитываем классификацию и код ответа запроса в счетчиках группы
+		DnsMetrics.ProbesTotal.WithLabelValues(result.GroupName, string(resp.Status)).Inc()
+		if resp.Rcode >= 0 {
+			DnsMetrics.RcodesTotal.WithLabelValues(result.GroupName, rcodeName(resp.Rcode)).Inc()
+			// Время отклика учитываем в гистограммах только при полученном ответе,
+			// чтобы тайм-ауты и ошибки сети не искажали распределение задержек
+			DnsMetrics.GroupRtt.WithLabelValues(result.GroupName).Observe(resp.TimeToResponse.Seconds())
+			DnsMetrics.ServerRtt.WithLabelValues(serverLabelValues(result.GroupName, resp)...).Observe(resp.TimeToResponse.Seconds())
+		}
+	}
+}
+
+// Forget удаляет накопленные счетчики и гистограммы групп и серверов, которых больше нет в конфигурации conf.
+// Гистограммы сервера удаляются также, если у него изменилось значение любого лейбла (адрес, порт,
+// запрашиваемая запись или транспорт): иначе серия со старыми лейблами отдавалась бы бесконечно.
+// Вызывается при перезагрузке конфигурации.
+func (DnsMetrics *DnsMetricsDesc) Forget(old, conf *Config) {
+	// Значения лейблов серверов новой конфигурации по группе и идентификатору сервера
+	current := make(map[maintenanceKey][]string)
+	for _, group := range conf.GroupsDNS {
+		for _, target := range group.DNSServers {
+			current[maintenanceKey{Group: group.GroupName, ServerID: target.ServerID}] = targetLabelValues(group.GroupName, target)
+		}
+	}
+	for _, group := range old.GroupsDNS {
+		if !conf.hasGroup(group.GroupName) {
+			labels := prometheus.Labels{"group": group.GroupName}
+			DnsMetrics.GroupRtt.DeletePartialMatch(labels)
+			DnsMetrics.ServerRtt.DeletePartialMatch(labels)
+			DnsMetrics.ProbesTotal.DeletePartialMatch(labels)
+			DnsMetrics.RcodesTotal.DeletePartialMatch(labels)
+			continue
+		}
+		for _, target := range group.DNSServers {
+			labels, ok := current[maintenanceKey{Group: group.GroupName, ServerID: target.ServerID}]
+			if !ok || !slices.Equal(labels, targetLabelValues(group.GroupName, target)) {
+				DnsMetrics.ServerRtt.DeletePartialMatch(prometheus.Labels{"group": group.GroupName, "server_id": target.ServerID})
+			}
+		}
+	}
+}
+
+// serverLabelValues возвращает значения лейблов сервера: группа, идентификатор, адрес, порт,
+// запрашиваемая запись и транспорт
+func serverLabelValues(groupName string, resp DnsResponseData) []string {
+	return requestLabelValues(groupName, resp.Request)
+}
+
+// targetLabelValues возвращает значения лейблов сервера target из конфигурации
+func targetLabelValues(groupName string, target DNSTarget) []string {
+	return requestLabelValues(groupName, CreateDnsRequestData(target))
+}
+
+// requestLabelValues возвращает значения лейблов сервера по данным запроса к нему
+func requestLabelValues(groupName string, drd DnsRequestData) []string {
+	return []string{
+		groupName,
+		drd.ServerID,
+		drd.Address,
+		strconv.Itoa(int(drd.Port)),
+		drd.Fqdn,
+		drd.Transport,
+	}
+}
+
+// collectServer отправляет метрики отдельного сервера группы.
+// Для сервера на обслуживании отправляются только метрики состояния обслуживания и параметров запроса.
+func (DnsMetrics *DnsMetricsDesc) collectServer(ch chan<- prometheus.Metric, groupName string, resp DnsResponseData) {
+	labels := serverLabelValues(groupName, resp)
+
+	// Информационная метрика о типе и классе запроса сервера
+	ch <- prometheus.MustNewConstMetric(
+		DnsMetrics.ServerQueryInfo,
+		prometheus.GaugeValue,
+		1,
+		groupName,
+		resp.ServerID,
+		dns.TypeToString[resp.Request.QueryType],
+		dns.ClassToString[resp.Request.QueryClass],
+	)
+
+	var maintenance float64
+	if resp.Maintenance {
+		maintenance = 1
+	}
+	ch <- prometheus.MustNewConstMetric(DnsMetrics.ServerMaintenance, prometheus.GaugeValue, maintenance, labels...)
+	if resp.Maintenance {
+		return
+	}
+
+	var up float64
+	if resp.Availability {
+		up = 1
+	}
+	ch <- prometheus.MustNewConstMetric(DnsMetrics.ServerUp, prometheus.GaugeValue, up, labels...)
+	ch <- prometheus.MustNewConstMetric(DnsMetrics.ServerResponseTime, prometheus.GaugeValue, resp.TimeToResponse.Seconds(), labels...)
+	ch <- prometheus.MustNewConstMetric(DnsMetrics.ServerLastRcode, prometheus.GaugeValue, float64(resp.Rcode), labels...)
+	ch <- prometheus.MustNewConstMetric(DnsMetrics.ServerAttempts, prometheus.GaugeValue, float64(resp.Attempts), labels...)
+
+	// Для недоступных серверов отправляем причину недоступности
+	if !resp.Availability {
+		ch <- prometheus.MustNewConstMetric(
+			DnsMetrics.ServerFailure,
+			prometheus.GaugeValue,
+			1,
+			groupName,
+			resp.ServerID,
+			resp.FailureReason,
+		)
+	}
+}
+
+// NewDnsMetrics создает новый объект DnsMetricsDesc с дескрипторами для метрик DNS серверов
+// Метрики групп собираются с лейблом, соответствующим группе серверов,
+// метрики отдельных серверов - с лейблами группы, сервера, адреса, порта, запрашиваемой записи и транспорта.
+// rttBuckets задает границы корзин гистограмм времени отклика; если не задан, используется DefaultRttBuckets.
+// snapshot - хранилище последних результатов проверок, заполняемое планировщиком
+func NewDnsMetrics(rttBuckets []float64, snapshot *Snapshot, maint *MaintenanceStore) *DnsMetricsDesc {
+	serverLabels := []string{"group", "server_id", "ip", "port", "record", "transport"}
+	if len(rttBuckets) == 0 {
+		rttBuckets = DefaultRttBuckets
+	}
+	return &DnsMetricsDesc{
+		Snapshot:    snapshot,
+		Maintenance: maint,
+		AllServers: prometheus.NewDesc(
+			"all_servers", // Имя метрики для общего количества серверов
+			"Total number of DNS servers in the group", // Описание метрики
+			[]string{"group"},                          // Лейблы метрики: идентификатор группы серверов
+			prometheus.Labels{},                        // Нет предустановленных лейблов
+		),
+		AvailabileServers: prometheus.NewDesc(
+			"available_servers",                            // Имя метрики для доступных серверов
+			"Number of available DNS servers in the group", // Описание метрики
+			[]string{"group"},                              // Лейблы метрики: идентификатор группы серверов
+			prometheus.Labels{},                            // Нет предустановленных лейблов
+		),
+		UnavailableServers: prometheus.NewDesc(
+			"unavailable_servers",                            // Имя метрики для недоступных серверов
+			"Number of unavailable DNS servers in the group", // Описание метрики
+			[]string{"group"},                                // Лейблы метрики: идентификатор группы серверов
+			prometheus.Labels{},                              // Нет предустановленных лейблов
+		),
+		MaintenanceServers: prometheus.NewDesc(
+			"maintenance_servers", // Имя метрики для серверов на обслуживании
+			"Number of DNS servers in the group under maintenance", // Описание метрики
+			[]string{"group"},   // Лейблы метрики: идентификатор группы серверов
+			prometheus.Labels{}, // Нет предустановленных лейблов
+		),
+		ServerQueryInfo: prometheus.NewDesc(
+			"server_query_info", // Имя информационной метрики с параметрами запроса сервера
+			"Query type and class used to probe the DNS server (always 1)", // Описание метрики
+			[]string{"group", "server_id", "type", "class"},                // Лейблы метрики: группа, сервер, тип и класс запроса
+			prometheus.Labels{}, // Нет предустановленных лейблов
+		),
+		ServerFailure: prometheus.NewDesc(
+			"server_failure_reason", // Имя метрики с причиной недоступности сервера
+			"Reason why the DNS server is considered unavailable (always 1, present only for failed servers)", // Описание метрики
+			[]string{"group", "server_id", "reason"},                                                          // Лейблы метрики: группа, сервер и причина недоступности
+			prometheus.Labels{},                                                                               // Нет предустановленных лейблов
+		),
+		ServerUp: prometheus.NewDesc(
+			"server_up", // Имя метрики доступности сервера
+			"Whether the DNS server passed the last probe (1 - available, 0 - unavailable)", // Описание метрики
+			serverLabels,        // Лейблы метрики: группа, сервер, адрес, порт, запись и транспорт
+			prometheus.Labels{}, // Нет предустановленных лейблов
+		),
+		ServerResponseTime: prometheus.NewDesc(
+			"server_response_time_seconds",                                 // Имя метрики времени отклика сервера
+			"Response time of the last probe of the DNS server in seconds", // Описание метрики
+			serverLabels,        // Лейблы метрики: группа, сервер, адрес, порт, запись и транспорт
+			prometheus.Labels{}, // Нет предустановленных лейблов
+		),
+		ServerLastRcode: prometheus.NewDesc(
+			"server_last_rcode", // Имя метрики последнего кода ответа сервера
+			"Response code of the last probe of the DNS server (-1 if no response was received)", // Описание метрики
+			serverLabels,        // Лейблы метрики: группа, сервер, адрес, порт, запись и транспорт
+			prometheus.Labels{}, // Нет предустановленных лейблов
+		),
+		ServerAttempts: prometheus.NewDesc(
+			"server_probe_attempts", // Имя метрики количества попыток запроса
+			"Number of attempts (including retries) used by the last probe of the DNS server", // Описание метрики
+			serverLabels,        // Лейблы метрики: группа, сервер, адрес, порт, запись и транспорт
+			prometheus.Labels{}, // Нет предустановленных лейблов
+		),
+		ServerMaintenance: prometheus.NewDesc(
+			"server_maintenance", // Имя метрики состояния обслуживания сервера
+			"Whether the DNS server is under maintenance (1 - under maintenance, 0 - in service)", // Описание метрики
+			serverLabels,        // Лейблы метрики: группа, сервер, адрес, порт, запись и транспорт
+			prometheus.Labels{}, // Нет предустановленных лейблов
+		),
+		LastCheck: prometheus.NewDesc(
+			"last_check_timestamp_seconds", // Имя метрики времени последней проверки группы
+			"Unix timestamp of the last completed check of the group; the age of the results is time() minus this value", // Описание метрики
+			[]string{"group"},   // Лейблы метрики: идентификатор группы серверов
+			prometheus.Labels{}, // Нет предустановленных лейблов
+		),
+		GroupState: prometheus.NewDesc(
+			"group_state", // Имя метрики состояния группы
+			"State of the group according to its health policy (1 for the current state: healthy, degraded, down or maintenance)", // Описание метрики
+			[]string{"group", "state"}, // Лейблы метрики: группа серверов и состояние
+			prometheus.Labels{},        // Нет предустановленных лейблов
+		),
+		MaintenanceInfo: prometheus.NewDesc(
+			"maintenance_info", // Имя информационной метрики обслуживания
+			"Maintenance set through the API (server_id is empty for a whole group)", // Описание метрики
+			[]string{"group", "server_id", "reason", "set_by"},                       // Лейблы метрики: группа, сервер, причина и кто перевел на обслуживание
+			prometheus.Labels{}, // Нет предустановленных лейблов
+		),
+		MaintenanceExpiry: prometheus.NewDesc(
+			"maintenance_expiry_timestamp_seconds",                  // Имя метрики времени окончания обслуживания
+			"Time when the maintenance set through the API expires", // Описание метрики
+			[]string{"group", "server_id"},                          // Лейблы метрики: группа и сервер
+			prometheus.Labels{},                                     // Нет предустановленных лейблов
+		),
+		GroupRtt: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "response_time_seconds", // Имя гистограммы времени отклика серверов группы
+				Help:    "Histogram of DNS response times of the servers in the group in seconds",
+				Buckets: rttBuckets,
+			},
+			[]string{"group"}, // Лейблы метрики: идентификатор группы серверов
+		),
+		ServerRtt: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "server_response_time_histogram_seconds", // Имя гистограммы времени отклика сервера
+				Help:    "Histogram of DNS response times of the server in seconds",
+				Buckets: rttBuckets,
+			},
+			serverLabels, // Лейблы метрики: группа, сервер, адрес, порт, запись и транспорт
+		),
+		ProbesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "probes_total", // Имя метрики для количества запросов к серверам
+				Help: "Total number of DNS probes by group and result (ok, bad_rcode, timeout, network_error, truncated, assertion_failed)",
+			},
+			[]string{"group", "status"}, // Лейблы метрики: группа серверов и классификация результата
+		),
+		RcodesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rcode_responses_total", // Имя метрики для количества ответов по кодам
+				Help: "Total number of DNS responses by group and response code",
+			},
+			[]string{"group", "rcode"}, // Лейблы метрики: группа серверов и код ответа
+		),
+	}
+}
+
+// Run загружает конфигурацию из source (файл и переопределения параметров), инициализирует сервер
+// и запускает сбор метрик для Prometheus.
+// На каждом адресе из секции web запускается отдельный сервер; для каждого адреса
+// в зависимости от конфигурации может быть включен mTLS для безопасного соединения.
+// Действующая после перезагрузок конфигурация хранится в Reloader.
+// Работает до сигнала SIGTERM или SIGINT либо до ошибки HTTP сервера, после чего корректно останавливается
+// (см. shutdown) и закрывает выходы логов. При корректной остановке по сигналу возвращает nil, иначе ошибку,
+// оборачивающую ErrConfig, ErrStartup, ErrServer или ErrShutdownTimeout.
+// Если служба запущена systemd с Type=notify, сообщает о готовности, остановке и (при WatchdogSec) о работе.
+func Run(source ConfigSource) error {
+	Conf, err := LoadConfig(source)
+	if err != nil {
+		// Логируем ошибку при чтении конфигурации
+		slog.Error("Error reading configuration", "error", err)
+		return fmt.Errorf("%w: %w", ErrConfig, err) // Если ошибка при чтении конфигурации, возвращаем ошибку
+	}
+
+	// Инициализация логгера с заданными параметрами
+	logging, err := initLogger(Conf)
+	if err != nil {
+		slog.Error("Error opening log outputs", slog.String("error", err.Error()))
+		return fmt.Errorf("%w: %w", ErrStartup, err)
+	}
+	defer logging.Close()
+
+	// Логируем успешное чтение конфигурации
+	slog.Info("Configuration loaded successfully.")
+
+	// Сигналы остановки принимаются с самого начала, чтобы остановка во время запуска тоже была корректной
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(stop)
+
+	// Регистрируем коллектор метрик для Prometheus
+	reg := prometheus.NewPedanticRegistry()
+	snapshot := NewSnapshot()
+	// Загружаем обслуживание, заданное через API до перезапуска
+	maint, err := NewMaintenanceStore(Conf.StateFile)
+	if err != nil {
+		slog.Error("Error loading maintenance state", slog.String("error", err.Error()))
+		return fmt.Errorf("%w: %w", ErrStartup, err)
+	}
+	retainMaintenance(maint, Conf)
+	workerDns := NewDnsMetrics(Conf.RttBuckets, snapshot, maint)
+
+	// ctx отменяется при остановке и завершает фоновые горутины (отслеживание файла, watchdog).
+	// Проверки групп запускаются без него: при остановке начатые проверки завершаются в shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Запускаем фоновые проверки групп; метрики отдаются из последних сохраненных результатов.
+	// Планировщик заменяется при перезагрузке конфигурации и забирается при остановке, поэтому защищен блокировкой.
+	var schedulerMu sync.Mutex
+	scheduler := NewScheduler(Conf, snapshot, maint, workerDns.Observe)
+	scheduler.Start(context.Background())
+
+	// При перезагрузке конфигурации проверки перезапускаются с новыми группами, а результаты
+	// оставшихся групп продолжают отдаваться до их следующей проверки
+	reloader := NewReloader(source, Conf, func(old, conf *Config) {
+		schedulerMu.Lock()
+		defer schedulerMu.Unlock()
+		if scheduler == nil {
+			// Демон останавливается, проверки не перезапускаются
+			return
+		}
+		scheduler.Stop()
+		snapshot.Retain(conf)
+		retainMaintenance(maint, conf)
+		workerDns.Forget(old, conf)
+		scheduler = NewScheduler(conf, snapshot, maint, workerDns.Observe)
+		scheduler.Start(context.Background())
+	})
+	go reloader.Watch(ctx)
+
+	// Перезагрузка конфигурации по сигналу SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloader.Reload("sighup")
+		}
+	}()
+
+	// Включение и выключение уровня DEBUG по сигналу SIGUSR1
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			logging.ToggleDebug()
+		}
+	}()
+
+	// Регистрируем наш коллектор метрик в Prometheus
+	reg.MustRegister(workerDns)
+	reg.MustRegister(reloader)
+
+	// Обработчики HTTP сервера, общие для всех адресов
+	mux := http.NewServeMux()
+	mux.Handle(Conf.metricsPath(), promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.Handle(ProbePath, ProbeHandler(reloader.Config))
+	RegisterAPI(mux, snapshot)
+	RegisterMaintenanceAPI(mux, reloader.Config, maint)
+	RegisterReloadAPI(mux, reloader)
+	RegisterLogLevelAPI(mux, logging)
+
+	// Ошибка запуска останавливает уже начатые проверки
+	startupFailed := func(err error) error {
+		schedulerMu.Lock()
+		scheduler.Stop()
+		scheduler = nil
+		schedulerMu.Unlock()
+		return fmt.Errorf("%w: %w", ErrStartup, err)
+	}
+
+	// Аутентификация запросов настраивается для каждого адреса по группам обработчиков
+	listeners := Conf.listeners()
+	handlers := make([]http.Handler, len(listeners))
+	for i, listener := range listeners {
+		handler, err := Conf.authHandler(mux, listener)
+		if err != nil {
+			slog.Error("Failed to configure authentication", slog.String("addr", listener.Address), slog.String("error", err.Error()))
+			return startupFailed(err)
+		}
+		handlers[i] = handler
+	}
+
+	// Сертификаты адресов с mTLS читаются до открытия адресов и затем перечитываются при изменении файлов
+	certs := make([]*CertificateStore, len(listeners))
+	var stores []*CertificateStore
+	for i, listener := range listeners {
+		if listener.Mtls == nil || !listener.Mtls.Enabled {
+			continue
+		}
+		store, err := NewCertificateStore(listener.Address, *listener.Mtls)
+		if err != nil {
+			slog.Error("Failed to load TLS certificates", slog.String("addr", listener.Address), slog.String("error", err.Error()))
+			return startupFailed(err)
+		}
+		certs[i] = store
+		stores = append(stores, store)
+		go store.Watch(ctx)
+	}
+	reg.MustRegister(NewCertificateMetrics(stores))
+	if len(stores) > 0 {
+		// SIGHUP перечитывает и сертификаты, не дожидаясь следующей проверки файлов
+		certHup := make(chan os.Signal, 1)
+		signal.Notify(certHup, syscall.SIGHUP)
+		go func() {
+			for range certHup {
+				for _, store := range stores {
+					store.Reload("sighup")
+				}
+			}
+		}()
+	}
+
+	// Сначала открываем все адреса, чтобы ошибка (например, занятый порт) обнаружилась до запуска серверов
+	opened := make([]net.Listener, 0, len(listeners))
+	for _, listener := range listeners {
+		ln, err := Listen(listener.Address)
+		if err != nil {
+			slog.Error("Failed to listen", slog.String("addr", listener.Address), slog.String("error", err.Error()))
+			for _, ln := range opened {
+				ln.Close()
+			}
+			return startupFailed(err)
+		}
+		opened = append(opened, ln)
+	}
+
+	// Запускаем сервер на каждом адресе; работа завершается при ошибке любого из серверов
+	chErr := make(chan error, len(listeners))
+	servers := make([]*http.Server, len(listeners))
+	for i, listener := range listeners {
+		servers[i] = &http.Server{Handler: handlers[i]}
+		go func(server *http.Server, ln net.Listener, listener ListenerConfig, certs *CertificateStore) {
+			if certs != nil {
+				// Запускаем сервер с поддержкой mTLS
+				slog.Info("Run server with mtls.", slog.String("addr", listener.Address))
+				chErr <- RunServerWithTls(server, ln, certs)
+			} else {
+				// Запускаем сервер без mTLS
+				slog.Info("Run server without mtls.", slog.String("addr", listener.Address))
+				chErr <- RunServerWithousTls(server, ln)
+			}
+		}(servers[i], opened[i], listener, certs[i])
+	}
+
+	// Сообщаем systemd о готовности и, если включен watchdog, периодически подтверждаем, что проверки выполняются
+	sdNotify(sdReady)
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go runSdWatchdog(ctx, interval, func() bool {
+			schedulerMu.Lock()
+			defer schedulerMu.Unlock()
+			return scheduler == nil || scheduler.Alive(time.Now())
+		})
+	}
+
+	var runErr error
+	select {
+	case sig := <-stop:
+		slog.Info("Shutdown signal received", slog.String("signal", sig.String()), slog.Duration("timeout", reloader.Config().shutdownTimeout()))
+	case err := <-chErr:
+		// Один из серверов остановился с ошибкой; остальные останавливаются так же, как по сигналу
+		runErr = fmt.Errorf("%w: %w", ErrServer, err)
+	}
+
+	// Повторный сигнал завершает процесс сразу, не дожидаясь корректной остановки
+	signal.Stop(stop)
+	sdNotify(sdStopping)
+	cancel()
+	schedulerMu.Lock()
+	current := scheduler
+	scheduler = nil
+	schedulerMu.Unlock()
+	if err := shutdown(reloader.Config().shutdownTimeout(), servers, current); err != nil && runErr == nil {
+		runErr = err
+	}
+	return runErr
+}
@@ -0,0 +1,86 @@
+package pdns
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestDnsMetricsForget проверяет, что после перезагрузки конфигурации остаются гистограммы только тех серверов,
+// у которых не изменилась группа и значения лейблов
+func TestDnsMetricsForget(t *testing.T) {
+	server := func(id, ip, record, transport string) DNSTarget {
+		return DNSTarget{ServerID: id, IP: ip, RequestedRecord: record, Transport: transport}
+	}
+	old := &Config{GroupsDNS: []GroupDNS{
+		{GroupName: "g", DNSServers: []DNSTarget{
+			server("same", "192.0.2.1", "example.com", ""),
+			server("ip", "192.0.2.2", "example.com", ""),
+			server("record", "192.0.2.3", "example.com", ""),
+			server("transport", "192.0.2.4", "example.com", ""),
+			server("port", "192.0.2.5", "example.com", ""),
+			server("moved", "192.0.2.6", "example.com", ""),
+			server("removed", "192.0.2.7", "example.com", ""),
+		}},
+		{GroupName: "removed", DNSServers: []DNSTarget{server("other", "192.0.2.8", "example.com", "")}},
+	}}
+	port := server("port", "192.0.2.5", "example.com", "")
+	port.DNSPort = 5353
+	conf := &Config{GroupsDNS: []GroupDNS{
+		{GroupName: "g", DNSServers: []DNSTarget{
+			server("same", "192.0.2.1", "example.com", ""),
+			server("ip", "192.0.2.20", "example.com", ""),
+			server("record", "192.0.2.3", "example.org", ""),
+			server("transport", "192.0.2.4", "example.com", TransportTCP),
+			port,
+		}},
+		{GroupName: "h", DNSServers: []DNSTarget{server("moved", "192.0.2.6", "example.com", "")}},
+	}}
+
+	metrics := NewDnsMetrics(nil, NewSnapshot(), nil)
+	for _, group := range old.GroupsDNS {
+		result := AvailabilityGroup{GroupName: group.GroupName}
+		for _, target := range group.DNSServers {
+			result.Responses = append(result.Responses, DnsResponseData{
+				ServerID:       target.ServerID,
+				Request:        CreateDnsRequestData(target),
+				Status:         ProbeStatusOK,
+				TimeToResponse: time.Millisecond,
+			})
+		}
+		metrics.Observe(result)
+	}
+	metrics.Forget(old, conf)
+
+	if got := seriesLabels(t, metrics.ServerRtt, "server_id"); !slices.Equal(got, []string{"same"}) {
+		t.Errorf("server histograms after reload: %v, want [same]", got)
+	}
+	if got := seriesLabels(t, metrics.ProbesTotal, "group"); !slices.Equal(got, []string{"g"}) {
+		t.Errorf("probe counters after reload: %v, want [g]", got)
+	}
+}
+
+// seriesLabels возвращает отсортированные значения лейбла label всех серий коллектора collector
+func seriesLabels(t *testing.T, collector prometheus.Collector, label string) []string {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collector)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if pair.GetName() == label {
+					values = append(values, pair.GetValue())
+				}
+			}
+		}
+	}
+	slices.Sort(values)
+	return values
+}
@@ -0,0 +1,234 @@
+package pdns
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GroupSnapshot - последний результат проверки группы вместе со временем его получения
+type GroupSnapshot struct {
+	Result    AvailabilityGroup        // Результат проверки группы
+	CheckedAt time.Time                // Время завершения проверки
+	History   map[string]ServerHistory // Время последнего успеха и последняя ошибка по идентификатору сервера
+}
+
+// ServerHistory - последние успешная и неуспешная проверки сервера, в том числе из предыдущих проверок группы
+type ServerHistory struct {
+	LastSuccess     time.Time // Время последней успешной проверки (нулевое, если успешных проверок не было)
+	LastError       time.Time // Время последней неуспешной проверки (нулевое, если ошибок не было)
+	LastErrorReason string    // Причина последней ошибки
+	LastErrorDetail string    // Подробности последней ошибки
+}
+
+// Snapshot - потокобезопасное хранилище последних результатов проверок всех групп.
+// Планировщик записывает в него результаты, а обработчики HTTP (метрики) только читают.
+type Snapshot struct {
+	mu     sync.RWMutex
+	groups map[string]GroupSnapshot // Результаты по имени группы
+}
+
+// NewSnapshot создает пустое хранилище результатов
+func NewSnapshot() *Snapshot {
+	return &Snapshot{groups: make(map[string]GroupSnapshot)}
+}
+
+// Store сохраняет результат проверки группы, заменяя предыдущий.
+// История серверов дополняется результатами новой проверки; для каждой проверки создается новая
+// карта истории, поэтому ранее выданные копии не изменяются.
+func (s *Snapshot) Store(result AvailabilityGroup, checkedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.groups[result.GroupName].History
+	history := make(map[string]ServerHistory, len(result.Responses))
+	for _, resp := range result.Responses {
+		entry := previous[resp.ServerID]
+		switch {
+		case resp.Maintenance:
+			// Сервер на обслуживании не проверялся, история не меняется
+		case resp.Availability:
+			entry.LastSuccess = checkedAt
+		default:
+			entry.LastError, entry.LastErrorReason, entry.LastErrorDetail = checkedAt, resp.FailureReason, resp.FailureDetail
+		}
+		history[resp.ServerID] = entry
+	}
+	s.groups[result.GroupName] = GroupSnapshot{Result: result, CheckedAt: checkedAt, History: history}
+}
+
+// Group возвращает последний результат проверки группы name
+func (s *Snapshot) Group(name string) (GroupSnapshot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	group, ok := s.groups[name]
+	return group, ok
+}
+
+// Groups возвращает копию последних результатов всех групп, отсортированную по имени группы
+func (s *Snapshot) Groups() []GroupSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	groups := make([]GroupSnapshot, 0, len(s.groups))
+	for _, group := range s.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Result.GroupName < groups[j].Result.GroupName })
+	return groups
+}
+
+// Retain удаляет результаты групп, которых нет в конфигурации conf, и возвращает имена удаленных групп.
+// Вызывается при перезагрузке конфигурации, чтобы не отдавать метрики удаленных групп.
+func (s *Snapshot) Retain(conf *Config) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var removed []string
+	for name := range s.groups {
+		if !conf.hasGroup(name) {
+			delete(s.groups, name)
+			removed = append(removed, name)
+		}
+	}
+	return removed
+}
+
+// Scheduler - планировщик фоновых проверок групп DNS серверов.
+// Каждая группа проверяется в отдельной горутине со своим интервалом и случайной задержкой (jitter),
+// чтобы проверки разных групп не совпадали по времени. Результаты сохраняются в Snapshot.
+type Scheduler struct {
+	conf     *Config                 // Конфигурация с группами и интервалами проверок
+	snapshot *Snapshot               // Хранилище последних результатов
+	onResult func(AvailabilityGroup) // Вызывается после каждой проверки группы (например, для обновления счетчиков)
+	maint    *MaintenanceStore       // Обслуживание, заданное через API (может быть nil)
+	pool     *ProbePool              // Общий пул DNS запросов всех групп
+	wg       sync.WaitGroup          // Ожидание завершения горутин проверок
+	stop     chan struct{}           // Закрывается, чтобы не начинать новых проверок
+	halt     sync.Once               // Защита от повторного закрытия stop
+	cancel   context.CancelFunc      // Прерывание начатых проверок
+	started  time.Time               // Время запуска проверок
+}
+
+// stalledGrace - запас времени сверх двух интервалов проверки группы, после которого ее проверки считаются зависшими
+const stalledGrace = time.Minute
+
+// NewScheduler создает планировщик проверок групп из конфигурации conf.
+// Серверы, переведенные на обслуживание через maint, не проверяются. maint и onResult могут быть nil.
+func NewScheduler(conf *Config, snapshot *Snapshot, maint *MaintenanceStore, onResult func(AvailabilityGroup)) *Scheduler {
+	return &Scheduler{
+		conf:     conf,
+		snapshot: snapshot,
+		maint:    maint,
+		onResult: onResult,
+	}
+}
+
+// Start запускает фоновые проверки всех групп. Проверки выполняются до отмены ctx или вызова Stop.
+// Количество одновременных DNS запросов ограничено параметром probeWorkers.
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.stop = make(chan struct{})
+	s.started = time.Now()
+	s.pool = NewProbePool(s.conf.ProbeWorkers)
+	for _, group := range s.conf.GroupsDNS {
+		interval, jitter := group.checkSchedule(s.conf)
+		slog.Info("Scheduling DNS group checks", slog.String("groupName", group.GroupName), slog.Duration("interval", interval), slog.Duration("jitter", jitter))
+		s.wg.Add(1)
+		go s.runGroup(ctx, group, interval, jitter)
+	}
+}
+
+// Stop останавливает проверки и ожидает завершения уже начатых.
+// Результаты прерванных проверок не сохраняются.
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wait()
+}
+
+// Shutdown прекращает планирование новых проверок и ожидает завершения уже начатых, чтобы их результаты
+// были сохранены. Если ctx отменяется раньше, начатые проверки прерываются и возвращается ошибка ctx
+// без ожидания их завершения (запрос, уже ожидающий ответа, завершается только по своему тайм-ауту).
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.halt.Do(func() { close(s.stop) })
+	done := make(chan struct{})
+	go func() {
+		s.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		return ctx.Err()
+	}
+}
+
+// Alive проверяет, что проверки групп выполняются: каждая группа проверялась (или планировщик был запущен)
+// не раньше чем два интервала проверки с jitter и stalledGrace назад. Используется для watchdog systemd.
+func (s *Scheduler) Alive(now time.Time) bool {
+	for _, group := range s.conf.GroupsDNS {
+		interval, jitter := group.checkSchedule(s.conf)
+		last := s.started
+		if snap, ok := s.snapshot.Group(group.GroupName); ok && snap.CheckedAt.After(last) {
+			last = snap.CheckedAt
+		}
+		if now.Sub(last) > 2*(interval+jitter)+stalledGrace {
+			return false
+		}
+	}
+	return true
+}
+
+// wait ожидает завершения горутин проверок и останавливает пул запросов
+func (s *Scheduler) wait() {
+	s.wg.Wait()
+	if s.pool != nil {
+		s.pool.Close()
+	}
+}
+
+// runGroup выполняет периодические проверки одной группы.
+// Первая проверка выполняется сразу после случайной задержки, последующие - через interval плюс случайная задержка.
+func (s *Scheduler) runGroup(ctx context.Context, group GroupDNS, interval, jitter time.Duration) {
+	defer s.wg.Done()
+	timer := time.NewTimer(randomJitter(jitter))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Debug("Stopping DNS group checks", slog.String("groupName", group.GroupName))
+			return
+		case <-s.stop:
+			slog.Debug("Stopping DNS group checks", slog.String("groupName", group.GroupName))
+			return
+		case <-timer.C:
+		}
+
+		result := processingDnsGroup(ctx, s.maint.Apply(group, time.Now()), s.pool)
+		if ctx.Err() != nil {
+			// Проверка прервана остановкой планировщика, ее результат неполон
+			return
+		}
+		s.snapshot.Store(result, time.Now())
+		if s.onResult != nil {
+			s.onResult(result)
+		}
+		timer.Reset(interval + randomJitter(jitter))
+	}
+}
+
+// randomJitter возвращает случайную задержку в диапазоне [0, jitter)
+func randomJitter(jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(jitter)))
+}
@@ -0,0 +1,72 @@
+package pdns
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Состояния, передаваемые systemd через sd_notify
+const (
+	sdReady    = "READY=1"    // Служба запущена и обслуживает запросы
+	sdStopping = "STOPPING=1" // Служба начала остановку
+	sdWatchdog = "WATCHDOG=1" // Служба работает (сброс таймера WatchdogSec)
+)
+
+// sdNotify передает состояние state менеджеру служб systemd через сокет из NOTIFY_SOCKET.
+// Если NOTIFY_SOCKET не задан (служба запущена не systemd или без Type=notify), ничего не делает.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// Сокет в абстрактном пространстве имен задается с префиксом "@"
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("Failed to notify systemd", slog.String("state", state), slog.String("error", err.Error()))
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("Failed to notify systemd", slog.String("state", state), slog.String("error", err.Error()))
+	}
+}
+
+// sdWatchdogInterval возвращает интервал отправки WATCHDOG=1 - половину WatchdogSec службы из WATCHDOG_USEC.
+// Возвращает 0, если watchdog не включен или предназначен другому процессу (WATCHDOG_PID).
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// runSdWatchdog отправляет systemd WATCHDOG=1 каждые interval, пока alive возвращает true, и до отмены ctx.
+// Если alive возвращает false (например, проверки групп давно не выполнялись), сигнал не отправляется
+// и systemd перезапускает службу по истечении WatchdogSec.
+func runSdWatchdog(ctx context.Context, interval time.Duration, alive func() bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if alive() {
+				sdNotify(sdWatchdog)
+			} else {
+				slog.Warn("Skipping systemd watchdog notification, DNS group checks are stalled")
+			}
+		}
+	}
+}
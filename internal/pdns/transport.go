@@ -0,0 +1,272 @@
+package pdns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// Транспорты, по которым может выполняться DNS запрос
+const (
+	TransportUDP = "udp" // Обычный DNS по UDP
+	TransportTCP = "tcp" // DNS по TCP
+	TransportDoT = "dot" // DNS over TLS (RFC 7858)
+	TransportDoH = "doh" // DNS over HTTPS (RFC 8484)
+	TransportDoQ = "doq" // DNS over QUIC (RFC 9250)
+)
+
+// defaultTransportPorts - порты по умолчанию для каждого транспорта
+var defaultTransportPorts = map[string]int{
+	TransportUDP: 53,
+	TransportTCP: 53,
+	TransportDoT: 853,
+	TransportDoH: 443,
+	TransportDoQ: 853,
+}
+
+// DnsExchanger - клиент, выполняющий обмен DNS сообщениями с сервером по одному из транспортов.
+// Интерфейс совпадает с методом ExchangeContext у *dns.Client, поэтому UDP, TCP и DoT используют его напрямую.
+type DnsExchanger interface {
+	ExchangeContext(ctx context.Context, msg *dns.Msg, address string) (*dns.Msg, time.Duration, error)
+}
+
+// clientTimeouts - тайм-ауты DNS клиента
+type clientTimeouts struct {
+	Dial  time.Duration // Тайм-аут соединения с сервером
+	Read  time.Duration // Тайм-аут чтения ответа
+	Write time.Duration // Тайм-аут отправки запроса
+}
+
+// total возвращает общий тайм-аут запроса, используемый там, где нет раздельных тайм-аутов (DoH, DoQ)
+func (t clientTimeouts) total() time.Duration {
+	return t.Dial + t.Write + t.Read
+}
+
+// parseTransport проверяет имя транспорта; пустая строка означает UDP
+func parseTransport(name string) (string, error) {
+	if name == "" {
+		return TransportUDP, nil
+	}
+	transport := strings.ToLower(name)
+	if _, ok := defaultTransportPorts[transport]; !ok {
+		return "", fmt.Errorf("unknown transport %q (expected udp, tcp, dot, doh or doq)", name)
+	}
+	return transport, nil
+}
+
+// parseDohMethod проверяет HTTP метод для DoH; пустая строка означает POST
+func parseDohMethod(name string) (string, error) {
+	switch strings.ToUpper(name) {
+	case "", http.MethodPost:
+		return http.MethodPost, nil
+	case http.MethodGet:
+		return http.MethodGet, nil
+	}
+	return "", fmt.Errorf("unknown dohMethod %q (expected GET or POST)", name)
+}
+
+// serverAddress возвращает адрес сервера в формате host:port (с квадратными скобками для IPv6)
+func (drd DnsRequestData) serverAddress() string {
+	if drd.Transport == TransportDoH {
+		return drd.DohURL
+	}
+	return net.JoinHostPort(drd.Address, strconv.Itoa(int(drd.Port)))
+}
+
+// tlsConfig создает TLS конфигурацию для DoT, DoH и DoQ: имя сервера для проверки сертификата
+// (по умолчанию адрес сервера) и пул доверенных CA (по умолчанию системный).
+func (drd DnsRequestData) tlsConfig(nextProtos ...string) (*tls.Config, error) {
+	serverName := drd.TLSServerName
+	if serverName == "" {
+		serverName = drd.Address
+	}
+	config := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+	}
+	if drd.TLSCAFile != "" {
+		caCert, err := os.ReadFile(drd.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read tlsCAFile: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("tlsCAFile %q contains no PEM certificates", drd.TLSCAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// newClassicClient создает клиент miekg/dns для UDP, TCP и DoT
+func newClassicClient(network string, timeouts clientTimeouts, tlsConfig *tls.Config) *dns.Client {
+	return &dns.Client{
+		Net:          network,
+		Dialer:       &net.Dialer{Timeout: timeouts.Dial},
+		ReadTimeout:  timeouts.Read,
+		WriteTimeout: timeouts.Write,
+		TLSConfig:    tlsConfig,
+	}
+}
+
+// dohClient - клиент DNS over HTTPS (RFC 8484)
+type dohClient struct {
+	client *http.Client // HTTP клиент с TLS конфигурацией и тайм-аутом
+	method string       // HTTP метод: GET или POST
+}
+
+// newDohClient создает клиент DoH. Перенаправления не выполняются: ответ 3xx считается ошибкой,
+// чтобы запрос не уходил на адрес, которого нет в конфигурации.
+func newDohClient(method string, timeouts clientTimeouts, tlsConfig *tls.Config) *dohClient {
+	return &dohClient{
+		client: &http.Client{
+			Timeout: timeouts.total(),
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+			Transport: &http.Transport{
+				DialContext:         (&net.Dialer{Timeout: timeouts.Dial}).DialContext,
+				TLSClientConfig:     tlsConfig,
+				TLSHandshakeTimeout: timeouts.Dial,
+				ForceAttemptHTTP2:   true,
+			},
+		},
+		method: method,
+	}
+}
+
+// ExchangeContext отправляет DNS запрос на URL DoH сервера и возвращает ответ и время отклика.
+// Для метода GET параметр dns добавляется к параметрам запроса, уже заданным в URL.
+func (c *dohClient) ExchangeContext(ctx context.Context, msg *dns.Msg, address string) (*dns.Msg, time.Duration, error) {
+	defer c.client.CloseIdleConnections()
+	// RFC 8484 рекомендует нулевой ID для лучшего кэширования ответов
+	query := msg.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var req *http.Request
+	if c.method == http.MethodGet {
+		var endpoint *url.URL
+		if endpoint, err = url.Parse(address); err != nil {
+			return nil, 0, err
+		}
+		params := endpoint.Query()
+		params.Set("dns", base64.RawURLEncoding.EncodeToString(packed))
+		endpoint.RawQuery = params.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, address, bytes.NewReader(packed))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/dns-message")
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/dns-message")
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("doh: unexpected HTTP status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	rtt := time.Since(start)
+
+	answer := new(dns.Msg)
+	if err := answer.Unpack(body); err != nil {
+		return nil, 0, fmt.Errorf("doh: %w", err)
+	}
+	answer.Id = msg.Id
+	return answer, rtt, nil
+}
+
+// doqClient - клиент DNS over QUIC (RFC 9250)
+type doqClient struct {
+	tlsConfig *tls.Config    // TLS конфигурация с ALPN "doq"
+	timeouts  clientTimeouts // Тайм-ауты запроса
+}
+
+// ExchangeContext открывает QUIC соединение, отправляет запрос в отдельном потоке и читает ответ
+func (c *doqClient) ExchangeContext(ctx context.Context, msg *dns.Msg, address string) (*dns.Msg, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeouts.total())
+	defer cancel()
+
+	// RFC 9250: ID сообщения должен быть равен 0, сообщение предваряется двухбайтовой длиной
+	query := msg.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	frame := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(frame, uint16(len(packed)))
+	copy(frame[2:], packed)
+
+	start := time.Now()
+	dialCtx, dialCancel := context.WithTimeout(ctx, c.timeouts.Dial)
+	defer dialCancel()
+	conn, err := quic.DialAddr(dialCtx, address, c.tlsConfig, &quic.Config{HandshakeIdleTimeout: c.timeouts.Dial})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.CloseWithError(0, "")
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	if _, err := stream.Write(frame); err != nil {
+		return nil, 0, err
+	}
+	// Закрываем поток на запись: сервер отвечает после получения конца потока
+	if err := stream.Close(); err != nil {
+		return nil, 0, err
+	}
+
+	var length uint16
+	if err := binary.Read(stream, binary.BigEndian, &length); err != nil {
+		return nil, 0, err
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(stream, body); err != nil {
+		return nil, 0, err
+	}
+	rtt := time.Since(start)
+
+	answer := new(dns.Msg)
+	if err := answer.Unpack(body); err != nil {
+		return nil, 0, fmt.Errorf("doq: %w", err)
+	}
+	answer.Id = msg.Id
+	return answer, rtt, nil
+}
@@ -0,0 +1,147 @@
+package pdns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// startFakeDohServer запускает DoH сервер с сертификатом от ca, отвечающий как fake.
+// Сервер принимает запросы только на /dns-query с параметром token=secret, а /redirect перенаправляет туда.
+func startFakeDohServer(t *testing.T, fake *fakeDNSServer, ca *testCA) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/dns-query?token=secret", http.StatusTemporaryRedirect)
+			return
+		}
+		if r.URL.Path != "/dns-query" || r.URL.Query().Get("token") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		var packed []byte
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			packed, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if r.Header.Get("Content-Type") != "application/dns-message" {
+				http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+				return
+			}
+			packed, err = io.ReadAll(r.Body)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		req := new(dns.Msg)
+		if err == nil {
+			err = req.Unpack(packed)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := fake.reply(req).Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(resp)
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{ca.issueServer(t)}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// startFakeDotServer запускает DoT сервер с сертификатом от ca, отвечающий как fake, и возвращает его порт
+func startFakeDotServer(t *testing.T, fake *fakeDNSServer, ca *testCA) int {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{ca.issueServer(t)}})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	var ready sync.WaitGroup
+	ready.Add(1)
+	server := &dns.Server{Listener: listener, Handler: dns.HandlerFunc(fake.serve), NotifyStartedFunc: ready.Done}
+	go server.ActivateAndServe()
+	ready.Wait()
+	t.Cleanup(func() { server.Shutdown() })
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// TestDohExchange проверяет запросы DoH методами GET и POST к URL, уже содержащему параметры,
+// и то, что перенаправления не выполняются
+func TestDohExchange(t *testing.T) {
+	fake := startFakeDNSServer(t, 0)
+	ca := newTestCA(t, "doh test CA")
+	server := startFakeDohServer(t, fake, ca)
+	caFile := ca.writePEM(t)
+	tests := []struct {
+		name       string
+		url        string
+		method     string
+		record     string
+		wantStatus ProbeStatus
+	}{
+		{"get", server.URL + "/dns-query?token=secret", http.MethodGet, fakeNameOK, ProbeStatusOK},
+		{"post", server.URL + "/dns-query?token=secret", http.MethodPost, fakeNameOK, ProbeStatusOK},
+		{"get servfail", server.URL + "/dns-query?token=secret", http.MethodGet, fakeNameServfail, ProbeStatusBadRcode},
+		{"get without token", server.URL + "/dns-query", http.MethodGet, fakeNameOK, ProbeStatusNetworkError},
+		{"redirect", server.URL + "/redirect", http.MethodPost, fakeNameOK, ProbeStatusNetworkError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := DNSTarget{ServerID: "doh", IP: "127.0.0.1", RequestedRecord: tt.record,
+				Transport: TransportDoH, TLSCAFile: caFile, DohURL: tt.url, DohMethod: tt.method}
+			result := DnsRequest(context.Background(), CreateDnsRequestData(target))
+			if result.Status != tt.wantStatus {
+				t.Fatalf("status %s (%s), want %s", result.Status, result.FailureDetail, tt.wantStatus)
+			}
+			if tt.wantStatus == ProbeStatusOK && len(result.Msg.Answer) != 1 {
+				t.Errorf("answer %v, want one A record", result.Msg.Answer)
+			}
+		})
+	}
+}
+
+// TestDotExchange проверяет запрос DoT и проверку сертификата сервера по tlsCAFile
+func TestDotExchange(t *testing.T) {
+	fake := startFakeDNSServer(t, 0)
+	ca := newTestCA(t, "dot test CA")
+	port := startFakeDotServer(t, fake, ca)
+	tests := []struct {
+		name       string
+		caFile     string
+		serverName string
+		wantStatus ProbeStatus
+	}{
+		{"trusted", ca.writePEM(t), "", ProbeStatusOK},
+		{"server name", ca.writePEM(t), "localhost", ProbeStatusOK},
+		{"wrong server name", ca.writePEM(t), "dns.example.org", ProbeStatusNetworkError},
+		{"untrusted", newTestCA(t, "other CA").writePEM(t), "", ProbeStatusNetworkError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := DNSTarget{ServerID: "dot", IP: "127.0.0.1", DNSPort: port, RequestedRecord: fakeNameOK,
+				Transport: TransportDoT, TLSCAFile: tt.caFile, TLSServerName: tt.serverName}
+			result := DnsRequest(context.Background(), CreateDnsRequestData(target))
+			if result.Status != tt.wantStatus {
+				t.Fatalf("status %s (%s), want %s", result.Status, result.FailureDetail, tt.wantStatus)
+			}
+			if tt.wantStatus == ProbeStatusOK && len(result.Msg.Answer) != 1 {
+				t.Errorf("answer %v, want one A record", result.Msg.Answer)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+package pdns
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ConfigError - ошибка в конфигурации с путем к полю и номером строки в файле
+type ConfigError struct {
+	File    string // Подключенный файл (include) или переменная окружения и флаг, в которых найдена ошибка; пустой для основного файла
+	Path    string // Путь к полю в формате JSON ("groupsDns[0].dnsServers[1].IP"), пустой для ошибки всего файла
+	Line    int    // Номер строки в файле (0, если неизвестен)
+	Message string // Описание ошибки
+}
+
+// Error возвращает ошибку в виде "line 12: groupsDns[0].dnsServers[1].IP: <описание>"
+// (для подключенного файла - "conf.d/ny.yaml: line 12: ...")
+func (e ConfigError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File + ": ")
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", e.Line)
+	}
+	if e.Path != "" {
+		b.WriteString(e.Path + ": ")
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// ConfigErrors - все ошибки, найденные при проверке конфигурации, по одной на строку
+type ConfigErrors []ConfigError
+
+// Error возвращает все ошибки, разделенные переводом строки
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, 0, len(errs))
+	for _, err := range errs {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// configCheck накапливает ошибки проверки конфигурации и определяет номера строк по путям к полям
+type configCheck struct {
+	lines     map[string]int    // Номер строки значения по пути к полю (0, если поле задано, но строка неизвестна)
+	source    []byte            // Исходный JSON документ для определения строки по смещению (nil для YAML и TOML)
+	origins   []groupOrigin     // Происхождение групп по индексу в итоговой конфигурации (для групп из подключенных файлов)
+	path      string            // Путь к основному файлу, если к нему подключены другие файлы (для ссылок между файлами)
+	overrides map[string]string // Источник значения по пути к параметру, переопределенному переменной окружения или флагом
+	errs      ConfigErrors      // Найденные ошибки
+}
+
+// groupOrigin - подключенный файл, из которого взята группа, и индекс группы в этом файле
+type groupOrigin struct {
+	file  string       // Путь к подключенному файлу (пустой для групп основного файла)
+	index int          // Индекс группы в groupsDns подключенного файла
+	check *configCheck // Номера строк подключенного файла
+}
+
+// parse разбирает содержимое файла data в формате format и запоминает номера строк полей.
+// Возвращает JSON документ для decodeConfig; ошибки разбора YAML и TOML добавляются в c.
+func (c *configCheck) parse(data []byte, format string) []byte {
+	switch format {
+	case ConfigFormatYAML, ConfigFormatTOML:
+		data, c.lines = toJSON(data, format, c)
+	default:
+		c.lines, c.source = jsonLines(data), data
+	}
+	return data
+}
+
+// offsetLine возвращает номер строки смещения offset в исходном JSON документе (0 для YAML и TOML)
+func (c *configCheck) offsetLine(offset int64) int {
+	if c.source == nil {
+		return 0
+	}
+	return lineAt(c.source, offset)
+}
+
+// addf добавляет ошибку поля path
+func (c *configCheck) addf(path, format string, args ...any) {
+	configErr := c.locate(path)
+	configErr.Message = fmt.Sprintf(format, args...)
+	c.errs = append(c.errs, configErr)
+}
+
+// groupPathRe выделяет индекс группы из пути к полю ("groupsDns[3].dnsServers[0].IP")
+var groupPathRe = regexp.MustCompile(`^groupsDns\[(\d+)\]`)
+
+// locate возвращает файл, путь к полю в этом файле и номер строки для поля path итоговой конфигурации.
+// Поля групп из подключенных файлов указываются в подключенном файле с индексом группы в нем,
+// а переопределенные параметры - переменной окружения или флагом без номера строки.
+func (c *configCheck) locate(path string) ConfigError {
+	for overridden, source := range c.overrides {
+		if path == overridden || strings.HasPrefix(path, overridden+".") || strings.HasPrefix(path, overridden+"[") {
+			return ConfigError{File: source, Path: path}
+		}
+	}
+	if match := groupPathRe.FindStringSubmatch(path); match != nil {
+		i, _ := strconv.Atoi(match[1])
+		if i < len(c.origins) && c.origins[i].file != "" {
+			origin := c.origins[i]
+			local := indexPath("groupsDns", origin.index) + path[len(match[0]):]
+			return ConfigError{File: origin.file, Path: local, Line: origin.check.line(local)}
+		}
+	}
+	return ConfigError{Path: path, Line: c.line(path)}
+}
+
+// where возвращает поле path итоговой конфигурации для сообщений об ошибках. Если подключены другие файлы,
+// поле указывается с именем файла, в котором оно задано ("conf.d/ny.yaml: groupsDns[0]").
+func (c *configCheck) where(path string) string {
+	location := c.locate(path)
+	if location.File == "" {
+		location.File = c.path
+	}
+	if location.File != "" {
+		return location.File + ": " + location.Path
+	}
+	return location.Path
+}
+
+// add добавляет ошибку err поля path
+func (c *configCheck) add(path string, err error) {
+	c.addf(path, "%s", err)
+}
+
+// present проверяет, задано ли поле path в файле явно
+func (c *configCheck) present(path string) bool {
+	_, ok := c.lines[path]
+	return ok
+}
+
+// line возвращает номер строки поля path. Если поле в файле не задано (например, пропущено
+// обязательное поле), возвращается строка ближайшего заданного родителя.
+func (c *configCheck) line(path string) int {
+	for path != "" {
+		if line, ok := c.lines[path]; ok && line > 0 {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+// err возвращает найденные ошибки, отсортированные по файлу (сначала основной) и номеру строки,
+// или nil, если ошибок нет
+func (c *configCheck) err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(c.errs, func(i, j int) bool {
+		if c.errs[i].File != c.errs[j].File {
+			return c.errs[i].File < c.errs[j].File
+		}
+		return c.errs[i].Line < c.errs[j].Line
+	})
+	return c.errs
+}
+
+// fieldPath формирует путь к полю path.name
+func fieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// indexPath формирует путь к элементу массива path[i]
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// jsonLines разбирает JSON документ data и возвращает номер строки каждого значения по пути к нему.
+// Ключи объектов записываются через точку ("web.listeners[0].address"), в том числе ключи карт.
+// Для некорректного документа возвращаются строки, разобранные до ошибки.
+func jsonLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	newlines := make([]int, 0, bytes.Count(data, []byte("\n")))
+	for i, ch := range data {
+		if ch == '\n' {
+			newlines = append(newlines, i)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var walk func(path string) error
+	walk = func(path string) error {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		// Строка значения - строка, на которой оно закончилось (для объекта и массива - открывающая скобка)
+		lines[path] = sort.SearchInts(newlines, int(dec.InputOffset())) + 1
+		switch token {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if err := walk(fieldPath(path, key.(string))); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(indexPath(path, i)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	walk("")
+	delete(lines, "")
+	return lines
+}
+
+// lineAt возвращает номер строки смещения offset в data
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// arrayIndexRe - индекс массива в пути поля из ошибки json ("groupsDns.0.IP"), который записывается в скобках
+var arrayIndexRe = regexp.MustCompile(`\.(\d+)\b`)
+
+// unknownFieldRe выделяет имя поля из ошибки json о неизвестном поле
+var unknownFieldRe = regexp.MustCompile(`^json: unknown field "(.*)"$`)
+
+// decodeConfig разбирает JSON документ data в conf (Config или ConfigInclude). Неизвестные поля считаются ошибкой,
+// чтобы опечатки в именах полей не приводили к молчаливому использованию значений по умолчанию.
+// Ошибки разбора возвращаются с номером строки; для YAML и TOML номер строки определяется по пути к полю.
+func decodeConfig(data []byte, conf any, check *configCheck) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(conf)
+	if err == nil {
+		// После конфигурации в файле не должно быть других значений
+		if _, err := dec.Token(); err != io.EOF {
+			check.errs = append(check.errs, ConfigError{Line: check.offsetLine(dec.InputOffset()), Message: "unexpected data after the end of the configuration"})
+		}
+		return
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		check.errs = append(check.errs, ConfigError{Line: check.offsetLine(syntaxErr.Offset), Message: "invalid JSON: " + syntaxErr.Error()})
+	case errors.As(err, &typeErr):
+		path := arrayIndexRe.ReplaceAllString(typeErr.Field, "[$1]")
+		line := check.offsetLine(typeErr.Offset)
+		if line == 0 {
+			line = check.line(path)
+		}
+		check.errs = append(check.errs, ConfigError{
+			Path:    path,
+			Line:    line,
+			Message: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value),
+		})
+	case errors.Is(err, io.EOF):
+		check.errs = append(check.errs, ConfigError{Message: "configuration file is empty"})
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		check.errs = append(check.errs, ConfigError{Line: check.offsetLine(int64(len(data))), Message: "invalid JSON: unexpected end of file"})
+	default:
+		// Неизвестное поле: ищем в файле первое поле с таким именем, чтобы указать путь и строку
+		if match := unknownFieldRe.FindStringSubmatch(err.Error()); match != nil {
+			for _, path := range check.sortedPaths() {
+				if path == match[1] || strings.HasSuffix(path, "."+match[1]) {
+					check.addf(path, "unknown field %q", match[1])
+					return
+				}
+			}
+		}
+		check.errs = append(check.errs, ConfigError{Message: err.Error()})
+	}
+}
+
+// sortedPaths возвращает пути всех полей файла в порядке их следования
+func (c *configCheck) sortedPaths() []string {
+	paths := make([]string, 0, len(c.lines))
+	for path := range c.lines {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if c.lines[paths[i]] != c.lines[paths[j]] {
+			return c.lines[paths[i]] < c.lines[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	return paths
+}
+
+// configValidator - валидатор тегов `validate` структур конфигурации.
+// Поля называются по их именам в JSON, дополнительно зарегистрированы теги host (IP адрес или имя хоста)
+// и dnsname (имя для DNS запроса).
+var configValidator = newConfigValidator()
+
+// newConfigValidator создает валидатор тегов структур конфигурации
+func newConfigValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		return name
+	})
+	validate.RegisterValidation("host", func(fl validator.FieldLevel) bool {
+		return validHost(fl.Field().String())
+	})
+	validate.RegisterValidation("dnsname", func(fl validator.FieldLevel) bool {
+		return validDomainName(fl.Field().String(), true)
+	})
+	return validate
+}
+
+// validHost проверяет, что value - IP адрес или имя хоста (RFC 1123).
+// Значение только из цифр и точек считается IP адресом, поэтому "999.1.1.1" и "1.2.3.4.5" не принимаются как имена.
+func validHost(value string) bool {
+	if _, err := netip.ParseAddr(value); err == nil {
+		return true
+	}
+	if strings.Trim(value, "0123456789.") == "" {
+		return false
+	}
+	return validDomainName(value, false)
+}
+
+// validDomainName проверяет синтаксис доменного имени: метки из букв, цифр и дефисов
+// (и "_", если underscore, как в "_sip._tcp.example.com" для SRV), дефис не в начале и не в конце метки,
+// длина метки до 63 символов, имени - до 253 символов без завершающей точки. Корень "." допустим.
+func validDomainName(name string, underscore bool) bool {
+	if name == "." {
+		return true
+	}
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range []byte(label) {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+			case c == '_' && underscore:
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// jsonName возвращает имя поля структуры конфигурации в JSON по имени поля в Go
+// (имена полей конфигурации в JSON отличаются регистром первой буквы)
+func jsonName(field string) string {
+	if field == "" {
+		return field
+	}
+	return strings.ToLower(field[:1]) + field[1:]
+}
+
+// mapKeyRe - ключ карты в пути validator ("probeModules[auth]"), который записывается через точку
+var mapKeyRe = regexp.MustCompile(`\[([^\]]*[^0-9\]][^\]]*)\]`)
+
+// validateTags проверяет теги `validate` структуры конфигурации conf (Config или ConfigInclude)
+// и добавляет ошибки в check
+func validateTags(conf any, check *configCheck) {
+	err := configValidator.Struct(conf)
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		if err != nil {
+			check.errs = append(check.errs, ConfigError{Message: err.Error()})
+		}
+		return
+	}
+	for _, fieldErr := range errs {
+		// Namespace начинается с имени структуры ("Config.groupsDns[0].IP")
+		_, path, _ := strings.Cut(fieldErr.Namespace(), ".")
+		path = mapKeyRe.ReplaceAllString(path, ".$1")
+		check.addf(path, "%s", tagMessage(fieldErr))
+	}
+}
+
+// tagMessage возвращает понятное описание ошибки проверки тега
+func tagMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required", "required_without":
+		return "is required"
+	case "required_if":
+		// Параметр тега - имя поля структуры и значение ("Enabled true")
+		field, value, _ := strings.Cut(fieldErr.Param(), " ")
+		return fmt.Sprintf("is required when %s is %s", jsonName(field), value)
+	case "required_with":
+		return fmt.Sprintf("is required when %s is set", jsonName(fieldErr.Param()))
+	case "min", "gte":
+		if fieldErr.Kind() == reflect.Slice || fieldErr.Kind() == reflect.Map {
+			return fmt.Sprintf("must contain at least %s item(s)", fieldErr.Param())
+		}
+		return fmt.Sprintf("must be at least %s, got %v", fieldErr.Param(), fieldErr.Value())
+	case "max", "lte":
+		return fmt.Sprintf("must be at most %s, got %v", fieldErr.Param(), fieldErr.Value())
+	case "oneof":
+		return fmt.Sprintf("must be one of %s, got %q", strings.ReplaceAll(fieldErr.Param(), " ", ", "), fieldErr.Value())
+	case "host":
+		return fmt.Sprintf("%q is not a valid IP address or hostname", fieldErr.Value())
+	case "dnsname":
+		return fmt.Sprintf("%q is not a valid domain name", fieldErr.Value())
+	case "file":
+		return fmt.Sprintf("file %q does not exist", fieldErr.Value())
+	}
+	return fmt.Sprintf("failed %q check", fieldErr.Tag())
+}
@@ -0,0 +1,168 @@
+package pdns
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestValidHost проверяет адреса DNS серверов: IP адреса и имена хостов
+func TestValidHost(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"192.0.2.1", true},
+		{"2001:db8::53", true},
+		{"::1", true},
+		{"ns1.example.com", true},
+		{"ns1.example.com.", true},
+		{"localhost", true},
+		{"1e100.net", true},
+		{"999.1.1.1", false},
+		{"256.0.0.1", false},
+		{"1.2.3.4.5", false},
+		{"1.2.3", false},
+		{"10", false},
+		{"", false},
+		{"_dns.example.com", false},
+		{"exa mple.com", false},
+		{"-ns.example.com", false},
+		{"ns..example.com", false},
+		{"2001:db8::zz", false},
+	}
+	for _, tt := range tests {
+		if got := validHost(tt.value); got != tt.want {
+			t.Errorf("validHost(%q) = %t, want %t", tt.value, got, tt.want)
+		}
+	}
+}
+
+// TestValidDomainName проверяет синтаксис запрашиваемых имен
+func TestValidDomainName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"example.com", true},
+		{"example.com.", true},
+		{".", true},
+		{"com", true},
+		{"_sip._tcp.example.com", true},
+		{"1.2.0.192.in-addr.arpa.", true},
+		{"xn--e1afmkfd.xn--p1ai", true},
+		{"a-b.example", true},
+		{strings.Repeat("a", 63) + ".com", true},
+		{strings.Repeat("a", 64) + ".com", false},
+		{strings.Repeat("abcdefg.", 31) + "abcd", true}, // 252 символа
+		{strings.Repeat("abcdefg.", 31) + "abcdef", false},
+		{"", false},
+		{"..", false},
+		{"example..com", false},
+		{".example.com", false},
+		{"exa mple.com", false},
+		{"a@b", false},
+		{"-x.com", false},
+		{"x-.com", false},
+		{"example.com;rm", false},
+		{"пример.рф", false},
+		{`ex\.ample.com`, false},
+	}
+	for _, tt := range tests {
+		if got := validDomainName(tt.name, true); got != tt.want {
+			t.Errorf("validDomainName(%q) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+	if validDomainName("_sip._tcp.example.com", false) {
+		t.Error("underscore accepted in a hostname")
+	}
+}
+
+// TestParseConfigErrors проверяет, что ошибки конфигурации указывают путь к полю во всех форматах
+// и строку в JSON и YAML
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+		want   []ConfigError
+	}{
+		{
+			name:   "json",
+			format: ConfigFormatJSON,
+			data: `{
+  "groupsDns": [
+    {
+      "groupName": "g",
+      "dnsServers": [
+        {"serverID": "s1", "IP": "999.1.1.1", "requestedRecord": "example.com"},
+        {"serverID": "s2", "IP": "192.0.2.2", "requestedRecord": "example.com;rm"}
+      ]
+    }
+  ]
+}`,
+			want: []ConfigError{
+				{Path: "groupsDns[0].dnsServers[0].IP", Line: 6, Message: `"999.1.1.1" is not a valid IP address or hostname`},
+				{Path: "groupsDns[0].dnsServers[1].requestedRecord", Line: 7, Message: `"example.com;rm" is not a valid domain name`},
+			},
+		},
+		{
+			name:   "yaml",
+			format: ConfigFormatYAML,
+			data: `groupsDns:
+  - groupName: g
+    dnsServers:
+      - serverID: s1
+        IP: 192.0.2.1
+        requestedRecord: "-x.com"
+      - serverID: s2
+        IP: 1.2.3.4.5
+        requestedRecord: example.com
+`,
+			want: []ConfigError{
+				{Path: "groupsDns[0].dnsServers[0].requestedRecord", Line: 6, Message: `"-x.com" is not a valid domain name`},
+				{Path: "groupsDns[0].dnsServers[1].IP", Line: 8, Message: `"1.2.3.4.5" is not a valid IP address or hostname`},
+			},
+		},
+		{
+			name:   "toml",
+			format: ConfigFormatTOML,
+			data: `[[groupsDns]]
+groupName = "g"
+
+[[groupsDns.dnsServers]]
+serverID = "s1"
+IP = "256.0.0.1"
+requestedRecord = "example.com"
+`,
+			want: []ConfigError{
+				// Для TOML номера строк значений неизвестны
+				{Path: "groupsDns[0].dnsServers[0].IP", Message: `"256.0.0.1" is not a valid IP address or hostname`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig("config."+tt.format, []byte(tt.data), tt.format, nil)
+			var errs ConfigErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("error %v is not ConfigErrors", err)
+			}
+			for _, want := range tt.want {
+				if !containsConfigError(errs, want) {
+					t.Errorf("missing error %q in:\n%v", want.Error(), errs)
+				}
+			}
+		})
+	}
+}
+
+// containsConfigError проверяет, есть ли среди errs ошибка want
+func containsConfigError(errs ConfigErrors, want ConfigError) bool {
+	for _, err := range errs {
+		if err == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+// Package auth - аутентификация HTTP запросов подключаемыми способами: клиентский сертификат (mTLS),
+// bearer токен, HTTP basic с паролями в bcrypt и список разрешенных сетей.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Способы аутентификации (Identity.Method)
+const (
+	MethodCertificate = "mtls"
+	MethodBearer      = "bearer"
+	MethodBasic       = "basic"
+	MethodNetwork     = "ip"
+)
+
+// Ошибки аутентификации
+var (
+	ErrNoCredentials = errors.New("no credentials")       // Запрос не содержит учетных данных этого способа
+	ErrDenied        = errors.New("credentials rejected") // Учетные данные не подходят
+)
+
+// Identity - аутентифицированный клиент
+type Identity struct {
+	Method string // Способ аутентификации
+	Name   string // Имя клиента: CN или SAN сертификата, имя токена, имя пользователя или адрес
+}
+
+// Authenticator - один способ аутентификации
+type Authenticator interface {
+	// Authenticate проверяет запрос и возвращает клиента. Если запрос не содержит учетных данных
+	// этого способа, возвращает ErrNoCredentials, если они не подходят - ошибку, оборачивающую ErrDenied.
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// Policy - способы аутентификации группы обработчиков.
+// Запрос разрешен, если его принял любой из способов, а при RequireAll - все способы
+// (например, токен и адрес из разрешенной сети). Политика без способов отклоняет все запросы,
+// а nil политика означает, что аутентификация не требуется.
+// Policy сама реализует Authenticator, поэтому политики можно вкладывать друг в друга.
+type Policy struct {
+	Authenticators []Authenticator // Способы аутентификации в порядке проверки
+	RequireAll     bool            // Требовать успешной проверки всеми способами
+}
+
+// Authenticate проверяет запрос по политике. При RequireAll возвращается клиент первого способа.
+// Ошибка оборачивает ErrNoCredentials, если ни один способ не нашел в запросе учетных данных, иначе ErrDenied.
+func (p *Policy) Authenticate(r *http.Request) (Identity, error) {
+	if p == nil {
+		return Identity{}, nil
+	}
+	if len(p.Authenticators) == 0 {
+		return Identity{}, fmt.Errorf("%w: no authentication methods configured", ErrDenied)
+	}
+	var first Identity
+	var failures []error
+	for i, authenticator := range p.Authenticators {
+		identity, err := authenticator.Authenticate(r)
+		switch {
+		case err == nil && !p.RequireAll:
+			return identity, nil
+		case err == nil && i == 0:
+			first = identity
+		case err != nil && p.RequireAll:
+			return Identity{}, err
+		case err != nil:
+			failures = append(failures, err)
+		}
+	}
+	if p.RequireAll {
+		return first, nil
+	}
+	var denied []error
+	for _, err := range failures {
+		if !errors.Is(err, ErrNoCredentials) {
+			denied = append(denied, err)
+		}
+	}
+	if len(denied) > 0 {
+		return Identity{}, errors.Join(denied...)
+	}
+	return Identity{}, ErrNoCredentials
+}
+
+// challenges возвращает заголовки WWW-Authenticate способов политики (в том числе вложенных политик),
+// запрашивающих учетные данные у клиента
+func (p *Policy) challenges() []string {
+	if p == nil {
+		return nil
+	}
+	var challenges []string
+	for _, authenticator := range p.Authenticators {
+		switch authenticator := authenticator.(type) {
+		case *Policy:
+			challenges = append(challenges, authenticator.challenges()...)
+		case interface{ challenge() string }:
+			challenges = append(challenges, authenticator.challenge())
+		}
+	}
+	return challenges
+}
+
+// identityKey - ключ клиента в контексте запроса
+type identityKey struct{}
+
+// IdentityFrom возвращает клиента, аутентифицированного Handler
+func IdentityFrom(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok && identity.Method != ""
+}
+
+// Handler - middleware аутентификации. Политика запроса выбирается функцией route
+// (nil - без аутентификации, политика без способов - запрос отклоняется с кодом 403).
+// Аутентифицированный клиент сохраняется в контексте запроса (см. IdentityFrom).
+// Без учетных данных возвращается 401 с заголовком WWW-Authenticate, с неподходящими - 403.
+func Handler(next http.Handler, route func(r *http.Request) *Policy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := route(r)
+		identity, err := policy.Authenticate(r)
+		if err == nil {
+			if identity.Method != "" {
+				slog.Debug("Authentication successful", slog.String("method", identity.Method), slog.String("client", identity.Name), slog.String("remoteAddr", r.RemoteAddr), slog.String("path", r.URL.Path))
+				r = r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		slog.Warn("Authentication failed", slog.String("remoteAddr", r.RemoteAddr), slog.String("path", r.URL.Path), slog.String("error", err.Error()))
+		status, message := http.StatusForbidden, "access denied"
+		if challenges := policy.challenges(); errors.Is(err, ErrNoCredentials) && len(challenges) > 0 {
+			status, message = http.StatusUnauthorized, "authentication required"
+			for _, challenge := range challenges {
+				w.Header().Add("WWW-Authenticate", challenge)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"message": message})
+	})
+}
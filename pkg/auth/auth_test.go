@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubAuthenticator - способ аутентификации с заранее заданным результатом
+type stubAuthenticator struct {
+	identity Identity
+	err      error
+}
+
+// Authenticate реализует Authenticator
+func (s stubAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	return s.identity, s.err
+}
+
+// TestPolicy проверяет сочетание способов в режимах any и all, пустую и nil политику
+func TestPolicy(t *testing.T) {
+	alice := stubAuthenticator{identity: Identity{Method: MethodBearer, Name: "alice"}}
+	bob := stubAuthenticator{identity: Identity{Method: MethodNetwork, Name: "bob"}}
+	missing := stubAuthenticator{err: ErrNoCredentials}
+	denied := stubAuthenticator{err: ErrDenied}
+	tests := []struct {
+		name     string
+		policy   *Policy
+		wantName string
+		wantErr  error
+	}{
+		{name: "nil policy", policy: nil},
+		{name: "no authenticators", policy: &Policy{}, wantErr: ErrDenied},
+		{name: "no authenticators, all", policy: &Policy{RequireAll: true}, wantErr: ErrDenied},
+		{name: "any: first", policy: &Policy{Authenticators: []Authenticator{alice, bob}}, wantName: "alice"},
+		{name: "any: after missing", policy: &Policy{Authenticators: []Authenticator{missing, bob}}, wantName: "bob"},
+		{name: "any: after denied", policy: &Policy{Authenticators: []Authenticator{denied, bob}}, wantName: "bob"},
+		{name: "any: all missing", policy: &Policy{Authenticators: []Authenticator{missing, missing}}, wantErr: ErrNoCredentials},
+		{name: "any: denied wins over missing", policy: &Policy{Authenticators: []Authenticator{missing, denied}}, wantErr: ErrDenied},
+		{name: "all: first identity", policy: &Policy{RequireAll: true, Authenticators: []Authenticator{alice, bob}}, wantName: "alice"},
+		{name: "all: one missing", policy: &Policy{RequireAll: true, Authenticators: []Authenticator{alice, missing}}, wantErr: ErrNoCredentials},
+		{name: "all: one denied", policy: &Policy{RequireAll: true, Authenticators: []Authenticator{denied, bob}}, wantErr: ErrDenied},
+		{name: "nested", policy: &Policy{RequireAll: true, Authenticators: []Authenticator{&Policy{Authenticators: []Authenticator{missing, bob}}, alice}}, wantName: "bob"},
+		{name: "nested empty", policy: &Policy{RequireAll: true, Authenticators: []Authenticator{&Policy{}, alice}}, wantErr: ErrDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := tt.policy.Authenticate(httptest.NewRequest("GET", "/", nil))
+			switch {
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("error %v, want %v", err, tt.wantErr)
+			case tt.wantErr == nil && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case identity.Name != tt.wantName:
+				t.Errorf("identity %+v, want %q", identity, tt.wantName)
+			}
+		})
+	}
+}
+
+// TestHandler проверяет коды ответа middleware и передачу клиента обработчику
+func TestHandler(t *testing.T) {
+	bearer := &Bearer{}
+	bearer.AddToken("grafana", "secret")
+	network := &Network{}
+	policies := map[string]*Policy{
+		"/open":   nil,
+		"/closed": {},
+		"/token":  {Authenticators: []Authenticator{bearer}},
+		"/nested": {RequireAll: true, Authenticators: []Authenticator{&Policy{Authenticators: []Authenticator{bearer}}, &Certificate{}}},
+		"/ip":     {Authenticators: []Authenticator{network}},
+	}
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := IdentityFrom(r.Context())
+		w.Write([]byte(identity.Name))
+	}), func(r *http.Request) *Policy {
+		return policies[r.URL.Path]
+	})
+	tests := []struct {
+		path          string
+		token         string
+		wantStatus    int
+		wantChallenge bool
+		wantBody      string
+	}{
+		{path: "/open", wantStatus: http.StatusOK},
+		{path: "/closed", wantStatus: http.StatusForbidden},
+		{path: "/closed", token: "secret", wantStatus: http.StatusForbidden},
+		{path: "/token", wantStatus: http.StatusUnauthorized, wantChallenge: true},
+		{path: "/token", token: "wrong", wantStatus: http.StatusForbidden},
+		{path: "/token", token: "secret", wantStatus: http.StatusOK, wantBody: "grafana"},
+		{path: "/nested", wantStatus: http.StatusUnauthorized, wantChallenge: true},
+		{path: "/ip", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s (token %q): status %d, want %d", tt.path, tt.token, w.Code, tt.wantStatus)
+		}
+		if challenge := w.Header().Get("WWW-Authenticate"); (challenge != "") != tt.wantChallenge {
+			t.Errorf("%s (token %q): WWW-Authenticate %q", tt.path, tt.token, challenge)
+		}
+		if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+			t.Errorf("%s: identity %q, want %q", tt.path, w.Body.String(), tt.wantBody)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Certificate - аутентификация по клиентскому сертификату, проверенному при установке mTLS соединения.
+// Сертификат принимается, если совпадает любое из условий: CN, DNS имя, URI или email из SAN либо
+// отпечаток SHA-256. Если условия не заданы, принимается любой проверенный сертификат.
+type Certificate struct {
+	CommonNames  []string   // Разрешенные CN
+	DNSNames     []string   // Разрешенные DNS имена из SAN
+	URIs         []string   // Разрешенные URI из SAN (например, SPIFFE ID)
+	Emails       []string   // Разрешенные email из SAN
+	Fingerprints [][32]byte // Разрешенные отпечатки SHA-256 сертификата
+}
+
+// Authenticate реализует Authenticator
+func (c *Certificate) Authenticate(r *http.Request) (Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return Identity{}, ErrNoCredentials
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	identity := Identity{Method: MethodCertificate, Name: certificateName(cert)}
+	if len(c.CommonNames)+len(c.DNSNames)+len(c.URIs)+len(c.Emails)+len(c.Fingerprints) == 0 {
+		return identity, nil
+	}
+	if slices.Contains(c.CommonNames, cert.Subject.CommonName) ||
+		containsAny(c.DNSNames, cert.DNSNames) ||
+		containsAny(c.Emails, cert.EmailAddresses) ||
+		slices.Contains(c.Fingerprints, sha256.Sum256(cert.Raw)) {
+		return identity, nil
+	}
+	for _, uri := range cert.URIs {
+		if slices.Contains(c.URIs, uri.String()) {
+			return identity, nil
+		}
+	}
+	return Identity{}, fmt.Errorf("%w: certificate %q is not allowed", ErrDenied, identity.Name)
+}
+
+// certificateName возвращает имя клиента сертификата: CN, без него первое имя из SAN, иначе отпечаток SHA-256
+func certificateName(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// containsAny проверяет, есть ли в allowed хотя бы одно значение из values
+func containsAny(allowed, values []string) bool {
+	for _, value := range values {
+		if slices.Contains(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFingerprint разбирает отпечаток SHA-256 сертификата в шестнадцатеричном виде,
+// допускаются разделители ":" ("AB:CD:..." как в выводе openssl x509 -fingerprint -sha256)
+func ParseFingerprint(value string) ([32]byte, error) {
+	var fingerprint [32]byte
+	raw, err := hex.DecodeString(strings.ReplaceAll(value, ":", ""))
+	if err != nil || len(raw) != len(fingerprint) {
+		return fingerprint, fmt.Errorf("invalid SHA-256 fingerprint %q: expected 64 hex digits", value)
+	}
+	copy(fingerprint[:], raw)
+	return fingerprint, nil
+}
+
+// Bearer - аутентификация по токену из заголовка "Authorization: Bearer <token>".
+// Токены хранятся в виде SHA-256 и сравниваются за постоянное время.
+type Bearer struct {
+	names  []string   // Имена токенов (имя клиента)
+	hashes [][32]byte // SHA-256 токенов
+}
+
+// AddToken добавляет токен token с именем клиента name
+func (b *Bearer) AddToken(name, token string) {
+	b.names = append(b.names, name)
+	b.hashes = append(b.hashes, sha256.Sum256([]byte(token)))
+}
+
+// Authenticate реализует Authenticator
+func (b *Bearer) Authenticate(r *http.Request) (Identity, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Identity{}, ErrNoCredentials
+	}
+	hash := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	match := -1
+	for i := range b.hashes {
+		if subtle.ConstantTimeCompare(hash[:], b.hashes[i][:]) == 1 {
+			match = i
+		}
+	}
+	if match < 0 {
+		return Identity{}, fmt.Errorf("%w: unknown bearer token", ErrDenied)
+	}
+	return Identity{Method: MethodBearer, Name: b.names[match]}, nil
+}
+
+// challenge возвращает заголовок WWW-Authenticate для запроса без токена
+func (b *Bearer) challenge() string {
+	return `Bearer realm="dns-group-monitor"`
+}
+
+// dummyHash - хэш bcrypt, с которым сравнивается пароль неизвестного пользователя,
+// чтобы время ответа не выдавало существование пользователя
+const dummyHash = "$2a$10$fNA6sjBRCr2TQHogXCEKM.ZmxymJet6gSHg2rvLnJU0RHA8uM8A/u"
+
+// Basic - аутентификация HTTP basic по имени пользователя и паролю, хэшированному bcrypt
+type Basic struct {
+	users map[string][]byte // Хэши паролей по имени пользователя
+}
+
+// AddUser добавляет пользователя username с хэшем пароля bcrypt hash ("$2a$10$...", см. htpasswd -B)
+func (b *Basic) AddUser(username, hash string) error {
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return fmt.Errorf("user %q: invalid bcrypt hash: %w", username, err)
+	}
+	if b.users == nil {
+		b.users = make(map[string][]byte)
+	}
+	b.users[username] = []byte(hash)
+	return nil
+}
+
+// Authenticate реализует Authenticator
+func (b *Basic) Authenticate(r *http.Request) (Identity, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return Identity{}, ErrNoCredentials
+	}
+	hash, known := b.users[username]
+	if !known {
+		hash = []byte(dummyHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !known {
+		return Identity{}, fmt.Errorf("%w: invalid username or password for %q", ErrDenied, username)
+	}
+	return Identity{Method: MethodBasic, Name: username}, nil
+}
+
+// challenge возвращает заголовок WWW-Authenticate для запроса без имени и пароля
+func (b *Basic) challenge() string {
+	return `Basic realm="dns-group-monitor", charset="UTF-8"`
+}
+
+// Network - аутентификация по адресу клиента из списка разрешенных сетей.
+// Используется адрес TCP соединения (заголовки X-Forwarded-For не учитываются);
+// запросы через unix сокет не имеют адреса и не принимаются.
+type Network struct {
+	Prefixes []netip.Prefix // Разрешенные сети
+}
+
+// ParseNetwork разбирает сеть в нотации CIDR ("10.0.0.0/8") или отдельный адрес ("192.0.2.1")
+func ParseNetwork(value string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid network %q: expected CIDR (10.0.0.0/8) or IP address", value)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Authenticate реализует Authenticator
+func (n *Network) Authenticate(r *http.Request) (Identity, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: client address %q is not an IP address", ErrDenied, r.RemoteAddr)
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: client address %q is not an IP address", ErrDenied, r.RemoteAddr)
+	}
+	addr = addr.Unmap()
+	for _, prefix := range n.Prefixes {
+		if prefix.Contains(addr) {
+			return Identity{Method: MethodNetwork, Name: addr.String()}, nil
+		}
+	}
+	return Identity{}, fmt.Errorf("%w: client address %s is not in the allowed networks", ErrDenied, addr)
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testCertificate - клиентский сертификат с CN, DNS именем, URI и email в SAN
+func testCertificate() *x509.Certificate {
+	uri, _ := url.Parse("spiffe://example.org/prometheus")
+	return &x509.Certificate{
+		Raw:            []byte("test certificate"),
+		Subject:        pkix.Name{CommonName: "prometheus"},
+		DNSNames:       []string{"prometheus.example.org"},
+		URIs:           []*url.URL{uri},
+		EmailAddresses: []string{"ops@example.org"},
+	}
+}
+
+// checkResult проверяет результат аутентификации: имя клиента name или ошибку, оборачивающую wantErr
+func checkResult(t *testing.T, identity Identity, err error, method, name string, wantErr error) {
+	t.Helper()
+	if wantErr != nil {
+		if !errors.Is(err, wantErr) {
+			t.Errorf("error %v, want %v", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if identity.Method != method || identity.Name != name {
+		t.Errorf("identity %+v, want %s %q", identity, method, name)
+	}
+}
+
+// TestCertificate проверяет условия для клиентского сертификата
+func TestCertificate(t *testing.T) {
+	cert := testCertificate()
+	tests := []struct {
+		name     string
+		auth     Certificate
+		noTLS    bool
+		wantName string
+		wantErr  error
+	}{
+		{name: "no conditions", auth: Certificate{}, wantName: "prometheus"},
+		{name: "common name", auth: Certificate{CommonNames: []string{"grafana", "prometheus"}}, wantName: "prometheus"},
+		{name: "dns name", auth: Certificate{DNSNames: []string{"prometheus.example.org"}}, wantName: "prometheus"},
+		{name: "uri", auth: Certificate{URIs: []string{"spiffe://example.org/prometheus"}}, wantName: "prometheus"},
+		{name: "email", auth: Certificate{Emails: []string{"ops@example.org"}}, wantName: "prometheus"},
+		{name: "fingerprint", auth: Certificate{Fingerprints: [][32]byte{sha256.Sum256(cert.Raw)}}, wantName: "prometheus"},
+		{name: "no match", auth: Certificate{CommonNames: []string{"grafana"}, URIs: []string{"spiffe://example.org/grafana"}}, wantErr: ErrDenied},
+		{name: "wrong fingerprint", auth: Certificate{Fingerprints: [][32]byte{sha256.Sum256([]byte("other"))}}, wantErr: ErrDenied},
+		{name: "no certificate", auth: Certificate{}, noTLS: true, wantErr: ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if !tt.noTLS {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			}
+			identity, err := tt.auth.Authenticate(r)
+			checkResult(t, identity, err, MethodCertificate, tt.wantName, tt.wantErr)
+		})
+	}
+}
+
+// TestParseFingerprint проверяет разбор отпечатков сертификатов с разделителями и без
+func TestParseFingerprint(t *testing.T) {
+	sum := sha256.Sum256([]byte("test certificate"))
+	plain := hex.EncodeToString(sum[:])
+	var pairs []string
+	for i := 0; i < len(plain); i += 2 {
+		pairs = append(pairs, strings.ToUpper(plain[i:i+2]))
+	}
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: plain},
+		{value: strings.Join(pairs, ":")}, // Вывод openssl x509 -fingerprint -sha256
+		{value: plain[:62], wantErr: true},
+		{value: plain + "00", wantErr: true},
+		{value: "zz" + plain[2:], wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFingerprint(tt.value)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != sum) {
+			t.Errorf("ParseFingerprint(%q) = %x, %v; want error %t", tt.value, got, err, tt.wantErr)
+		}
+	}
+}
+
+// TestBearer проверяет поиск клиента по токену
+func TestBearer(t *testing.T) {
+	bearer := &Bearer{}
+	bearer.AddToken("grafana", "secret-1")
+	bearer.AddToken("ci", "secret-2")
+	tests := []struct {
+		name     string
+		header   string
+		wantName string
+		wantErr  error
+	}{
+		{name: "first token", header: "Bearer secret-1", wantName: "grafana"},
+		{name: "second token", header: "Bearer secret-2", wantName: "ci"},
+		{name: "scheme case", header: "bearer secret-2", wantName: "ci"},
+		{name: "unknown token", header: "Bearer secret-3", wantErr: ErrDenied},
+		{name: "empty token", header: "Bearer ", wantErr: ErrDenied},
+		{name: "no header", wantErr: ErrNoCredentials},
+		{name: "basic scheme", header: "Basic b3BzOnB3", wantErr: ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/groups", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			identity, err := bearer.Authenticate(r)
+			checkResult(t, identity, err, MethodBearer, tt.wantName, tt.wantErr)
+		})
+	}
+}
+
+// TestBasic проверяет пароли пользователей HTTP basic
+func TestBasic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic := &Basic{}
+	if err := basic.AddUser("ops", string(hash)); err != nil {
+		t.Fatal(err)
+	}
+	if err := basic.AddUser("bad", "password"); err == nil {
+		t.Error("AddUser accepted a plain text password")
+	}
+	tests := []struct {
+		name               string
+		username, password string
+		noCredentials      bool
+		wantName           string
+		wantErr            error
+	}{
+		{name: "valid", username: "ops", password: "password", wantName: "ops"},
+		{name: "wrong password", username: "ops", password: "Password", wantErr: ErrDenied},
+		{name: "unknown user", username: "root", password: "password", wantErr: ErrDenied},
+		{name: "user without hash", username: "bad", password: "password", wantErr: ErrDenied},
+		{name: "no credentials", noCredentials: true, wantErr: ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/-/log-level", nil)
+			if !tt.noCredentials {
+				r.SetBasicAuth(tt.username, tt.password)
+			}
+			identity, err := basic.Authenticate(r)
+			checkResult(t, identity, err, MethodBasic, tt.wantName, tt.wantErr)
+		})
+	}
+}
+
+// TestNetwork проверяет адрес клиента по разрешенным сетям
+func TestNetwork(t *testing.T) {
+	network := &Network{}
+	for _, value := range []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"} {
+		prefix, err := ParseNetwork(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		network.Prefixes = append(network.Prefixes, prefix)
+	}
+	tests := []struct {
+		remoteAddr string
+		wantName   string
+		wantErr    error
+	}{
+		{remoteAddr: "10.1.2.3:40000", wantName: "10.1.2.3"},
+		{remoteAddr: "192.0.2.1:40000", wantName: "192.0.2.1"},
+		{remoteAddr: "[::ffff:10.0.0.1]:40000", wantName: "10.0.0.1"},
+		{remoteAddr: "[2001:db8::1]:40000", wantName: "2001:db8::1"},
+		{remoteAddr: "192.0.2.2:40000", wantErr: ErrDenied},
+		{remoteAddr: "[2001:db9::1]:40000", wantErr: ErrDenied},
+		{remoteAddr: "@", wantErr: ErrDenied}, // unix сокет
+	}
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			r.RemoteAddr = tt.remoteAddr
+			identity, err := network.Authenticate(r)
+			checkResult(t, identity, err, MethodNetwork, tt.wantName, tt.wantErr)
+		})
+	}
+}
+
+// TestParseNetwork проверяет разбор сетей и отдельных адресов
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    netip.Prefix
+		wantErr bool
+	}{
+		{value: "10.1.2.3/8", want: netip.MustParsePrefix("10.0.0.0/8")},
+		{value: "192.0.2.1", want: netip.MustParsePrefix("192.0.2.1/32")},
+		{value: "::1", want: netip.MustParsePrefix("::1/128")},
+		{value: "10.0.0.0/33", wantErr: true},
+		{value: "example.com", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseNetwork(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseNetwork(%q) = %v, %v; want %v, error %t", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}